@@ -0,0 +1,258 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const defaultBatchChunkSize = 100
+
+// ErrTxAborted is returned when `EXEC` is aborted because a watched key changed.
+var ErrTxAborted = errors.New("redis transaction aborted")
+
+// Result holds the reply of a command queued into a Batch. It is only filled
+// after the batch is executed.
+type Result struct {
+	reply interface{}
+	err   error
+}
+
+func (r *Result) Reply() (interface{}, error) {
+	return r.reply, r.err
+}
+
+func (r *Result) Err() error {
+	return r.err
+}
+
+func (r *Result) String() (string, error) {
+	return redis.String(r.reply, r.err)
+}
+
+func (r *Result) Bytes() ([]byte, error) {
+	return redis.Bytes(r.reply, r.err)
+}
+
+func (r *Result) Int() (int, error) {
+	return redis.Int(r.reply, r.err)
+}
+
+func (r *Result) Int64() (int64, error) {
+	return redis.Int64(r.reply, r.err)
+}
+
+func (r *Result) Float64() (float64, error) {
+	return redis.Float64(r.reply, r.err)
+}
+
+func (r *Result) Bool() (bool, error) {
+	return redis.Bool(r.reply, r.err)
+}
+
+func (r *Result) Strings() ([]string, error) {
+	return redis.Strings(r.reply, r.err)
+}
+
+func (r *Result) StringMap() (map[string]string, error) {
+	return redis.StringMap(r.reply, r.err)
+}
+
+func (r *Result) Values() ([]interface{}, error) {
+	return redis.Values(r.reply, r.err)
+}
+
+type batchCmd struct {
+	name   string
+	args   []interface{}
+	result *Result
+}
+
+// Usage:
+// Queue commands into a batch, every Queue returns a Result which is filled by
+// Exec. Commands are written with Send and flushed in chunks of ChunkSize, so
+// hundreds of commands only cost a few round trips.
+//
+// Example:
+// b := pRedis.NewBatch(pool)
+// name := b.Queue("GET", "user:1:name")
+// b.Queue("HSET", "user:1", "visited", 1)
+// if err := b.Exec(); err != nil {
+//     return err
+// }
+// s, err := name.String()
+
+type Batch struct {
+	Pool      *redis.Pool
+	ChunkSize int
+
+	cmds []*batchCmd
+}
+
+func NewBatch(pool *redis.Pool) *Batch {
+	return &Batch{Pool: pool, ChunkSize: defaultBatchChunkSize}
+}
+
+// Queue adds a command to the batch.
+func (b *Batch) Queue(cmd string, args ...interface{}) *Result {
+	c := &batchCmd{name: cmd, args: args, result: new(Result)}
+	b.cmds = append(b.cmds, c)
+	return c.result
+}
+
+// Len returns the number of queued commands.
+func (b *Batch) Len() int {
+	return len(b.cmds)
+}
+
+// Exec sends every queued command and fills their results. Errors replied by
+// redis are only set to the matching Result, the returned error is about the
+// connection. The batch is empty after Exec and can be reused.
+func (b *Batch) Exec() error {
+	if len(b.cmds) == 0 {
+		return nil
+	}
+	conn := b.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	return b.exec(conn)
+}
+
+// ExecTx is like Exec, but wraps queued commands with `MULTI`/`EXEC`, so they
+// are executed atomically.
+func (b *Batch) ExecTx() error {
+	if len(b.cmds) == 0 {
+		return nil
+	}
+	conn := b.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	return b.execTx(conn)
+}
+
+func (b *Batch) chunkSize() int {
+	if b.ChunkSize <= 0 {
+		return defaultBatchChunkSize
+	}
+	return b.ChunkSize
+}
+
+func (b *Batch) exec(conn redis.Conn) error {
+	cmds := b.cmds
+	b.cmds = nil
+	size := b.chunkSize()
+	for start := 0; start < len(cmds); start += size {
+		end := start + size
+		if end > len(cmds) {
+			end = len(cmds)
+		}
+		for _, c := range cmds[start:end] {
+			if err := conn.Send(c.name, c.args...); err != nil {
+				return errors.Wrap(err, "send batch failed")
+			}
+		}
+		if err := conn.Flush(); err != nil {
+			return errors.Wrap(err, "flush batch failed")
+		}
+		for _, c := range cmds[start:end] {
+			c.result.reply, c.result.err = conn.Receive()
+			if c.result.err != nil {
+				if _, ok := c.result.err.(redis.Error); !ok {
+					return errors.Wrap(c.result.err, "receive batch failed")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Batch) execTx(conn redis.Conn) error {
+	cmds := b.cmds
+	b.cmds = nil
+	size := b.chunkSize()
+	if err := conn.Send("MULTI"); err != nil {
+		return errors.Wrap(err, "send batch failed")
+	}
+	for i, c := range cmds {
+		if err := conn.Send(c.name, c.args...); err != nil {
+			return errors.Wrap(err, "send batch failed")
+		}
+		if (i+1)%size == 0 {
+			if err := conn.Flush(); err != nil {
+				return errors.Wrap(err, "flush batch failed")
+			}
+		}
+	}
+	reply, err := conn.Do("EXEC")
+	if err != nil {
+		return errors.Wrap(err, "exec transaction failed")
+	}
+	if reply == nil {
+		return ErrTxAborted
+	}
+	replies, err := redis.Values(reply, nil)
+	if err != nil {
+		return errors.Wrap(err, "exec transaction failed")
+	}
+	for i, c := range cmds {
+		if i >= len(replies) {
+			break
+		}
+		c.result.reply = replies[i]
+		if e, ok := replies[i].(redis.Error); ok {
+			c.result.reply, c.result.err = nil, e
+		}
+	}
+	return nil
+}
+
+// Transaction
+//
+// Run an optimistic transaction. keys are watched with `WATCH` before fn is
+// called, fn reads what it needs through conn and queues writes into tx, which
+// are executed by `MULTI`/`EXEC`. If a watched key changed in between, the
+// whole procedure is retried up to retries times, then ErrTxAborted is returned.
+//
+// Example:
+//
+//	err := pRedis.Transaction(pool, 3, []string{"stock"}, func(conn redis.Conn, tx *pRedis.Batch) error {
+//	    n, err := redis.Int(conn.Do("GET", "stock"))
+//	    if err != nil {
+//	        return err
+//	    }
+//	    tx.Queue("SET", "stock", n-1)
+//	    return nil
+//	})
+func Transaction(pool *redis.Pool, retries int, keys []string, fn func(conn redis.Conn, tx *Batch) error) error {
+	conn := pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	watchArgs := make([]interface{}, len(keys))
+	for i, k := range keys {
+		watchArgs[i] = k
+	}
+	for attempt := 0; attempt <= retries; attempt++ {
+		if len(watchArgs) > 0 {
+			if _, err := conn.Do("WATCH", watchArgs...); err != nil {
+				return errors.Wrap(err, "watch keys failed")
+			}
+		}
+		tx := &Batch{Pool: pool}
+		if err := fn(conn, tx); err != nil {
+			_, _ = conn.Do("UNWATCH")
+			return err
+		}
+		if tx.Len() == 0 {
+			_, err := conn.Do("UNWATCH")
+			return err
+		}
+		err := tx.execTx(conn)
+		if err != ErrTxAborted {
+			return err
+		}
+	}
+	return ErrTxAborted
+}
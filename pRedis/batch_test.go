@@ -0,0 +1,105 @@
+package pRedis_test
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"strconv"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	b := pRedis.NewBatch(pool)
+	b.ChunkSize = 3
+
+	incrs := make([]*pRedis.Result, 10)
+	for i := range incrs {
+		incrs[i] = b.Queue("INCR", "n")
+	}
+	b.Queue("HSET", "h", "f", "v")
+	wrong := b.Queue("INCR", "h")
+	get := b.Queue("GET", "missing")
+	if b.Len() != 13 {
+		t.Fatalf("Len = %d, want 13", b.Len())
+	}
+	if err := b.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, r := range incrs {
+		if n, err := r.Int(); err != nil || n != i+1 {
+			t.Fatalf("INCR #%d = %d, %v", i, n, err)
+		}
+	}
+	if _, ok := wrong.Err().(redis.Error); !ok {
+		t.Fatalf("INCR of a hash = %v, want a redis error", wrong.Err())
+	}
+	if _, err := get.String(); err != redis.ErrNil {
+		t.Fatalf("GET of a missing key = %v, want ErrNil", err)
+	}
+	if b.Len() != 0 {
+		t.Fatal("the batch is not empty after Exec")
+	}
+}
+
+func TestBatchExecTx(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	b := pRedis.NewBatch(pool)
+	set := b.Queue("SET", "k", "v")
+	get := b.Queue("GET", "k")
+	if err := b.ExecTx(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := set.String(); err != nil || s != "OK" {
+		t.Fatalf("SET = %q, %v", s, err)
+	}
+	if s, err := get.String(); err != nil || s != "v" {
+		t.Fatalf("GET = %q, %v", s, err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	other := pool.Get()
+	defer other.Close()
+	if _, err := other.Do("SET", "stock", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := 0
+	err := pRedis.Transaction(pool, 3, []string{"stock"}, func(conn redis.Conn, tx *pRedis.Batch) error {
+		attempts++
+		n, err := redis.Int(conn.Do("GET", "stock"))
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			// a concurrent write makes the first attempt abort
+			if _, err = other.Do("DECR", "stock"); err != nil {
+				return err
+			}
+		}
+		tx.Queue("SET", "stock", strconv.Itoa(n-1))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+	if n, _ := redis.Int(other.Do("GET", "stock")); n != 8 {
+		t.Fatalf("stock = %d, want 8", n)
+	}
+
+	err = pRedis.Transaction(pool, 1, []string{"stock"}, func(conn redis.Conn, tx *pRedis.Batch) error {
+		if _, err := other.Do("INCR", "stock"); err != nil {
+			return err
+		}
+		tx.Queue("SET", "stock", "0")
+		return nil
+	})
+	if err != pRedis.ErrTxAborted {
+		t.Fatalf("Transaction with conflicts only = %v, want ErrTxAborted", err)
+	}
+}
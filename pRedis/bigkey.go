@@ -0,0 +1,177 @@
+package pRedis
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"sort"
+	"time"
+)
+
+const (
+	defaultBigKeyTopN  = 20
+	bigKeyInspectBatch = 100
+)
+
+// lengthCommands give the number of elements of a key by its type.
+var lengthCommands = map[string]string{
+	"string": "STRLEN",
+	"hash":   "HLEN",
+	"list":   "LLEN",
+	"set":    "SCARD",
+	"zset":   "ZCARD",
+	"stream": "XLEN",
+}
+
+type BigKeyOptions struct {
+	// Match, Count and Interval are passed to Scan, use Interval to limit the
+	// load on a production server.
+	Match    string
+	Count    int
+	Interval time.Duration
+	// TopN is the number of biggest keys reported, 20 by default.
+	TopN int
+	// MinBytes ignores keys smaller than it in the report.
+	MinBytes int64
+	// Samples is passed to `MEMORY USAGE`, the number of nested values sampled
+	// to estimate the size of containers. The redis default of 5 is used when
+	// 0, every value is measured when negative, which is exact but slow on
+	// huge keys.
+	Samples int
+	// Observe is called with every key not smaller than MinBytes, e.g. to feed
+	// metrics.
+	Observe func(key BigKey)
+}
+
+type BigKey struct {
+	Key  string
+	Type string
+	// Bytes is the memory usage reported by redis.
+	Bytes int64
+	// Length is the number of elements, or the length of a string.
+	Length int64
+}
+
+type BigKeyReport struct {
+	// Scanned is the number of keys inspected.
+	Scanned int64
+	// TotalBytes is the sum of the memory usage of the scanned keys.
+	TotalBytes int64
+	// BytesByType is the memory usage of the scanned keys by type.
+	BytesByType map[string]int64
+	// Keys are the biggest keys, the biggest first.
+	Keys []BigKey
+}
+
+// ScanBigKeys
+//
+// Walk the keyspace with `SCAN` and measure every key with `MEMORY USAGE`
+// (redis >= 4.0). It is meant to be run offline or against a replica, since
+// it touches every key.
+//
+// Example:
+//
+//	report, err := pRedis.ScanBigKeys(ctx, pool, pRedis.BigKeyOptions{Interval: 10 * time.Millisecond})
+//	for _, k := range report.Keys {
+//		log.Infof("%s %s %d bytes, %d elements", k.Type, k.Key, k.Bytes, k.Length)
+//	}
+func ScanBigKeys(ctx context.Context, pool *redis.Pool, opts BigKeyOptions) (*BigKeyReport, error) {
+	if opts.TopN <= 0 {
+		opts.TopN = defaultBigKeyTopN
+	}
+	report := &BigKeyReport{BytesByType: map[string]int64{}}
+	it := Scan(pool, ScanOptions{Match: opts.Match, Count: opts.Count, Interval: opts.Interval})
+	keys := make([]string, 0, bigKeyInspectBatch)
+	for it.Next(ctx) {
+		keys = append(keys, it.Key())
+		if len(keys) < bigKeyInspectBatch {
+			continue
+		}
+		if err := inspectBigKeys(pool, keys, opts, report); err != nil {
+			return report, err
+		}
+		keys = keys[:0]
+	}
+	if err := it.Err(); err != nil {
+		return report, err
+	}
+	if err := inspectBigKeys(pool, keys, opts, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func inspectBigKeys(pool *redis.Pool, keys []string, opts BigKeyOptions, report *BigKeyReport) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	b := NewBatch(pool)
+	types := make([]*Result, len(keys))
+	usages := make([]*Result, len(keys))
+	for i, key := range keys {
+		types[i] = b.Queue("TYPE", key)
+		switch {
+		case opts.Samples > 0:
+			usages[i] = b.Queue("MEMORY", "USAGE", key, "SAMPLES", opts.Samples)
+		case opts.Samples < 0:
+			usages[i] = b.Queue("MEMORY", "USAGE", key, "SAMPLES", 0)
+		default:
+			usages[i] = b.Queue("MEMORY", "USAGE", key)
+		}
+	}
+	if err := b.Exec(); err != nil {
+		return errors.Wrap(err, "inspect keys failed")
+	}
+
+	found := make([]BigKey, 0, len(keys))
+	lb := NewBatch(pool)
+	var lengths []*Result
+	for i, key := range keys {
+		kind, err := types[i].String()
+		if err != nil {
+			return errors.Wrapf(err, "inspect key failed, key=%s", key)
+		}
+		bytes, err := usages[i].Int64()
+		if err == redis.ErrNil || kind == "none" {
+			// deleted since it was scanned
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "inspect key failed, key=%s", key)
+		}
+		report.Scanned++
+		report.TotalBytes += bytes
+		report.BytesByType[kind] += bytes
+		if bytes < opts.MinBytes {
+			continue
+		}
+		found = append(found, BigKey{Key: key, Type: kind, Bytes: bytes})
+		if cmd, ok := lengthCommands[kind]; ok {
+			lengths = append(lengths, lb.Queue(cmd, key))
+		} else {
+			lengths = append(lengths, nil)
+		}
+	}
+	if lb.Len() > 0 {
+		if err := lb.Exec(); err != nil {
+			return errors.Wrap(err, "inspect keys failed")
+		}
+	}
+	for i := range found {
+		if lengths[i] != nil {
+			found[i].Length, _ = lengths[i].Int64()
+		}
+		if opts.Observe != nil {
+			opts.Observe(found[i])
+		}
+	}
+
+	report.Keys = append(report.Keys, found...)
+	sort.Slice(report.Keys, func(i, j int) bool {
+		return report.Keys[i].Bytes > report.Keys[j].Bytes
+	})
+	if len(report.Keys) > opts.TopN {
+		report.Keys = report.Keys[:opts.TopN]
+	}
+	return nil
+}
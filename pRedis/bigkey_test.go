@@ -0,0 +1,83 @@
+package pRedis_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/zzj-custom/pkg/pRedis"
+	"testing"
+)
+
+func TestScanBigKeys(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+	raw := newTestPool(t, srv, nil)
+
+	b := pRedis.NewBatch(pool)
+	b.Queue("SET", "small", "v")
+	for i := 0; i < 50; i++ {
+		b.Queue("HSET", "big", fmt.Sprintf("field%d", i), "some value")
+		b.Queue("RPUSH", "list", i)
+	}
+	if err := b.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	// keys of other prefixes are not scanned
+	rawConn := raw.Get()
+	_, err := rawConn.Do("SET", "other", "v")
+	_ = rawConn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var observed []string
+	report, err := pRedis.ScanBigKeys(context.Background(), pool, pRedis.BigKeyOptions{
+		Count:   2,
+		TopN:    2,
+		Observe: func(key pRedis.BigKey) { observed = append(observed, key.Key) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Scanned != 3 || len(observed) != 3 {
+		t.Fatalf("Scanned = %d, observed %v, want the 3 keys of the prefix", report.Scanned, observed)
+	}
+	if len(report.Keys) != 2 {
+		t.Fatalf("Keys = %+v, want the top 2", report.Keys)
+	}
+	if k := report.Keys[0]; k.Key != "big" || k.Type != "hash" || k.Length != 50 {
+		t.Errorf("biggest key = %+v, want the hash of 50 fields", k)
+	}
+	if k := report.Keys[1]; k.Key != "list" || k.Type != "list" || k.Length != 50 {
+		t.Errorf("second biggest key = %+v, want the list of 50 elements", k)
+	}
+	if report.BytesByType["string"] == 0 || report.TotalBytes <= report.Keys[0].Bytes+report.Keys[1].Bytes {
+		t.Errorf("report = %+v, want the string counted in totals", report)
+	}
+
+	report, err = pRedis.ScanBigKeys(context.Background(), pool, pRedis.BigKeyOptions{MinBytes: report.Keys[0].Bytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Keys) != 1 || report.Keys[0].Key != "big" {
+		t.Fatalf("Keys with MinBytes = %+v, want the biggest only", report.Keys)
+	}
+}
+
+func TestScanBigKeysStreams(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+	conn := pool.Get()
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Do("XADD", "events", "*", "n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = conn.Close()
+
+	report, err := pRedis.ScanBigKeys(context.Background(), pool, pRedis.BigKeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Keys) != 1 || report.Keys[0].Type != "stream" || report.Keys[0].Length != 3 {
+		t.Fatalf("Keys = %+v, want the stream of 3 entries", report.Keys)
+	}
+}
@@ -0,0 +1,121 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"math"
+)
+
+// A redis string holds at most 512MB, that is 2^32 bits.
+const maxBloomBits = 1 << 32
+
+// BloomFilter
+//
+// A bloom filter stored in a redis bitmap with `SETBIT`/`GETBIT`, no module is
+// required. Use it to reject keys that surely do not exist before hitting the
+// cache and the database.
+//
+// Example:
+//
+//	bf, _ := pRedis.NewBloomFilter(pool, "bloom:user", 1000000, 0.01)
+//	_ = bf.Add("user:1")
+//	ok, _ := bf.Exists("user:2")
+type BloomFilter struct {
+	Pool *redis.Pool
+	Key  string
+
+	bits   uint64
+	hashes int
+}
+
+// NewBloomFilter sizes the filter for capacity items with fpRate false
+// positive rate, and computes the optimal number of hash functions.
+func NewBloomFilter(pool *redis.Pool, key string, capacity uint64, fpRate float64) (*BloomFilter, error) {
+	if capacity == 0 {
+		return nil, errors.Errorf("capacity must be positive")
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		return nil, errors.Errorf("false positive rate must be in (0, 1)")
+	}
+	n := float64(capacity)
+	m := math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	if m > maxBloomBits {
+		return nil, errors.Errorf("bloom filter needs %.0f bits, more than a redis string holds", m)
+	}
+	k := int(math.Round(m / n * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &BloomFilter{Pool: pool, Key: key, bits: uint64(m), hashes: k}, nil
+}
+
+// Bits returns the size of the bitmap.
+func (b *BloomFilter) Bits() uint64 {
+	return b.bits
+}
+
+// Hashes returns the number of hash functions.
+func (b *BloomFilter) Hashes() int {
+	return b.hashes
+}
+
+// locations uses double hashing, the i-th location is h1 + i*h2.
+func (b *BloomFilter) locations(item string) []uint64 {
+	f1 := fnv.New64a()
+	_, _ = f1.Write([]byte(item))
+	h1 := f1.Sum64()
+	f2 := fnv.New64()
+	_, _ = f2.Write([]byte(item))
+	h2 := f2.Sum64() | 1
+
+	locations := make([]uint64, b.hashes)
+	for i := range locations {
+		locations[i] = (h1 + uint64(i)*h2) % b.bits
+	}
+	return locations
+}
+
+// Add adds items to the filter.
+func (b *BloomFilter) Add(items ...string) error {
+	batch := NewBatch(b.Pool)
+	results := make([]*Result, 0, len(items)*b.hashes)
+	for _, item := range items {
+		for _, loc := range b.locations(item) {
+			results = append(results, batch.Queue("SETBIT", b.Key, loc, 1))
+		}
+	}
+	if err := batch.Exec(); err != nil {
+		return errors.Wrapf(err, "add to bloom filter failed, key=%s", b.Key)
+	}
+	for _, r := range results {
+		if err := r.Err(); err != nil {
+			return errors.Wrapf(err, "add to bloom filter failed, key=%s", b.Key)
+		}
+	}
+	return nil
+}
+
+// Exists reports whether item may have been added. False positives happen at
+// the configured rate, false negatives never happen.
+func (b *BloomFilter) Exists(item string) (bool, error) {
+	batch := NewBatch(b.Pool)
+	locations := b.locations(item)
+	results := make([]*Result, len(locations))
+	for i, loc := range locations {
+		results[i] = batch.Queue("GETBIT", b.Key, loc)
+	}
+	if err := batch.Exec(); err != nil {
+		return false, errors.Wrapf(err, "check bloom filter failed, key=%s", b.Key)
+	}
+	for _, r := range results {
+		bit, err := r.Int()
+		if err != nil {
+			return false, errors.Wrapf(err, "check bloom filter failed, key=%s", b.Key)
+		}
+		if bit == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
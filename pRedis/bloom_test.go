@@ -0,0 +1,50 @@
+package pRedis_test
+
+import (
+	"fmt"
+	"github.com/zzj-custom/pkg/pRedis"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	if _, err := pRedis.NewBloomFilter(pool, "bloom", 0, 0.01); err == nil {
+		t.Fatal("NewBloomFilter accepted a capacity of 0")
+	}
+	if _, err := pRedis.NewBloomFilter(pool, "bloom", 100, 1); err == nil {
+		t.Fatal("NewBloomFilter accepted a false positive rate of 1")
+	}
+	bf, err := pRedis.NewBloomFilter(pool, "bloom", 1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bf.Bits() != 9586 || bf.Hashes() != 7 {
+		t.Fatalf("filter of %d bits and %d hashes, want 9586 and 7", bf.Bits(), bf.Hashes())
+	}
+
+	var added []string
+	for i := 0; i < 1000; i++ {
+		added = append(added, fmt.Sprintf("user:%d", i))
+	}
+	if err = bf.Add(added...); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range added {
+		if ok, err := bf.Exists(item); err != nil || !ok {
+			t.Fatalf("Exists(%s) = %v, %v, want no false negative", item, ok, err)
+		}
+	}
+	positives := 0
+	for i := 0; i < 1000; i++ {
+		ok, err := bf.Exists(fmt.Sprintf("other:%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			positives++
+		}
+	}
+	if positives > 30 {
+		t.Fatalf("%d false positives out of 1000, want about 1%%", positives)
+	}
+}
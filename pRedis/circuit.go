@@ -0,0 +1,406 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenTimeout      = 5 * time.Second
+	defaultBreakerHalfOpenProbes   = 1
+)
+
+// ErrCircuitOpen is returned instead of reaching redis while the circuit
+// breaker of the pool is open.
+var ErrCircuitOpen = errors.New("redis circuit breaker is open")
+
+// poolBreakers maps pools created by NewPool to their breaker.
+var poolBreakers = sync.Map{}
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// FallbackFunc answers a command instead of redis while the circuit is open.
+type FallbackFunc func(cmd string, args []interface{}) (interface{}, error)
+
+// CacheMissFallback answers every command with a nil reply, so reads look
+// like cache misses and writes are skipped.
+func CacheMissFallback(cmd string, args []interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+type BreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures opening the
+	// circuit, 5 by default.
+	FailureThreshold int `toml:"failure-threshold" json:"failure-threshold,omitempty" yaml:"failure-threshold" mapstructure:"failure-threshold"`
+	// OpenTimeout is how long the circuit stays open before probing redis
+	// again, 5 seconds by default.
+	OpenTimeout time.Duration `toml:"open-timeout" json:"open-timeout,omitempty" yaml:"open-timeout" mapstructure:"open-timeout"`
+	// HalfOpenProbes is the number of commands let through while probing, 1
+	// by default. Every probe counts once admitted, whatever its outcome. The
+	// circuit closes on the first success, and opens again on the first
+	// failure.
+	HalfOpenProbes int `toml:"half-open-probes" json:"half-open-probes,omitempty" yaml:"half-open-probes" mapstructure:"half-open-probes"`
+
+	// IsFailure tells whether an error counts as a failure of redis. By
+	// default, every error but replies of redis (e.g. WRONGTYPE), redis.ErrNil
+	// and an exhausted pool is a failure.
+	IsFailure func(err error) bool `toml:"-" json:"-" yaml:"-" mapstructure:"-"`
+	// Fallback answers commands while the circuit is open, ErrCircuitOpen is
+	// returned if nil.
+	Fallback FallbackFunc `toml:"-" json:"-" yaml:"-" mapstructure:"-"`
+	// OnStateChange is called when the state of the circuit changes.
+	OnStateChange func(name string, from, to BreakerState) `toml:"-" json:"-" yaml:"-" mapstructure:"-"`
+}
+
+// CircuitBreaker
+//
+// Stop sending commands to a redis that keeps failing. After FailureThreshold
+// consecutive failures the circuit opens, and commands fail at once with
+// ErrCircuitOpen, or are answered by the fallback, instead of waiting for
+// timeouts. After OpenTimeout a few probes are let through, and the circuit
+// closes once one of them succeeds.
+//
+// Set `DialConfig.Breaker` to protect a pool, and fetch its breaker by name
+// with Breaker.
+//
+// Example:
+//
+//	config.Breaker = &pRedis.BreakerOptions{FailureThreshold: 3, OpenTimeout: 10 * time.Second}
+//	_, _ = pRedis.InitPool("cache", config)
+//	breaker, _ := pRedis.Breaker("cache")
+//	breaker.SetFallback(pRedis.CacheMissFallback)
+type CircuitBreaker struct {
+	name string
+	opts BreakerOptions
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+	fallback FallbackFunc
+}
+
+// NewCircuitBreaker creates a breaker, name is used in logs and given to
+// OnStateChange. Breakers of pools are named after the pool.
+func NewCircuitBreaker(name string, opts BreakerOptions) *CircuitBreaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = defaultBreakerHalfOpenProbes
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isRedisFailure
+	}
+	return &CircuitBreaker{name: name, opts: opts, fallback: opts.Fallback}
+}
+
+// Breaker
+//
+// Fetch the circuit breaker of the pool with given name, see Pool. An error is
+// returned if the pool was not created with `DialConfig.Breaker`.
+func Breaker(name ...string) (*CircuitBreaker, error) {
+	pool, err := Pool(name...)
+	if err != nil {
+		return nil, err
+	}
+	b := poolBreaker(pool)
+	if b == nil {
+		return nil, errors.Errorf("no circuit breaker for pool %v", name)
+	}
+	return b, nil
+}
+
+// poolBreaker returns the breaker of pool, the one of the primary for pools
+// with replicas, nil if none.
+func poolBreaker(pool *redis.Pool) *CircuitBreaker {
+	if g, ok := replicaGroups.Load(pool); ok {
+		pool = g.(*ReplicaGroup).Primary
+	}
+	if b, ok := poolBreakers.Load(pool); ok {
+		return b.(*CircuitBreaker)
+	}
+	return nil
+}
+
+func isRedisFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch errors.Cause(err) {
+	case redis.ErrNil, redis.ErrPoolExhausted, ErrCircuitOpen:
+		return false
+	}
+	if _, ok := errors.Cause(err).(redis.Error); ok {
+		return false
+	}
+	return true
+}
+
+// State returns the current state of the circuit.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.halfOpenIfDue()
+	return b.state
+}
+
+// SetFallback replaces the fallback answering commands while the circuit is
+// open, nil makes them fail with ErrCircuitOpen.
+func (b *CircuitBreaker) SetFallback(fallback FallbackFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fallback = fallback
+}
+
+// Reset closes the circuit.
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	from := b.state
+	b.failures = 0
+	b.probes = 0
+	b.state = BreakerClosed
+	b.mu.Unlock()
+	b.changed(from, BreakerClosed)
+}
+
+// Allow tells whether a command may be sent, and whether it is a probe. The
+// outcome of an allowed command must be reported by Done.
+func (b *CircuitBreaker) Allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.halfOpenIfDue()
+	switch b.state {
+	case BreakerOpen:
+		return false, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probes >= b.opts.HalfOpenProbes {
+			return false, ErrCircuitOpen
+		}
+		b.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+// Done reports the outcome of a command allowed by Allow. Probes are counted
+// by Allow, so that a probe completing after the circuit opened again does
+// not let another one through.
+func (b *CircuitBreaker) Done(probe bool, err error) {
+	failed := b.opts.IsFailure(err)
+	b.mu.Lock()
+	from := b.state
+	switch {
+	case !failed:
+		// only probes close a half-open circuit, commands admitted before it
+		// opened prove nothing
+		switch {
+		case b.state == BreakerClosed:
+			b.failures = 0
+		case b.state == BreakerHalfOpen && probe:
+			b.state = BreakerClosed
+		}
+	case b.state == BreakerHalfOpen:
+		b.open()
+	case b.state == BreakerClosed:
+		b.failures++
+		if b.failures >= b.opts.FailureThreshold {
+			b.open()
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	if from != to {
+		if failed {
+			log.WithError(err).WithField("breaker", b.name).Warnf("redis circuit breaker %s", to)
+		}
+		b.changed(from, to)
+	}
+}
+
+// open must be called with b.mu held.
+func (b *CircuitBreaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.failures = 0
+	b.probes = 0
+}
+
+// halfOpenIfDue must be called with b.mu held.
+func (b *CircuitBreaker) halfOpenIfDue() {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.opts.OpenTimeout {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+	}
+}
+
+func (b *CircuitBreaker) changed(from, to BreakerState) {
+	if from == to {
+		return
+	}
+	if to == BreakerClosed {
+		log.WithField("breaker", b.name).Info("redis circuit breaker closed")
+	}
+	if b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(b.name, from, to)
+	}
+}
+
+func (b *CircuitBreaker) reject(cmd string, args []interface{}, err error) (interface{}, error) {
+	b.mu.Lock()
+	fallback := b.fallback
+	b.mu.Unlock()
+	if fallback == nil {
+		return nil, err
+	}
+	return fallback(cmd, args)
+}
+
+// Hook returns the hook guarding commands with the breaker. Pipelined
+// commands are admitted by Send like the ones of Do, and their replies are
+// reported when read, see `Hook.Pipeline`. Pub/sub messages and timeouts of
+// Receive are not reported, an idle subscriber is no sign of failure.
+//
+// Pools created with `DialConfig.Breaker` have it already, each connection
+// must use its own hook.
+func (b *CircuitBreaker) Hook() Hook {
+	// rejected is the error of the command being sent, set by Pipeline which
+	// is called right before Send.
+	var rejected error
+	return Hook{
+		Do: func(next DoFunc) DoFunc {
+			return func(cmd string, args ...interface{}) (interface{}, error) {
+				probe, err := b.Allow()
+				if err != nil {
+					return b.reject(cmd, args, err)
+				}
+				reply, err := next(cmd, args...)
+				b.Done(probe, err)
+				return reply, err
+			}
+		},
+		Pipeline: func(cmd string, args []interface{}) func(reply interface{}, err error) {
+			probe, err := b.Allow()
+			if rejected = err; err != nil {
+				return nil
+			}
+			return func(reply interface{}, err error) {
+				if isTimeout(err) {
+					b.release(probe)
+					return
+				}
+				b.Done(probe, err)
+			}
+		},
+		Send: func(next SendFunc) SendFunc {
+			return func(cmd string, args ...interface{}) error {
+				if err := rejected; err != nil {
+					rejected = nil
+					return err
+				}
+				return next(cmd, args...)
+			}
+		},
+	}
+}
+
+// release gives back a probe admitted by Allow whose outcome is unknown, so
+// that another one is let through.
+func (b *CircuitBreaker) release(probe bool) {
+	if !probe {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := errors.Cause(err).(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// dial wraps the Dial of a pool, so that no connection is attempted while the
+// circuit is open. A placeholder connection answering with the fallback is
+// returned instead, the pool drops it when it is closed.
+func (b *CircuitBreaker) dial(dial func() (redis.Conn, error)) func() (redis.Conn, error) {
+	return func() (redis.Conn, error) {
+		probe, err := b.Allow()
+		if err != nil {
+			return &openConn{breaker: b}, nil
+		}
+		conn, err := dial()
+		b.Done(probe, err)
+		return conn, err
+	}
+}
+
+// openConn stands for a connection while the circuit is open.
+type openConn struct {
+	breaker *CircuitBreaker
+}
+
+func (c *openConn) Close() error {
+	return nil
+}
+
+func (c *openConn) Err() error {
+	return ErrCircuitOpen
+}
+
+func (c *openConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		return nil, nil
+	}
+	return c.breaker.reject(cmd, args, ErrCircuitOpen)
+}
+
+// DoWithTimeout is Do, nothing is waited for.
+func (c *openConn) DoWithTimeout(_ time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return c.Do(cmd, args...)
+}
+
+func (c *openConn) Send(cmd string, args ...interface{}) error {
+	return ErrCircuitOpen
+}
+
+func (c *openConn) Flush() error {
+	return ErrCircuitOpen
+}
+
+func (c *openConn) Receive() (interface{}, error) {
+	return nil, ErrCircuitOpen
+}
+
+func (c *openConn) ReceiveWithTimeout(time.Duration) (interface{}, error) {
+	return c.Receive()
+}
@@ -0,0 +1,287 @@
+package pRedis_test
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/zzj-custom/pkg/pRedis"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := pRedis.NewCircuitBreaker("test", pRedis.BreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+	})
+
+	b.Done(false, redis.ErrNil)
+	b.Done(false, io.EOF)
+	if b.State() != pRedis.BreakerClosed {
+		t.Fatalf("state = %s after one failure, want closed", b.State())
+	}
+	b.Done(false, io.EOF)
+	if b.State() != pRedis.BreakerOpen {
+		t.Fatalf("state = %s after two failures, want open", b.State())
+	}
+	if _, err := b.Allow(); err != pRedis.ErrCircuitOpen {
+		t.Fatalf("Allow of an open circuit = %v, want ErrCircuitOpen", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if b.State() != pRedis.BreakerHalfOpen {
+		t.Fatalf("state = %s after OpenTimeout, want half-open", b.State())
+	}
+	probe, err := b.Allow()
+	if err != nil || !probe {
+		t.Fatalf("Allow = %v, %v, want a probe", probe, err)
+	}
+	b.Done(probe, io.EOF)
+	if b.State() != pRedis.BreakerOpen {
+		t.Fatalf("state = %s after a failed probe, want open", b.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	probe, err = b.Allow()
+	if err != nil || !probe {
+		t.Fatalf("Allow = %v, %v, want a probe", probe, err)
+	}
+	b.Done(probe, nil)
+	if b.State() != pRedis.BreakerClosed {
+		t.Fatalf("state = %s after a successful probe, want closed", b.State())
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	b := pRedis.NewCircuitBreaker("test", pRedis.BreakerOptions{
+		FailureThreshold: 1,
+		OpenTimeout:      20 * time.Millisecond,
+		HalfOpenProbes:   2,
+	})
+	b.Done(false, io.EOF)
+	time.Sleep(30 * time.Millisecond)
+
+	// probes are counted when admitted, so slow probes do not let more in
+	var admitted int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if probe, err := b.Allow(); err == nil && probe {
+				atomic.AddInt32(&admitted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if admitted != 2 {
+		t.Fatalf("%d probes admitted, want 2", admitted)
+	}
+}
+
+func TestCircuitBreakerPool(t *testing.T) {
+	srv := newTestServer(t)
+	var fail int32
+	config := srv.DialConfig()
+	var mu sync.Mutex
+	var changes []string
+	config.Breaker = &pRedis.BreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+		OnStateChange: func(name string, from, to pRedis.BreakerState) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, name+": "+to.String())
+		},
+	}
+	config.Hooks = []pRedis.Hook{pRedis.FaultHook(func(cmd string, args []interface{}) error {
+		if cmd == "SET" && atomic.LoadInt32(&fail) == 1 {
+			return io.EOF
+		}
+		return nil
+	})}
+	if _, err := pRedis.InitPool("test-breaker", config); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := pRedis.Pool("test-breaker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := pRedis.Breaker("test-breaker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a single connection, so that the PING of borrowed connections does not
+	// reset the count of consecutive failures
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err = conn.Do("SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&fail, 1)
+	for i := 0; i < 2; i++ {
+		if _, err = conn.Do("SET", "k", "v"); errors.Cause(err) != io.EOF {
+			t.Fatalf("Do = %v, want the injected fault", err)
+		}
+	}
+	if _, err = conn.Do("GET", "k"); errors.Cause(err) != pRedis.ErrCircuitOpen {
+		t.Fatalf("Do of an open circuit = %v, want ErrCircuitOpen", err)
+	}
+	other := pool.Get()
+	_, err = other.Do("GET", "k")
+	if errors.Cause(err) != pRedis.ErrCircuitOpen {
+		t.Fatalf("Do on a new connection of an open circuit = %v, want ErrCircuitOpen", err)
+	}
+	_, err = redis.DoWithTimeout(other, time.Second, "GET", "k")
+	if errors.Cause(err) != pRedis.ErrCircuitOpen {
+		t.Fatalf("DoWithTimeout on a new connection of an open circuit = %v, want ErrCircuitOpen", err)
+	}
+	_, err = redis.ReceiveWithTimeout(other, time.Second)
+	_ = other.Close()
+	if errors.Cause(err) != pRedis.ErrCircuitOpen {
+		t.Fatalf("ReceiveWithTimeout on a new connection of an open circuit = %v, want ErrCircuitOpen", err)
+	}
+
+	b.Reset()
+	atomic.StoreInt32(&fail, 0)
+	if _, err = conn.Do("SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"test-breaker: open", "test-breaker: closed"}; !reflect.DeepEqual(changes, want) {
+		t.Fatalf("state changes %v, want %v", changes, want)
+	}
+}
+
+func TestCircuitBreakerPipeline(t *testing.T) {
+	var flaky int32
+	var receives int32
+	// every other reply of a pipeline is lost
+	lose := pRedis.Hook{
+		Receive: func(next pRedis.ReceiveFunc) pRedis.ReceiveFunc {
+			return func() (interface{}, error) {
+				reply, err := next()
+				if atomic.LoadInt32(&flaky) == 1 && atomic.AddInt32(&receives, 1)%2 == 0 {
+					return nil, io.ErrUnexpectedEOF
+				}
+				return reply, err
+			}
+		},
+	}
+	pool := newTestPool(t, newTestServer(t), func(config *pRedis.DialConfig) {
+		config.Breaker = &pRedis.BreakerOptions{FailureThreshold: 2, OpenTimeout: time.Minute}
+		config.Hooks = []pRedis.Hook{lose}
+	})
+	conn := pool.Get()
+	defer conn.Close()
+	atomic.StoreInt32(&flaky, 1)
+
+	for i := 0; i < 6; i++ {
+		if err := conn.Send("INCR", "n"); err != nil {
+			t.Fatalf("Send #%d = %v, want the circuit closed by the successes in between", i, err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		_, _ = conn.Receive()
+	}
+	if err := conn.Send("INCR", "n"); err != nil {
+		t.Fatalf("Send after non-consecutive failures = %v, want the circuit closed", err)
+	}
+}
+
+func TestCircuitBreakerPipelineProbes(t *testing.T) {
+	var fail int32 = 1
+	config := newTestServer(t).DialConfig()
+	config.Breaker = &pRedis.BreakerOptions{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond}
+	config.Hooks = []pRedis.Hook{pRedis.FaultHook(func(cmd string, args []interface{}) error {
+		if cmd == "GET" && atomic.LoadInt32(&fail) == 1 {
+			return io.EOF
+		}
+		return nil
+	})}
+	pool, err := pRedis.InitPool("test-breaker-probes", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := pRedis.Breaker("test-breaker-probes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, other := pool.Get(), pool.Get()
+	defer conn.Close()
+	defer other.Close()
+
+	// sent while closed, its reply is read once half-open
+	if err = conn.Send("INCR", "n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Do("GET", "k"); errors.Cause(err) != io.EOF {
+		t.Fatalf("Do = %v, want the injected fault", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if b.State() != pRedis.BreakerHalfOpen {
+		t.Fatalf("state = %s after OpenTimeout, want half-open", b.State())
+	}
+	if err = conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	if b.State() != pRedis.BreakerHalfOpen {
+		t.Fatalf("state = %s after the reply of a command sent before, want half-open", b.State())
+	}
+
+	// pipelined commands are probes too, one is let through
+	if err = conn.Send("INCR", "n"); err != nil {
+		t.Fatalf("Send of the probe = %v", err)
+	}
+	if err = conn.Send("INCR", "n"); errors.Cause(err) != pRedis.ErrCircuitOpen {
+		t.Fatalf("Send beyond the probes = %v, want ErrCircuitOpen", err)
+	}
+	if err = conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(conn.Receive()); err != nil || n != 2 {
+		t.Fatalf("Receive of the probe = %d, %v, want 2", n, err)
+	}
+	if b.State() != pRedis.BreakerClosed {
+		t.Fatalf("state = %s after a successful probe, want closed", b.State())
+	}
+}
+
+func TestCircuitBreakerSubscriber(t *testing.T) {
+	config := newTestServer(t).DialConfig()
+	config.Breaker = &pRedis.BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Minute}
+	pool, err := pRedis.InitPool("test-breaker-subscriber", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := pRedis.Breaker("test-breaker-subscriber")
+	if err != nil {
+		t.Fatal(err)
+	}
+	psc := redis.PubSubConn{Conn: pool.Get()}
+	defer psc.Close()
+	if err = psc.Subscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := psc.Receive().(redis.Subscription); !ok {
+		t.Fatal("no subscription confirmed")
+	}
+	// nothing published, an idle subscriber is no failure
+	if err, ok := psc.ReceiveWithTimeout(10 * time.Millisecond).(error); !ok {
+		t.Fatalf("ReceiveWithTimeout = %v, want a timeout", err)
+	}
+	if b.State() != pRedis.BreakerClosed {
+		t.Fatalf("state = %s after a receive timeout, want closed", b.State())
+	}
+}
@@ -0,0 +1,436 @@
+package pRedis
+
+import (
+	"container/list"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	invalidateChannel            = "__redis__:invalidate"
+	defaultClientCacheMaxEntries = 10000
+	clientCacheReconnectInterval = time.Second
+)
+
+// poolCaches maps the pools registered with `DialConfig.ClientCache` to their
+// cache.
+var poolCaches = sync.Map{}
+
+type ClientCacheOptions struct {
+	// Broadcast enables the broadcasting mode, redis then invalidates every
+	// key matching Prefixes whoever read it, instead of the keys read by the
+	// cache only.
+	Broadcast bool     `toml:"broadcast" json:"broadcast,omitempty" yaml:"broadcast" mapstructure:"broadcast"`
+	Prefixes  []string `toml:"prefixes" json:"prefixes,omitempty" yaml:"prefixes" mapstructure:"prefixes"`
+	// MaxEntries bounds the number of cached replies, the least recently used
+	// are evicted first. 10000 by default.
+	MaxEntries int `toml:"max-entries" json:"max-entries,omitempty" yaml:"max-entries" mapstructure:"max-entries"`
+	// TTL bounds the age of cached replies, they are kept until invalidated
+	// if 0.
+	TTL time.Duration `toml:"ttl" json:"ttl,omitempty" yaml:"ttl" mapstructure:"ttl"`
+}
+
+type ClientCacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+	Entries       int
+	// Tracking is false while the connections to redis are being set up,
+	// commands are then sent to redis without caching.
+	Tracking bool
+}
+
+type cacheEntry struct {
+	id       string
+	keys     []string
+	reply    interface{}
+	storedAt time.Time
+}
+
+// ClientCache
+//
+// A local cache of read-only replies kept coherent by redis 6 server-assisted
+// client side caching. Two connections are dialed outside of the pool: one
+// subscribed to the invalidation channel, and one with `CLIENT TRACKING`
+// redirected to the first, which runs every cache miss. When a key read by
+// the cache changes, redis sends its name and the replies involving it are
+// dropped. If a connection breaks, the cache is flushed and both are dialed
+// again.
+//
+// Replies are shared between callers and must not be modified.
+//
+// Example:
+//
+//	cache, _ := pRedis.NewClientCache(pool, pRedis.ClientCacheOptions{})
+//	defer cache.Close()
+//	name, err := redis.String(cache.Do("HGET", "user:1", "name"))
+type ClientCache struct {
+	pool *redis.Pool
+	opts ClientCacheOptions
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	byKey    map[string]map[string]struct{}
+	lru      *list.List
+	tracking bool
+	// fetching holds the keys of the miss in progress, dirty is set if one of
+	// them is invalidated before the reply is stored.
+	fetching []string
+	dirty    bool
+
+	// connMu serializes the commands of conn, the tracking connection.
+	connMu sync.Mutex
+	conn   redis.Conn
+	sub    redis.Conn
+
+	hits, misses, invalidations uint64
+
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewClientCache
+//
+// Create a cache of the read-only replies of pool, see ClientCache. It tracks
+// in background until closed. Use the pool of a primary, replicas are not
+// tracked.
+func NewClientCache(pool *redis.Pool, opts ClientCacheOptions) (*ClientCache, error) {
+	if pool == nil {
+		return nil, errors.Errorf("redis pool must be specified")
+	}
+	if len(opts.Prefixes) > 0 && !opts.Broadcast {
+		return nil, errors.Errorf("prefixes need the broadcasting mode")
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultClientCacheMaxEntries
+	}
+	c := &ClientCache{
+		pool:    pool,
+		opts:    opts,
+		entries: map[string]*list.Element{},
+		byKey:   map[string]map[string]struct{}{},
+		lru:     list.New(),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// ClientCacheOf
+//
+// Fetch the cache of the pool with given name, see Pool. An error is returned
+// if the pool was not registered with `DialConfig.ClientCache`.
+func ClientCacheOf(name ...string) (*ClientCache, error) {
+	pool, err := Pool(name...)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := poolCaches.Load(pool)
+	if !ok {
+		return nil, errors.Errorf("no client cache for pool %v", name)
+	}
+	return c.(*ClientCache), nil
+}
+
+// Do runs a read-only command through the cache, other commands are sent to
+// the pool as is.
+func (c *ClientCache) Do(cmd string, args ...interface{}) (interface{}, error) {
+	keys := CommandKeys(cmd, args...)
+	if !isReadOnly(cmd) || len(keys) == 0 {
+		return c.direct(cmd, args...)
+	}
+	id := cacheEntryID(cmd, args)
+	if reply, ok := c.lookup(id); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return reply, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return c.fetch(id, keys, cmd, args)
+}
+
+// Invalidate drops the replies involving keys, e.g. right after writing them
+// when the invalidation of redis must not be waited for.
+func (c *ClientCache) Invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		c.invalidateKey(key)
+	}
+}
+
+// Flush drops every cached reply.
+func (c *ClientCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush()
+}
+
+func (c *ClientCache) Stats() ClientCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ClientCacheStats{
+		Hits:          atomic.LoadUint64(&c.hits),
+		Misses:        atomic.LoadUint64(&c.misses),
+		Invalidations: atomic.LoadUint64(&c.invalidations),
+		Entries:       len(c.entries),
+		Tracking:      c.tracking,
+	}
+}
+
+// Close stops tracking and closes the connections of the cache.
+func (c *ClientCache) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.connMu.Lock()
+		if c.sub != nil {
+			// unblocks the receiving loop
+			_ = c.sub.Close()
+		}
+		c.connMu.Unlock()
+	})
+	<-c.done
+	return nil
+}
+
+func cacheEntryID(cmd string, args []interface{}) string {
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, strings.ToUpper(cmd))
+	for _, arg := range args {
+		parts = append(parts, argString(arg))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func (c *ClientCache) direct(cmd string, args ...interface{}) (interface{}, error) {
+	conn := c.pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	return conn.Do(cmd, args...)
+}
+
+func (c *ClientCache) lookup(id string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if c.opts.TTL > 0 && time.Since(entry.storedAt) > c.opts.TTL {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.reply, true
+}
+
+// fetch runs a miss on the tracking connection, so that redis tracks its keys.
+func (c *ClientCache) fetch(id string, keys []string, cmd string, args []interface{}) (interface{}, error) {
+	c.connMu.Lock()
+	c.mu.Lock()
+	tracking := c.tracking
+	if tracking {
+		c.fetching, c.dirty = keys, false
+	}
+	c.mu.Unlock()
+	if !tracking {
+		c.connMu.Unlock()
+		return c.direct(cmd, args...)
+	}
+	defer c.connMu.Unlock()
+
+	reply, err := c.conn.Do(cmd, args...)
+	if c.conn.Err() != nil {
+		// the subscription is closed too, so that both are dialed again
+		_ = c.sub.Close()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil && c.tracking && !c.dirty {
+		c.store(&cacheEntry{id: id, keys: keys, reply: reply, storedAt: time.Now()})
+	}
+	c.fetching, c.dirty = nil, false
+	return reply, err
+}
+
+// store adds an entry, c.mu must be held.
+func (c *ClientCache) store(entry *cacheEntry) {
+	if e, ok := c.entries[entry.id]; ok {
+		c.remove(e)
+	}
+	c.entries[entry.id] = c.lru.PushFront(entry)
+	for _, key := range entry.keys {
+		ids, ok := c.byKey[key]
+		if !ok {
+			ids = map[string]struct{}{}
+			c.byKey[key] = ids
+		}
+		ids[entry.id] = struct{}{}
+	}
+	for c.lru.Len() > c.opts.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry, c.mu must be held.
+func (c *ClientCache) remove(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	c.lru.Remove(e)
+	delete(c.entries, entry.id)
+	for _, key := range entry.keys {
+		if ids, ok := c.byKey[key]; ok {
+			delete(ids, entry.id)
+			if len(ids) == 0 {
+				delete(c.byKey, key)
+			}
+		}
+	}
+}
+
+// invalidateKey drops the entries of key, c.mu must be held.
+func (c *ClientCache) invalidateKey(key string) {
+	for _, k := range c.fetching {
+		if k == key {
+			c.dirty = true
+		}
+	}
+	for id := range c.byKey[key] {
+		if e, ok := c.entries[id]; ok {
+			c.remove(e)
+		}
+	}
+}
+
+// flush drops every entry, c.mu must be held.
+func (c *ClientCache) flush() {
+	c.entries = map[string]*list.Element{}
+	c.byKey = map[string]map[string]struct{}{}
+	c.lru.Init()
+	c.dirty = true
+}
+
+func (c *ClientCache) run() {
+	defer close(c.done)
+	for {
+		err := c.track()
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+		log.WithError(err).Warn("redis client cache stopped tracking, reconnecting")
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(clientCacheReconnectInterval):
+		}
+	}
+}
+
+// track dials the connections of the cache, then applies invalidations until
+// the subscription breaks.
+func (c *ClientCache) track() error {
+	sub, conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	c.connMu.Lock()
+	select {
+	case <-c.closed:
+		c.connMu.Unlock()
+		_ = sub.Close()
+		_ = conn.Close()
+		return nil
+	default:
+	}
+	c.sub, c.conn = sub, conn
+	c.mu.Lock()
+	c.flush()
+	c.tracking = true
+	c.mu.Unlock()
+	c.connMu.Unlock()
+
+	defer func() {
+		c.connMu.Lock()
+		c.mu.Lock()
+		c.tracking = false
+		c.flush()
+		c.mu.Unlock()
+		_ = c.conn.Close()
+		_ = c.sub.Close()
+		c.conn, c.sub = nil, nil
+		c.connMu.Unlock()
+	}()
+	for {
+		// no read timeout, invalidations may not come for long
+		reply, err := redis.ReceiveWithTimeout(sub, 0)
+		if err != nil {
+			return err
+		}
+		c.handle(reply)
+	}
+}
+
+func (c *ClientCache) dial() (sub, conn redis.Conn, err error) {
+	if sub, err = c.pool.Dial(); err != nil {
+		return nil, nil, err
+	}
+	id, err := redis.Int64(sub.Do("CLIENT", "ID"))
+	if err == nil {
+		_, err = sub.Do("SUBSCRIBE", invalidateChannel)
+	}
+	if err != nil {
+		_ = sub.Close()
+		return nil, nil, errors.Wrap(err, "subscribe invalidations failed")
+	}
+	if conn, err = c.pool.Dial(); err != nil {
+		_ = sub.Close()
+		return nil, nil, err
+	}
+	args := redis.Args{}.Add("TRACKING", "ON", "REDIRECT", id)
+	if c.opts.Broadcast {
+		args = args.Add("BCAST")
+		for _, prefix := range c.opts.Prefixes {
+			args = args.Add("PREFIX", prefix)
+		}
+	}
+	if _, err = conn.Do("CLIENT", args...); err != nil {
+		_ = sub.Close()
+		_ = conn.Close()
+		return nil, nil, errors.Wrap(err, "enable tracking failed")
+	}
+	return sub, conn, nil
+}
+
+// handle applies an invalidation message, whose payload is the array of
+// invalidated keys, or nil when redis flushed its databases.
+func (c *ClientCache) handle(reply interface{}) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 3 {
+		return
+	}
+	if kind, _ := redis.String(values[0], nil); kind != "message" {
+		return
+	}
+	atomic.AddUint64(&c.invalidations, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys, ok := values[2].([]interface{})
+	if !ok {
+		c.flush()
+		return
+	}
+	for _, key := range keys {
+		if k, err := redis.String(key, nil); err == nil {
+			c.invalidateKey(k)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package pRedis_test
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"testing"
+)
+
+func TestClientCache(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+	cache, err := pRedis.NewClientCache(pool, pRedis.ClientCacheOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	waitFor(t, "tracking", func() bool { return cache.Stats().Tracking })
+
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err = conn.Do("SET", "k", "1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if v, err := redis.String(cache.Do("GET", "k")); err != nil || v != "1" {
+			t.Fatalf("GET = %q, %v, want 1", v, err)
+		}
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("stats = %+v, want a miss then a hit", stats)
+	}
+
+	// a write of another client invalidates the unprefixed key
+	if _, err = conn.Do("SET", "k", "2"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the invalidation", func() bool { return cache.Stats().Entries == 0 })
+	if v, err := redis.String(cache.Do("GET", "k")); err != nil || v != "2" {
+		t.Fatalf("GET after a write = %q, %v, want 2", v, err)
+	}
+
+	// commands that are not read-only are not cached
+	if _, err = cache.Do("INCR", "n"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(cache.Do("INCR", "n")); err != nil || n != 2 {
+		t.Fatalf("INCR = %d, %v, want 2", n, err)
+	}
+}
+
+func TestClientCacheOf(t *testing.T) {
+	srv := newTestServer(t)
+	config := srv.DialConfig()
+	config.ClientCache = &pRedis.ClientCacheOptions{Broadcast: true, Prefixes: []string{"user:"}}
+	if _, err := pRedis.InitPool("test-client-cache", config); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := pRedis.ClientCacheOf("test-client-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "tracking", func() bool { return cache.Stats().Tracking })
+
+	pool, err := pRedis.Pool("test-client-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err = conn.Do("HSET", "user:1", "name", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cache.Do("HGET", "user:1", "name"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Do("HSET", "user:1", "name", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the broadcast invalidation", func() bool { return cache.Stats().Invalidations > 0 })
+	if name, err := redis.String(cache.Do("HGET", "user:1", "name")); err != nil || name != "bob" {
+		t.Fatalf("HGET after a write = %q, %v, want bob", name, err)
+	}
+
+	if _, err = pRedis.ClientCacheOf("missing"); err == nil {
+		t.Fatal("ClientCacheOf of a missing pool succeeded")
+	}
+}
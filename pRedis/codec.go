@@ -0,0 +1,193 @@
+package pRedis
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+	"time"
+)
+
+// Codec converts values between Go and the bytes stored in redis.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtoCodec encodes values implementing proto.Message with protobuf.
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errors.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// DefaultCodec is used by the typed helpers when no codec is given.
+var DefaultCodec Codec = JSONCodec{}
+
+func pickCodec(codec []Codec) Codec {
+	if len(codec) > 0 && codec[0] != nil {
+		return codec[0]
+	}
+	return DefaultCodec
+}
+
+// Get
+//
+// Fetch key and decode it into a T with given codec, or DefaultCodec if no
+// codec given. redis.ErrNil is returned if key does not exist.
+func Get[T any](pool *redis.Pool, key string, codec ...Codec) (T, error) {
+	var v T
+	conn := pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	data, err := redis.Bytes(conn.Do("GET", key))
+	if err != nil {
+		return v, err
+	}
+	if err = pickCodec(codec).Unmarshal(data, &v); err != nil {
+		return v, errors.Wrapf(err, "decode value failed, key=%s", key)
+	}
+	return v, nil
+}
+
+// Set
+//
+// Encode value with given codec, or DefaultCodec if no codec given, and store
+// it to key. The key expires after ttl if ttl is positive.
+func Set[T any](pool *redis.Pool, key string, value T, ttl time.Duration, codec ...Codec) error {
+	data, err := pickCodec(codec).Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "encode value failed, key=%s", key)
+	}
+	args := []interface{}{key, data}
+	if ttl > 0 {
+		args = append(args, "PX", ttlMillis(ttl))
+	}
+	conn := pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Do("SET", args...)
+	return err
+}
+
+// ttlMillis converts a positive ttl to milliseconds, rounded up, so that a ttl
+// below a millisecond is not sent as 0, which redis refuses with `PX` and
+// takes as an immediate expiry with `PEXPIRE`.
+func ttlMillis(ttl time.Duration) int64 {
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+// HGetAll
+//
+// Fetch hash key into a struct T. Fields are mapped by the `redis` struct tag
+// as redis.ScanStruct does, e.g.
+//
+//	type User struct {
+//	    Name string `redis:"name"`
+//	    Age  int    `redis:"age"`
+//	}
+//
+// T may also be a pointer to such a struct, which is then allocated.
+// redis.ErrNil is returned if key does not exist.
+func HGetAll[T any](pool *redis.Pool, key string) (T, error) {
+	var v T
+	conn := pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	values, err := redis.Values(conn.Do("HGETALL", key))
+	if err != nil {
+		return v, err
+	}
+	if len(values) == 0 {
+		return v, redis.ErrNil
+	}
+	target := interface{}(&v)
+	if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem()).Interface().(T)
+		target = v
+	}
+	if err = redis.ScanStruct(values, target); err != nil {
+		var zero T
+		return zero, errors.Wrapf(err, "scan hash failed, key=%s", key)
+	}
+	return v, nil
+}
+
+// HSet
+//
+// Store the fields of struct value into hash key, with the same mapping as
+// HGetAll. The key expires after ttl if ttl is positive.
+func HSet[T any](pool *redis.Pool, key string, value T, ttl time.Duration) error {
+	args := redis.Args{}.Add(key).AddFlat(value)
+	if len(args) == 1 {
+		return errors.Errorf("no field to set, key=%s", key)
+	}
+	conn := pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if ttl <= 0 {
+		_, err := conn.Do("HSET", args...)
+		return err
+	}
+	b := &Batch{}
+	set := b.Queue("HSET", args...)
+	b.Queue("PEXPIRE", key, ttlMillis(ttl))
+	if err := b.execTx(conn); err != nil {
+		return err
+	}
+	return set.Err()
+}
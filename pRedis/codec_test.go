@@ -0,0 +1,80 @@
+package pRedis_test
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	Name string   `json:"name" redis:"name"`
+	Age  int      `json:"age" redis:"age"`
+	Tags []string `json:"tags" redis:"-"`
+}
+
+func TestGetSet(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, nil)
+	user := testUser{Name: "alice", Age: 30, Tags: []string{"admin"}}
+
+	for _, codec := range []pRedis.Codec{pRedis.JSONCodec{}, pRedis.MsgpackCodec{}, pRedis.GobCodec{}} {
+		if err := pRedis.Set(pool, "user", user, 0, codec); err != nil {
+			t.Fatal(err)
+		}
+		got, err := pRedis.Get[testUser](pool, "user", codec)
+		if err != nil || !reflect.DeepEqual(got, user) {
+			t.Fatalf("Get with %T = %+v, %v, want %+v", codec, got, err, user)
+		}
+	}
+	if _, err := pRedis.Get[testUser](pool, "missing"); err != redis.ErrNil {
+		t.Fatalf("Get of a missing key = %v, want ErrNil", err)
+	}
+	if _, err := pRedis.Get[int](pool, "user", pRedis.GobCodec{}); err == nil {
+		t.Fatal("Get decoded a user into an int")
+	}
+
+	// a ttl below a millisecond still expires the key
+	if err := pRedis.Set(pool, "short", 1, 500*time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	if ttl, _ := redis.Int(conn.Do("PTTL", "short")); ttl < 0 {
+		t.Fatalf("PTTL = %d, want the key to expire", ttl)
+	}
+	srv.Advance(time.Millisecond)
+	if _, err := pRedis.Get[int](pool, "short"); err != redis.ErrNil {
+		t.Fatalf("Get of an expired key = %v, want ErrNil", err)
+	}
+}
+
+func TestHGetAllHSet(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, nil)
+
+	if err := pRedis.HSet(pool, "user:1", testUser{Name: "alice", Age: 30}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, err := pRedis.HGetAll[*testUser](pool, "user:1")
+	if err != nil || got == nil || got.Name != "alice" || got.Age != 30 {
+		t.Fatalf("HGetAll = %+v, %v, want alice", got, err)
+	}
+	if _, err = pRedis.HGetAll[testUser](pool, "missing"); err != redis.ErrNil {
+		t.Fatalf("HGetAll of a missing key = %v, want ErrNil", err)
+	}
+
+	if err = pRedis.HSet(pool, "user:2", testUser{Name: "bob"}, 500*time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pRedis.HGetAll[testUser](pool, "user:2"); err != nil {
+		t.Fatalf("HGetAll of a hash with a ttl below a millisecond = %v, want it stored", err)
+	}
+	srv.Advance(time.Minute)
+	for _, key := range []string{"user:1", "user:2"} {
+		if _, err = pRedis.HGetAll[testUser](pool, key); err != redis.ErrNil {
+			t.Fatalf("HGetAll of expired %s = %v, want ErrNil", key, err)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package pRedis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// commandInfo describes where keys are located in the arguments of a command.
+// Positions are indexes into args (the command name excluded), a negative
+// last counts from the end of args.
+type commandInfo struct {
+	first, last, step int
+	readOnly          bool
+	channels          bool
+	numKeys           int  // >= 0 when the arg at this index holds the number of keys that follow it
+	streams           bool // keys are the first half of the args after STREAMS
+}
+
+func keys(first, last, step int) commandInfo {
+	return commandInfo{first: first, last: last, step: step, numKeys: -1}
+}
+
+func readKeys(first, last, step int) commandInfo {
+	info := keys(first, last, step)
+	info.readOnly = true
+	return info
+}
+
+var commandInfos = map[string]commandInfo{
+	// keys
+	"DEL":       keys(0, -1, 1),
+	"UNLINK":    keys(0, -1, 1),
+	"EXISTS":    readKeys(0, -1, 1),
+	"EXPIRE":    keys(0, 0, 1),
+	"PEXPIRE":   keys(0, 0, 1),
+	"EXPIREAT":  keys(0, 0, 1),
+	"PEXPIREAT": keys(0, 0, 1),
+	"PERSIST":   keys(0, 0, 1),
+	"TTL":       readKeys(0, 0, 1),
+	"PTTL":      readKeys(0, 0, 1),
+	"TYPE":      readKeys(0, 0, 1),
+	"TOUCH":     readKeys(0, -1, 1),
+	"DUMP":      readKeys(0, 0, 1),
+	"RESTORE":   keys(0, 0, 1),
+	"RENAME":    keys(0, 1, 1),
+	"RENAMENX":  keys(0, 1, 1),
+	"OBJECT":    readKeys(1, 1, 1),
+	"MEMORY":    readKeys(1, 1, 1),
+	"WATCH":     keys(0, -1, 1),
+	"KEYS":      readKeys(0, 0, 1),
+	"SCAN":      {first: -1, numKeys: -1}, // cursors are only valid on the server issuing them, never on a replica
+
+	// strings
+	"GET":         readKeys(0, 0, 1),
+	"SET":         keys(0, 0, 1),
+	"SETNX":       keys(0, 0, 1),
+	"SETEX":       keys(0, 0, 1),
+	"PSETEX":      keys(0, 0, 1),
+	"GETSET":      keys(0, 0, 1),
+	"GETDEL":      keys(0, 0, 1),
+	"GETEX":       keys(0, 0, 1),
+	"APPEND":      keys(0, 0, 1),
+	"STRLEN":      readKeys(0, 0, 1),
+	"INCR":        keys(0, 0, 1),
+	"INCRBY":      keys(0, 0, 1),
+	"INCRBYFLOAT": keys(0, 0, 1),
+	"DECR":        keys(0, 0, 1),
+	"DECRBY":      keys(0, 0, 1),
+	"MGET":        readKeys(0, -1, 1),
+	"MSET":        keys(0, -1, 2),
+	"MSETNX":      keys(0, -1, 2),
+	"GETRANGE":    readKeys(0, 0, 1),
+	"SETRANGE":    keys(0, 0, 1),
+	"GETBIT":      readKeys(0, 0, 1),
+	"SETBIT":      keys(0, 0, 1),
+	"BITCOUNT":    readKeys(0, 0, 1),
+	"BITPOS":      readKeys(0, 0, 1),
+
+	// hashes
+	"HGET":         readKeys(0, 0, 1),
+	"HSET":         keys(0, 0, 1),
+	"HSETNX":       keys(0, 0, 1),
+	"HMSET":        keys(0, 0, 1),
+	"HMGET":        readKeys(0, 0, 1),
+	"HGETALL":      readKeys(0, 0, 1),
+	"HDEL":         keys(0, 0, 1),
+	"HEXISTS":      readKeys(0, 0, 1),
+	"HINCRBY":      keys(0, 0, 1),
+	"HINCRBYFLOAT": keys(0, 0, 1),
+	"HKEYS":        readKeys(0, 0, 1),
+	"HVALS":        readKeys(0, 0, 1),
+	"HLEN":         readKeys(0, 0, 1),
+	"HSTRLEN":      readKeys(0, 0, 1),
+	"HSCAN":        keys(0, 0, 1), // a cursor, see SCAN
+
+	// lists
+	"LPUSH":      keys(0, 0, 1),
+	"RPUSH":      keys(0, 0, 1),
+	"LPUSHX":     keys(0, 0, 1),
+	"RPUSHX":     keys(0, 0, 1),
+	"LPOP":       keys(0, 0, 1),
+	"RPOP":       keys(0, 0, 1),
+	"LLEN":       readKeys(0, 0, 1),
+	"LRANGE":     readKeys(0, 0, 1),
+	"LINDEX":     readKeys(0, 0, 1),
+	"LSET":       keys(0, 0, 1),
+	"LREM":       keys(0, 0, 1),
+	"LTRIM":      keys(0, 0, 1),
+	"LINSERT":    keys(0, 0, 1),
+	"RPOPLPUSH":  keys(0, 1, 1),
+	"LMOVE":      keys(0, 1, 1),
+	"BLPOP":      keys(0, -2, 1),
+	"BRPOP":      keys(0, -2, 1),
+	"BRPOPLPUSH": keys(0, 1, 1),
+
+	// sets
+	"SADD":        keys(0, 0, 1),
+	"SREM":        keys(0, 0, 1),
+	"SPOP":        keys(0, 0, 1),
+	"SMOVE":       keys(0, 1, 1),
+	"SMEMBERS":    readKeys(0, 0, 1),
+	"SISMEMBER":   readKeys(0, 0, 1),
+	"SMISMEMBER":  readKeys(0, 0, 1),
+	"SCARD":       readKeys(0, 0, 1),
+	"SRANDMEMBER": readKeys(0, 0, 1),
+	"SSCAN":       keys(0, 0, 1), // a cursor, see SCAN
+	"SINTER":      readKeys(0, -1, 1),
+	"SUNION":      readKeys(0, -1, 1),
+	"SDIFF":       readKeys(0, -1, 1),
+	"SINTERSTORE": keys(0, -1, 1),
+	"SUNIONSTORE": keys(0, -1, 1),
+	"SDIFFSTORE":  keys(0, -1, 1),
+
+	// sorted sets
+	"ZADD":             keys(0, 0, 1),
+	"ZINCRBY":          keys(0, 0, 1),
+	"ZREM":             keys(0, 0, 1),
+	"ZREMRANGEBYRANK":  keys(0, 0, 1),
+	"ZREMRANGEBYSCORE": keys(0, 0, 1),
+	"ZREMRANGEBYLEX":   keys(0, 0, 1),
+	"ZPOPMIN":          keys(0, 0, 1),
+	"ZPOPMAX":          keys(0, 0, 1),
+	"ZSCORE":           readKeys(0, 0, 1),
+	"ZMSCORE":          readKeys(0, 0, 1),
+	"ZRANK":            readKeys(0, 0, 1),
+	"ZREVRANK":         readKeys(0, 0, 1),
+	"ZRANGE":           readKeys(0, 0, 1),
+	"ZREVRANGE":        readKeys(0, 0, 1),
+	"ZRANGEBYSCORE":    readKeys(0, 0, 1),
+	"ZREVRANGEBYSCORE": readKeys(0, 0, 1),
+	"ZRANGEBYLEX":      readKeys(0, 0, 1),
+	"ZREVRANGEBYLEX":   readKeys(0, 0, 1),
+	"ZCARD":            readKeys(0, 0, 1),
+	"ZCOUNT":           readKeys(0, 0, 1),
+	"ZLEXCOUNT":        readKeys(0, 0, 1),
+	"ZSCAN":            keys(0, 0, 1), // a cursor, see SCAN
+	"ZUNIONSTORE":      {first: 0, last: 0, step: 1, numKeys: 1},
+	"ZINTERSTORE":      {first: 0, last: 0, step: 1, numKeys: 1},
+
+	// hyperloglog
+	"PFADD":   keys(0, 0, 1),
+	"PFCOUNT": readKeys(0, -1, 1),
+	"PFMERGE": keys(0, -1, 1),
+
+	// geo
+	"GEOADD":         keys(0, 0, 1),
+	"GEODIST":        readKeys(0, 0, 1),
+	"GEOHASH":        readKeys(0, 0, 1),
+	"GEOPOS":         readKeys(0, 0, 1),
+	"GEOSEARCH":      readKeys(0, 0, 1),
+	"GEOSEARCHSTORE": keys(0, 1, 1),
+
+	// streams
+	"XADD":       keys(0, 0, 1),
+	"XDEL":       keys(0, 0, 1),
+	"XTRIM":      keys(0, 0, 1),
+	"XSETID":     keys(0, 0, 1),
+	"XLEN":       readKeys(0, 0, 1),
+	"XRANGE":     readKeys(0, 0, 1),
+	"XREVRANGE":  readKeys(0, 0, 1),
+	"XREAD":      {first: -1, numKeys: -1, streams: true, readOnly: true},
+	"XREADGROUP": {first: -1, numKeys: -1, streams: true},
+	"XGROUP":     keys(1, 1, 1),
+	"XACK":       keys(0, 0, 1),
+	"XPENDING":   readKeys(0, 0, 1),
+	"XCLAIM":     keys(0, 0, 1),
+	"XAUTOCLAIM": keys(0, 0, 1),
+	"XINFO":      readKeys(1, 1, 1),
+
+	// scripts
+	"EVAL":    {first: -1, numKeys: 1},
+	"EVALSHA": {first: -1, numKeys: 1},
+
+	// pub/sub
+	"PUBLISH":      {first: 0, last: 0, step: 1, channels: true, numKeys: -1},
+	"SUBSCRIBE":    {first: 0, last: -1, step: 1, channels: true, numKeys: -1},
+	"UNSUBSCRIBE":  {first: 0, last: -1, step: 1, channels: true, numKeys: -1},
+	"PSUBSCRIBE":   {first: 0, last: -1, step: 1, channels: true, numKeys: -1},
+	"PUNSUBSCRIBE": {first: 0, last: -1, step: 1, channels: true, numKeys: -1},
+}
+
+func lookupCommand(cmd string) (commandInfo, bool) {
+	info, ok := commandInfos[strings.ToUpper(cmd)]
+	return info, ok
+}
+
+// isReadOnly tells whether cmd never writes, so it can be sent to a replica.
+func isReadOnly(cmd string) bool {
+	info, ok := lookupCommand(cmd)
+	return ok && info.readOnly
+}
+
+// keyIndexes returns the indexes of args holding keys (or channels) of cmd.
+func keyIndexes(cmd string, args []interface{}) []int {
+	info, ok := lookupCommand(cmd)
+	if !ok {
+		return nil
+	}
+	var indexes []int
+	if info.first >= 0 {
+		last := info.last
+		if last < 0 {
+			last = len(args) + last
+		}
+		for i := info.first; i <= last && i < len(args); i += info.step {
+			indexes = append(indexes, i)
+		}
+	}
+	if info.numKeys >= 0 && info.numKeys < len(args) {
+		if n, err := strconv.Atoi(argString(args[info.numKeys])); err == nil {
+			for i := info.numKeys + 1; i <= info.numKeys+n && i < len(args); i++ {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	if info.streams {
+		indexes = append(indexes, streamsIndexes(args)...)
+	}
+	return indexes
+}
+
+// streamsIndexes returns the indexes of the keys of `XREAD` and `XREADGROUP`,
+// which are followed by as many ids after STREAMS.
+func streamsIndexes(args []interface{}) []int {
+	start := 0
+	if len(args) > 0 && strings.EqualFold(argString(args[0]), "GROUP") {
+		// skip the names of the group and of the consumer
+		start = 3
+	}
+	for i := start; i < len(args); i++ {
+		if !strings.EqualFold(argString(args[i]), "STREAMS") {
+			continue
+		}
+		n := (len(args) - i - 1) / 2
+		indexes := make([]int, 0, n)
+		for j := i + 1; j <= i+n; j++ {
+			indexes = append(indexes, j)
+		}
+		return indexes
+	}
+	return nil
+}
+
+// CommandKey returns the first key (or channel) of a command, or an empty
+// string if the command is unknown or carries no key. It is meant for hooks
+// that want to label a command, e.g. tracing spans or metrics.
+func CommandKey(cmd string, args ...interface{}) string {
+	indexes := keyIndexes(cmd, args)
+	if len(indexes) == 0 {
+		return ""
+	}
+	return argString(args[indexes[0]])
+}
+
+// CommandKeys returns all keys (or channels) of a command.
+func CommandKeys(cmd string, args ...interface{}) []string {
+	indexes := keyIndexes(cmd, args)
+	ks := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		ks = append(ks, argString(args[i]))
+	}
+	return ks
+}
+
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,33 @@
+package pRedis_test
+
+import (
+	"github.com/zzj-custom/pkg/pRedis"
+	"reflect"
+	"testing"
+)
+
+func TestCommandKeys(t *testing.T) {
+	for _, c := range []struct {
+		cmd  string
+		args []interface{}
+		want []string
+	}{
+		{"GET", []interface{}{"k"}, []string{"k"}},
+		{"MSET", []interface{}{"a", 1, "b", 2}, []string{"a", "b"}},
+		{"BLPOP", []interface{}{"a", "b", 0}, []string{"a", "b"}},
+		{"EVAL", []interface{}{"return 1", 2, "a", "b", "arg"}, []string{"a", "b"}},
+		{"ZUNIONSTORE", []interface{}{"dest", 2, "a", "b", "WEIGHTS", 1, 2}, []string{"dest", "a", "b"}},
+		{"XLEN", []interface{}{"s"}, []string{"s"}},
+		{"XREAD", []interface{}{"COUNT", 10, "BLOCK", 0, "STREAMS", "a", "b", "0-0", "$"}, []string{"a", "b"}},
+		{"XREADGROUP", []interface{}{"GROUP", "streams", "c", "STREAMS", "a", ">"}, []string{"a"}},
+		{"XGROUP", []interface{}{"CREATE", "s", "g", "$"}, []string{"s"}},
+		{"PING", nil, []string{}},
+	} {
+		if got := pRedis.CommandKeys(c.cmd, c.args...); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("CommandKeys(%s %v) = %v, want %v", c.cmd, c.args, got, c.want)
+		}
+	}
+	if key := pRedis.CommandKey("XREAD", "STREAMS", "a", "0"); key != "a" {
+		t.Errorf("CommandKey of XREAD = %q, want a", key)
+	}
+}
@@ -0,0 +1,335 @@
+package pRedis
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConfigSection = "redis"
+	defaultEnvPrefix     = "REDIS"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Usage:
+// Pools are described in a section of a TOML, YAML or JSON file, "redis" by
+// default, either as a list of MultiDialConfig or as a single DialConfig of
+// the pool named `defaultPoolName`. Durations are strings like "500ms" or
+// "3s", bare numbers are seconds. Unknown fields are errors, so that a typo
+// does not leave a field to its zero value.
+//
+// Example:
+// [[redis]]
+// name = "cache"
+// default = true
+// key-prefix = "app:"
+// [redis.config]
+// host = "127.0.0.1"
+// port = 6379
+// read-timeout = "500ms"
+// idle-timeout = 300
+//
+// err := pRedis.InitFromFile("config.toml", "")
+
+// ParseConfigs
+//
+// Decode the pools of section in data of format, "toml", "yaml" or "json",
+// and validate them with ValidateConfigs. section is a dotted path like
+// "storage.redis", "redis" if empty.
+func ParseConfigs(data []byte, format, section string) ([]*MultiDialConfig, error) {
+	var doc map[string]interface{}
+	var err error
+	switch strings.ToLower(format) {
+	case "toml":
+		err = toml.Unmarshal(data, &doc)
+	case "yaml", "yml":
+		err = yaml.Unmarshal(data, &doc)
+	case "json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return nil, errors.Errorf("unsupported config format %q", format)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %s config failed", format)
+	}
+	if section == "" {
+		section = defaultConfigSection
+	}
+	var value interface{} = doc
+	for _, name := range strings.Split(section, ".") {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("config section %s not found", section)
+		}
+		if value, ok = table[name]; !ok {
+			return nil, errors.Errorf("config section %s not found", section)
+		}
+	}
+
+	var configs []*MultiDialConfig
+	if table, ok := value.(map[string]interface{}); ok {
+		mdc := &MultiDialConfig{}
+		_, hasName := table["name"]
+		_, hasConfig := table["config"]
+		if hasName || hasConfig {
+			err = decodeConfig(table, mdc, false)
+		} else {
+			mdc.Name, mdc.Default, mdc.Config = defaultPoolName, true, &DialConfig{}
+			err = decodeConfig(table, mdc.Config, false)
+		}
+		configs = append(configs, mdc)
+	} else {
+		err = decodeConfig(value, &configs, false)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode config section %s failed", section)
+	}
+	if err = ValidateConfigs(configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// LoadConfigs
+//
+// Read the pools of section in the file at path like ParseConfigs, the format
+// is given by the extension of the file.
+func LoadConfigs(path, section string) ([]*MultiDialConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read redis config failed")
+	}
+	return ParseConfigs(data, strings.TrimPrefix(filepath.Ext(path), "."), section)
+}
+
+// LoadConfigsFromEnv
+//
+// Read the pools from environment variables named after prefix, "REDIS" if
+// empty. PREFIX_POOLS lists the names of the pools separated by commas, and
+// PREFIX_DEFAULT names the default one. Fields of a pool are read from
+// PREFIX_NAME_FIELD, where NAME and FIELD are the upper cased name of the pool
+// and toml key of the field with dashes replaced by underscores. Without
+// PREFIX_POOLS, a single pool named `defaultPoolName` is read from
+// PREFIX_FIELD. Breaker, ClientCache and Replicas cannot be set from the
+// environment.
+//
+// Example:
+//
+//	REDIS_POOLS=cache,queue
+//	REDIS_DEFAULT=cache
+//	REDIS_CACHE_HOST=10.0.0.1
+//	REDIS_CACHE_READ_TIMEOUT=500ms
+//	REDIS_QUEUE_HOST=10.0.0.2
+func LoadConfigsFromEnv(prefix string) ([]*MultiDialConfig, error) {
+	if prefix == "" {
+		prefix = defaultEnvPrefix
+	}
+	prefix = strings.TrimSuffix(prefix, "_") + "_"
+	var names []string
+	for _, name := range strings.Split(os.Getenv(prefix+"POOLS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	def := strings.TrimSpace(os.Getenv(prefix + "DEFAULT"))
+	single := len(names) == 0
+	if single {
+		names = []string{defaultPoolName}
+		def = defaultPoolName
+	}
+
+	configs := make([]*MultiDialConfig, 0, len(names))
+	for _, name := range names {
+		vars := prefix
+		if !single {
+			vars += envName(name) + "_"
+		}
+		fields := map[string]interface{}{}
+		for _, key := range envFields() {
+			if v, ok := os.LookupEnv(vars + envName(key)); ok {
+				fields[key] = v
+			}
+		}
+		mdc := &MultiDialConfig{Name: name, Default: name == def}
+		if len(fields) > 0 {
+			mdc.Config = &DialConfig{}
+			if err := decodeConfig(fields, mdc.Config, true); err != nil {
+				return nil, errors.Wrapf(err, "decode config of pool %s from environment failed", name)
+			}
+		}
+		configs = append(configs, mdc)
+	}
+	if err := ValidateConfigs(configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+func envName(s string) string {
+	return strings.ToUpper(strings.ReplaceAll(s, "-", "_"))
+}
+
+// envFields returns the keys of the scalar fields of DialConfig.
+func envFields() []string {
+	t := reflect.TypeOf(DialConfig{})
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("mapstructure")
+		switch f.Type.Kind() {
+		case reflect.String, reflect.Int, reflect.Int64, reflect.Bool:
+			if key != "" && key != "-" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// decodeConfig decodes input into output by their mapstructure tags, with
+// durations parsed by time.ParseDuration or taken as seconds if numbers.
+// weak converts strings to numbers and booleans, for environment variables.
+func decodeConfig(input, output interface{}, weak bool) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       decodeDuration,
+		ErrorUnused:      true,
+		WeaklyTypedInput: weak,
+		Result:           output,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(input)
+}
+
+func decodeDuration(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != durationType {
+		return data, nil
+	}
+	v := reflect.ValueOf(data)
+	switch from.Kind() {
+	case reflect.String:
+		s := strings.TrimSpace(v.String())
+		if secs, err := strconv.ParseFloat(s, 64); err == nil {
+			return time.Duration(secs * float64(time.Second)), nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, errors.Errorf("invalid duration %q, expect e.g. \"3s\" or a number of seconds", s)
+		}
+		return d, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return time.Duration(v.Int()) * time.Second, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return time.Duration(v.Uint()) * time.Second, nil
+	case reflect.Float32, reflect.Float64:
+		return time.Duration(v.Float() * float64(time.Second)), nil
+	}
+	return data, nil
+}
+
+// ValidateConfigs
+//
+// Check that pools are named uniquely and have a config, with a host, a valid
+// port, non-negative sizes and durations of a millisecond at least. Every
+// problem found is reported in the error.
+func ValidateConfigs(configs []*MultiDialConfig) error {
+	if len(configs) == 0 {
+		return errors.Errorf("no valid configs specified")
+	}
+	var problems []string
+	names := map[string]bool{}
+	for i, mdc := range configs {
+		if mdc == nil {
+			problems = append(problems, fmt.Sprintf("pool #%d is empty", i))
+			continue
+		}
+		pool := mdc.Name
+		switch {
+		case pool == "":
+			pool = fmt.Sprintf("#%d", i)
+			problems = append(problems, fmt.Sprintf("pool %s: name is required", pool))
+		case names[pool]:
+			problems = append(problems, fmt.Sprintf("pool %s: name is duplicated", pool))
+		}
+		names[mdc.Name] = true
+		if mdc.Config == nil {
+			problems = append(problems, fmt.Sprintf("pool %s: config is required", pool))
+		} else {
+			problems = append(problems, validateDialConfig("pool "+pool, mdc.Config)...)
+		}
+		for j, rc := range mdc.Replicas {
+			where := fmt.Sprintf("pool %s replica #%d", pool, j)
+			if rc == nil {
+				problems = append(problems, where+": config is required")
+				continue
+			}
+			problems = append(problems, validateDialConfig(where, rc)...)
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("invalid redis configs: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validateDialConfig(where string, config *DialConfig) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, where+": "+fmt.Sprintf(format, args...))
+	}
+	if config.Host == "" {
+		add("host is required")
+	}
+	if config.Port <= 0 || config.Port > 65535 {
+		add("port %d is out of range", config.Port)
+	}
+	if config.Database < 0 {
+		add("database %d is negative", config.Database)
+	}
+	if config.MaxIdle < 0 {
+		add("max-idle %d is negative", config.MaxIdle)
+	}
+	if config.MaxActive < 0 {
+		add("max-active %d is negative", config.MaxActive)
+	}
+	for _, problem := range config.durationProblems() {
+		add("%s", problem)
+	}
+	return problems
+}
+
+// InitFromFile
+//
+// Init the pools of section in the file at path with InitMultiPools, see
+// LoadConfigs.
+func InitFromFile(path, section string) error {
+	configs, err := LoadConfigs(path, section)
+	if err != nil {
+		return err
+	}
+	return InitMultiPools(configs)
+}
+
+// InitFromEnv
+//
+// Init the pools described by environment variables with InitMultiPools, see
+// LoadConfigsFromEnv.
+func InitFromEnv(prefix string) error {
+	configs, err := LoadConfigsFromEnv(prefix)
+	if err != nil {
+		return err
+	}
+	return InitMultiPools(configs)
+}
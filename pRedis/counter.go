@@ -0,0 +1,123 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// Granularity is the size of the buckets of a Counter, and how long they are kept.
+type Granularity struct {
+	Name string
+	Size time.Duration
+	TTL  time.Duration
+}
+
+var (
+	Minutely = Granularity{Name: "minute", Size: time.Minute, TTL: 24 * time.Hour}
+	Hourly   = Granularity{Name: "hour", Size: time.Hour, TTL: 7 * 24 * time.Hour}
+	Daily    = Granularity{Name: "day", Size: 24 * time.Hour, TTL: 90 * 24 * time.Hour}
+)
+
+type CounterPoint struct {
+	Time  time.Time
+	Value int64
+}
+
+// Counter
+//
+// Time-bucketed counters. Every increment is rolled up into a bucket of each
+// granularity at once, e.g. `pv:home:minute:1682899260`, and buckets expire
+// after the TTL of their granularity.
+//
+// Example:
+//
+//	pv := pRedis.NewCounter(pool, "pv")
+//	_ = pv.Incr("home", 1, time.Now())
+//	lastHour, _ := pv.Sum("home", pRedis.Minutely, time.Now().Add(-time.Hour), time.Now())
+type Counter struct {
+	Pool          *redis.Pool
+	Prefix        string
+	Granularities []Granularity
+}
+
+// NewCounter creates a counter with given granularities, Minutely, Hourly and
+// Daily if none given.
+func NewCounter(pool *redis.Pool, prefix string, granularities ...Granularity) *Counter {
+	if len(granularities) == 0 {
+		granularities = []Granularity{Minutely, Hourly, Daily}
+	}
+	return &Counter{Pool: pool, Prefix: prefix, Granularities: granularities}
+}
+
+// Key returns the key of the bucket of name containing t.
+func (c *Counter) Key(name string, g Granularity, t time.Time) string {
+	return c.Prefix + ":" + name + ":" + g.Name + ":" + strconv.FormatInt(t.Truncate(g.Size).Unix(), 10)
+}
+
+// Incr adds delta to the buckets of name containing t.
+func (c *Counter) Incr(name string, delta int64, t time.Time) error {
+	b := NewBatch(c.Pool)
+	results := make([]*Result, 0, len(c.Granularities))
+	for _, g := range c.Granularities {
+		key := c.Key(name, g, t)
+		results = append(results, b.Queue("INCRBY", key, delta))
+		if g.TTL > 0 {
+			b.Queue("PEXPIRE", key, ttlMillis(g.TTL))
+		}
+	}
+	if err := b.Exec(); err != nil {
+		return errors.Wrapf(err, "incr counter failed, name=%s", name)
+	}
+	for _, r := range results {
+		if err := r.Err(); err != nil {
+			return errors.Wrapf(err, "incr counter failed, name=%s", name)
+		}
+	}
+	return nil
+}
+
+// Range returns a point per bucket of granularity g between from and to, both
+// included. Missing buckets count as 0.
+func (c *Counter) Range(name string, g Granularity, from, to time.Time) ([]CounterPoint, error) {
+	var points []CounterPoint
+	var keys []interface{}
+	for t := from.Truncate(g.Size); !t.After(to); t = t.Add(g.Size) {
+		points = append(points, CounterPoint{Time: t})
+		keys = append(keys, c.Key(name, g, t))
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	conn := c.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	values, err := redis.Values(conn.Do("MGET", keys...))
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch counter failed, name=%s", name)
+	}
+	for i := range points {
+		if i >= len(values) || values[i] == nil {
+			continue
+		}
+		if points[i].Value, err = redis.Int64(values[i], nil); err != nil {
+			return nil, errors.Wrapf(err, "fetch counter failed, name=%s", name)
+		}
+	}
+	return points, nil
+}
+
+// Sum returns the total of the buckets of granularity g between from and to.
+func (c *Counter) Sum(name string, g Granularity, from, to time.Time) (int64, error) {
+	points, err := c.Range(name, g, from, to)
+	if err != nil {
+		return 0, err
+	}
+	var sum int64
+	for _, p := range points {
+		sum += p.Value
+	}
+	return sum, nil
+}
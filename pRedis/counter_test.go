@@ -0,0 +1,69 @@
+package pRedis_test
+
+import (
+	"github.com/zzj-custom/pkg/pRedis"
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	srv := newTestServer(t)
+	pv := pRedis.NewCounter(newTestPool(t, srv, nil), "pv")
+	start := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, incr := range []struct {
+		offset time.Duration
+		delta  int64
+	}{{0, 1}, {30 * time.Second, 2}, {2 * time.Minute, 4}, {time.Hour, 8}} {
+		if err := pv.Incr("home", incr.delta, start.Add(incr.offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if key := pv.Key("home", pRedis.Hourly, start.Add(59*time.Minute)); key != "pv:home:hour:1682935200" {
+		t.Fatalf("Key = %s, want the bucket of the hour", key)
+	}
+
+	points, err := pv.Range("home", pRedis.Minutely, start, start.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 || points[0].Value != 3 || points[1].Value != 0 || points[2].Value != 4 || !points[2].Time.Equal(start.Add(2*time.Minute)) {
+		t.Fatalf("Range = %v, want 3, 0 and 4", points)
+	}
+	if sum, err := pv.Sum("home", pRedis.Hourly, start, start.Add(time.Hour)); err != nil || sum != 15 {
+		t.Fatalf("Sum of the hours = %d, %v, want 15", sum, err)
+	}
+	if sum, err := pv.Sum("home", pRedis.Daily, start, start); err != nil || sum != 15 {
+		t.Fatalf("Sum of the day = %d, %v, want 15", sum, err)
+	}
+
+	// minutely buckets are kept for a day, hourly ones for a week
+	srv.Advance(25 * time.Hour)
+	if sum, err := pv.Sum("home", pRedis.Minutely, start, start.Add(time.Hour)); err != nil || sum != 0 {
+		t.Fatalf("Sum of expired minutes = %d, %v, want 0", sum, err)
+	}
+	if sum, err := pv.Sum("home", pRedis.Hourly, start, start.Add(time.Hour)); err != nil || sum != 15 {
+		t.Fatalf("Sum of the hours = %d, %v, want them kept", sum, err)
+	}
+}
+
+func TestCounterTTLBelowAMillisecond(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, nil)
+	pv := pRedis.NewCounter(pool, "pv")
+	pv.Granularities = []pRedis.Granularity{{Name: "minute", Size: time.Minute, TTL: 500 * time.Microsecond}}
+	now := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// rounded up to a millisecond, rather than expired at once
+	if err := pv.Incr("home", 1, now); err != nil {
+		t.Fatal(err)
+	}
+	granularity := pv.Granularities[0]
+	if sum, err := pv.Sum("home", granularity, now, now); err != nil || sum != 1 {
+		t.Fatalf("Sum = %d, %v, want 1", sum, err)
+	}
+	srv.Advance(time.Millisecond)
+	if sum, err := pv.Sum("home", granularity, now, now); err != nil || sum != 0 {
+		t.Fatalf("Sum after the ttl = %d, %v, want 0", sum, err)
+	}
+}
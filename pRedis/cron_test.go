@@ -0,0 +1,48 @@
+package pRedis_test
+
+import (
+	"github.com/zzj-custom/pkg/pRedis"
+	"testing"
+	"time"
+)
+
+func TestParseCronDaysOfWeek(t *testing.T) {
+	// a monday
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		expr string
+		days []time.Weekday
+	}{
+		{"0 0 * * 1-5", []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}},
+		{"0 0 * * 1-7", []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}},
+		{"0 0 * * 5-7", []time.Weekday{time.Friday, time.Saturday, time.Sunday}},
+		{"0 0 * * 7", []time.Weekday{time.Sunday}},
+		{"0 0 * * 0", []time.Weekday{time.Sunday}},
+		{"0 0 * * sat,sun", []time.Weekday{time.Saturday, time.Sunday}},
+		{"0 0 * * 1-7/3", []time.Weekday{time.Monday, time.Thursday, time.Sunday}},
+	} {
+		s, err := pRedis.ParseCron(c.expr, time.UTC)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) = %v", c.expr, err)
+		}
+		// the activations of a week, from the monday
+		var days []time.Weekday
+		for at := s.Next(monday.Add(-time.Second)); at.Before(monday.AddDate(0, 0, 7)); at = s.Next(at) {
+			days = append(days, at.Weekday())
+		}
+		if len(days) != len(c.days) {
+			t.Fatalf("%q runs on %v, want %v", c.expr, days, c.days)
+		}
+		for i := range days {
+			if days[i] != c.days[i] {
+				t.Fatalf("%q runs on %v, want %v", c.expr, days, c.days)
+			}
+		}
+	}
+
+	for _, expr := range []string{"0 0 * * 8", "0 0 * * 6-5", "0 0 * * 7-1"} {
+		if _, err := pRedis.ParseCron(expr, time.UTC); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package pRedis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const defaultEventChannel = "events"
+
+// Envelope carries an event with its metadata. On the wire it is JSON with
+// the payload inlined when the payload codec is JSONCodec, and protobuf with
+// the payload as bytes otherwise, so that any codec can be used.
+type Envelope struct {
+	ID     string            `json:"id"`
+	Type   string            `json:"type"`
+	Source string            `json:"source"`
+	Time   time.Time         `json:"time"`
+	Trace  map[string]string `json:"trace,omitempty"`
+	// Payload is the encoded event.
+	Payload []byte `json:"-"`
+}
+
+// envelope fields numbers of the protobuf format.
+const (
+	envelopeID protowire.Number = iota + 1
+	envelopeType
+	envelopeSource
+	envelopeTime
+	envelopeTrace
+	envelopePayload
+)
+
+func (e *Envelope) marshal(jsonPayload bool) ([]byte, error) {
+	if jsonPayload {
+		return json.Marshal(struct {
+			*Envelope
+			Payload json.RawMessage `json:"payload"`
+		}{e, e.Payload})
+	}
+	var b []byte
+	b = protowire.AppendTag(b, envelopeID, protowire.BytesType)
+	b = protowire.AppendString(b, e.ID)
+	b = protowire.AppendTag(b, envelopeType, protowire.BytesType)
+	b = protowire.AppendString(b, e.Type)
+	b = protowire.AppendTag(b, envelopeSource, protowire.BytesType)
+	b = protowire.AppendString(b, e.Source)
+	b = protowire.AppendTag(b, envelopeTime, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(e.Time.UnixNano()))
+	for k, v := range e.Trace {
+		// map entries are messages of a key and a value
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, v)
+		b = protowire.AppendTag(b, envelopeTrace, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	b = protowire.AppendTag(b, envelopePayload, protowire.BytesType)
+	b = protowire.AppendBytes(b, e.Payload)
+	return b, nil
+}
+
+func unmarshalEnvelope(data []byte) (*Envelope, error) {
+	e := &Envelope{}
+	if len(data) > 0 && data[0] == '{' {
+		var v struct {
+			*Envelope
+			Payload json.RawMessage `json:"payload"`
+		}
+		v.Envelope = e
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		e.Payload = v.Payload
+		return e, nil
+	}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch num {
+		case envelopeID:
+			e.ID = string(value)
+		case envelopeType:
+			e.Type = string(value)
+		case envelopeSource:
+			e.Source = string(value)
+		case envelopeTime:
+			e.Time = time.Unix(0, int64(varint))
+		case envelopeTrace:
+			k, v, err := unmarshalTraceEntry(value)
+			if err != nil {
+				return nil, err
+			}
+			if e.Trace == nil {
+				e.Trace = map[string]string{}
+			}
+			e.Trace[k] = v
+		case envelopePayload:
+			e.Payload = value
+		}
+	}
+	return e, nil
+}
+
+func unmarshalTraceEntry(data []byte) (key, value string, err error) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 || typ != protowire.BytesType {
+			return "", "", errors.Errorf("invalid trace entry")
+		}
+		data = data[n:]
+		v, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			value = string(v)
+		}
+	}
+	return key, value, nil
+}
+
+// EventHandler handles an event decoded into a T, see On.
+type EventHandler[T any] func(ctx context.Context, env *Envelope, event T) error
+
+type EventBusOptions struct {
+	Ctx  context.Context
+	Pool *redis.Pool
+	// Channel the events are published to, "events" by default. Buses
+	// sharing a channel receive the events of each other.
+	Channel string
+	// Source names the publisher in envelopes, hostname:pid by default.
+	Source string
+	// Codec encodes payloads, DefaultCodec if nil, see ProtoCodec.
+	Codec Codec
+	// TraceInject returns the trace context of ctx to propagate in envelopes,
+	// e.g. the W3C traceparent. TraceExtract restores it in the context given
+	// to handlers.
+	TraceInject  func(ctx context.Context) map[string]string
+	TraceExtract func(ctx context.Context, trace map[string]string) context.Context
+	// OnError is called when an event cannot be decoded or a handler fails,
+	// errors are logged if nil.
+	OnError func(env *Envelope, err error)
+	// RestartDuration and PingDuration are passed to the Subscription.
+	RestartDuration time.Duration
+	PingDuration    time.Duration
+}
+
+type eventHandler func(ctx context.Context, env *Envelope) error
+
+// Usage:
+// Create a bus with NewEventBus, register handlers by event type with On,
+// then call Start. Events published by Publish are delivered to every bus
+// listening to the channel, including the publisher, and every handler of
+// their type runs in turn.
+//
+// Example:
+// bus, _ := pRedis.NewEventBus(pRedis.EventBusOptions{Ctx: ctx, Pool: pool})
+// pRedis.On(bus, "order.created", func(ctx context.Context, env *pRedis.Envelope, o *Order) error {
+//     return notify(ctx, o)
+// })
+// go bus.Start()
+// _, err := bus.Publish(ctx, "order.created", order)
+
+type EventBus struct {
+	opts EventBusOptions
+	sub  *Subscription
+
+	mu       sync.RWMutex
+	handlers map[string][]eventHandler
+}
+
+func NewEventBus(opts EventBusOptions) (*EventBus, error) {
+	if opts.Ctx == nil {
+		return nil, errors.Errorf("context must be specified")
+	}
+	if opts.Pool == nil {
+		return nil, errors.Errorf("redis pool must be specified")
+	}
+	if opts.Channel == "" {
+		opts.Channel = defaultEventChannel
+	}
+	if opts.Source == "" {
+		host, _ := os.Hostname()
+		opts.Source = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	if opts.Codec == nil {
+		opts.Codec = DefaultCodec
+	}
+	sub, err := NewSubscription(Options{
+		Ctx:             opts.Ctx,
+		Pool:            opts.Pool,
+		RestartDuration: opts.RestartDuration,
+		PingDuration:    opts.PingDuration,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &EventBus{opts: opts, sub: sub, handlers: map[string][]eventHandler{}}, nil
+}
+
+// On
+//
+// Register handler for the events of eventType, decoded into a T by the codec
+// of bus. T may be a pointer, e.g. a protobuf message. Handlers of a type run
+// in the order they were registered, a failing handler does not stop the
+// others.
+func On[T any](bus *EventBus, eventType string, handler EventHandler[T]) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.handlers[eventType] = append(bus.handlers[eventType], func(ctx context.Context, env *Envelope) error {
+		event, err := decodeEvent[T](bus.opts.Codec, env.Payload)
+		if err != nil {
+			return errors.Wrapf(err, "decode event %s failed", env.Type)
+		}
+		return handler(ctx, env, event)
+	})
+}
+
+// decodeEvent decodes into a T, allocating it first if it is a pointer.
+func decodeEvent[T any](codec Codec, data []byte) (T, error) {
+	var v T
+	target := interface{}(&v)
+	if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem()).Interface().(T)
+		target = v
+	}
+	err := codec.Unmarshal(data, target)
+	return v, err
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate event id failed")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Publish sends event with the type eventType to every bus of the channel,
+// and returns its envelope.
+func (b *EventBus) Publish(ctx context.Context, eventType string, event interface{}) (*Envelope, error) {
+	payload, err := b.opts.Codec.Marshal(event)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encode event %s failed", eventType)
+	}
+	id, err := newEventID()
+	if err != nil {
+		return nil, err
+	}
+	env := &Envelope{
+		ID:      id,
+		Type:    eventType,
+		Source:  b.opts.Source,
+		Time:    time.Now(),
+		Payload: payload,
+	}
+	if b.opts.TraceInject != nil {
+		env.Trace = b.opts.TraceInject(ctx)
+	}
+	_, jsonPayload := b.opts.Codec.(JSONCodec)
+	data, err := env.marshal(jsonPayload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encode envelope of %s failed", eventType)
+	}
+
+	conn, err := b.opts.Pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err = conn.Do("PUBLISH", b.opts.Channel, data); err != nil {
+		return nil, errors.Wrapf(err, "publish event %s failed", eventType)
+	}
+	return env, nil
+}
+
+// Start subscribes to the channel and dispatches events until the context of
+// the bus is done, like `Subscription.Start`. It returns once the event being
+// dispatched, if any, is handled.
+func (b *EventBus) Start() error {
+	if err := b.sub.Subscribe(b.opts.Channel, b.dispatch); err != nil {
+		return err
+	}
+	b.sub.Start()
+	return nil
+}
+
+func (b *EventBus) dispatch(ctx context.Context, msg redis.Message) error {
+	env, err := unmarshalEnvelope(msg.Data)
+	if err != nil {
+		b.fail(nil, errors.Wrap(err, "decode envelope failed"))
+		return nil
+	}
+	b.mu.RLock()
+	handlers := b.handlers[env.Type]
+	b.mu.RUnlock()
+	if len(handlers) == 0 {
+		return nil
+	}
+	if b.opts.TraceExtract != nil && len(env.Trace) > 0 {
+		ctx = b.opts.TraceExtract(ctx, env.Trace)
+	}
+	for _, h := range handlers {
+		if err = safeHandle(ctx, env, h); err != nil {
+			b.fail(env, err)
+		}
+	}
+	return nil
+}
+
+func safeHandle(ctx context.Context, env *Envelope, h eventHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("event handler panic: %v", r)
+		}
+	}()
+	return h(ctx, env)
+}
+
+func (b *EventBus) fail(env *Envelope, err error) {
+	if b.opts.OnError != nil {
+		b.opts.OnError(env, err)
+		return
+	}
+	entry := log.WithError(err)
+	if env != nil {
+		entry = entry.WithField("event", env.Type).WithField("id", env.ID)
+	}
+	entry.Error("failed to handle event")
+}
@@ -0,0 +1,90 @@
+package pRedis_test
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/zzj-custom/pkg/pRedis"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testOrder struct {
+	ID    int    `json:"id"`
+	Buyer string `json:"buyer"`
+}
+
+type traceKey struct{}
+
+func TestEventBus(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var orders []*testOrder
+	var traces []string
+	var failures []error
+	bus, err := pRedis.NewEventBus(pRedis.EventBusOptions{
+		Ctx:  ctx,
+		Pool: pool,
+		TraceInject: func(ctx context.Context) map[string]string {
+			return map[string]string{"traceparent": ctx.Value(traceKey{}).(string)}
+		},
+		TraceExtract: func(ctx context.Context, trace map[string]string) context.Context {
+			return context.WithValue(ctx, traceKey{}, trace["traceparent"])
+		},
+		OnError: func(env *pRedis.Envelope, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failures = append(failures, err)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pRedis.On(bus, "order.created", func(ctx context.Context, env *pRedis.Envelope, o *testOrder) error {
+		mu.Lock()
+		defer mu.Unlock()
+		orders = append(orders, o)
+		traces = append(traces, ctx.Value(traceKey{}).(string))
+		return nil
+	})
+	pRedis.On(bus, "order.created", func(ctx context.Context, env *pRedis.Envelope, o testOrder) error {
+		return errors.New("second handler failed")
+	})
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- bus.Start()
+	}()
+
+	// publish until the subscription is up
+	published := context.WithValue(ctx, traceKey{}, "00-trace-01")
+	waitFor(t, "the event", func() bool {
+		if _, err := bus.Publish(published, "order.created", testOrder{ID: 1, Buyer: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		return len(orders) > 0
+	})
+	cancel()
+	select {
+	case err = <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after the context was done")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if *orders[0] != (testOrder{ID: 1, Buyer: "alice"}) || traces[0] != "00-trace-01" {
+		t.Fatalf("handled %+v with trace %q, want the published order and its trace", orders[0], traces[0])
+	}
+	if len(failures) == 0 || errors.Cause(failures[0]).Error() != "second handler failed" {
+		t.Fatalf("failures = %v, want the error of the second handler", failures)
+	}
+}
@@ -0,0 +1,11 @@
+package pRedis
+
+// BreakerCount returns the number of pools with a circuit breaker.
+func BreakerCount() int {
+	n := 0
+	poolBreakers.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
@@ -0,0 +1,345 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+type GeoUnit string
+
+const (
+	GeoMeters     GeoUnit = "m"
+	GeoKilometers GeoUnit = "km"
+	GeoMiles      GeoUnit = "mi"
+	GeoFeet       GeoUnit = "ft"
+)
+
+type GeoLocation struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+}
+
+type GeoResult struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+	// Distance from the center of the search, in the unit of the query.
+	Distance float64
+	// Hash is the 52 bits geohash of the position, i.e. its score.
+	Hash int64
+}
+
+// GeoQuery searches members within Radius, or within a box of Width and
+// Height, around Member or around Longitude and Latitude.
+type GeoQuery struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+	Radius    float64
+	Width     float64
+	Height    float64
+	// Unit of distances, meters by default.
+	Unit GeoUnit
+	// Desc sorts results from the farthest, they are sorted from the nearest
+	// by default.
+	Desc bool
+	// Offset and Limit select a page of results, all results are returned if
+	// Limit is 0.
+	Offset int
+	Limit  int
+}
+
+type GeoPage struct {
+	Results []GeoResult
+	// HasMore tells whether results follow the page.
+	HasMore bool
+}
+
+// GeoIndex
+//
+// Positions of members in a geo set, with metadata of members kept as
+// encoded values in the companion hash MetaKey. Searches need redis 6.2 or
+// later for `GEOSEARCH`.
+//
+// Example:
+//
+//	shops := pRedis.NewGeoIndex(pool, "geo:shops")
+//	_ = shops.AddWithMeta(pRedis.GeoLocation{Member: "shop:1", Longitude: 116.40, Latitude: 39.90}, shop)
+//	page, _ := shops.Search(pRedis.GeoQuery{Longitude: lon, Latitude: lat, Radius: 3, Unit: pRedis.GeoKilometers, Limit: 20})
+//	metas, _ := pRedis.GeoMetas[Shop](shops, page.Members()...)
+type GeoIndex struct {
+	Pool    *redis.Pool
+	Key     string
+	MetaKey string
+	// Codec of metadata, DefaultCodec if nil.
+	Codec Codec
+}
+
+func NewGeoIndex(pool *redis.Pool, key string) *GeoIndex {
+	return &GeoIndex{Pool: pool, Key: key, MetaKey: key + ":meta"}
+}
+
+func (g *GeoIndex) codec() Codec {
+	return pickCodec([]Codec{g.Codec})
+}
+
+// Add adds or moves locations and returns the number of added members.
+func (g *GeoIndex) Add(locations ...GeoLocation) (int64, error) {
+	if len(locations) == 0 {
+		return 0, nil
+	}
+	args := redis.Args{}.Add(g.Key)
+	for _, l := range locations {
+		args = args.Add(l.Longitude, l.Latitude, l.Member)
+	}
+	conn := g.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	n, err := redis.Int64(conn.Do("GEOADD", args...))
+	if err != nil {
+		return 0, errors.Wrapf(err, "add locations failed, key=%s", g.Key)
+	}
+	return n, nil
+}
+
+// AddWithMeta adds or moves location and sets the metadata of its member
+// atomically.
+func (g *GeoIndex) AddWithMeta(location GeoLocation, meta interface{}) error {
+	data, err := g.codec().Marshal(meta)
+	if err != nil {
+		return errors.Wrapf(err, "encode metadata of %s failed", location.Member)
+	}
+	b := NewBatch(g.Pool)
+	add := b.Queue("GEOADD", g.Key, location.Longitude, location.Latitude, location.Member)
+	set := b.Queue("HSET", g.MetaKey, location.Member, data)
+	if err = b.ExecTx(); err == nil {
+		if err = add.Err(); err == nil {
+			err = set.Err()
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "add location failed, key=%s", g.Key)
+	}
+	return nil
+}
+
+// SetMeta sets the metadata of member.
+func (g *GeoIndex) SetMeta(member string, meta interface{}) error {
+	data, err := g.codec().Marshal(meta)
+	if err != nil {
+		return errors.Wrapf(err, "encode metadata of %s failed", member)
+	}
+	conn := g.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Do("HSET", g.MetaKey, member, data)
+	return err
+}
+
+// Meta decodes the metadata of member into v, redis.ErrNil is returned if
+// member has none.
+func (g *GeoIndex) Meta(member string, v interface{}) error {
+	conn := g.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	data, err := redis.Bytes(conn.Do("HGET", g.MetaKey, member))
+	if err != nil {
+		return err
+	}
+	return g.codec().Unmarshal(data, v)
+}
+
+// GeoMetas
+//
+// Fetch the metadata of members of g, members without metadata are missing
+// from the result.
+func GeoMetas[T any](g *GeoIndex, members ...string) (map[string]T, error) {
+	metas := make(map[string]T, len(members))
+	if len(members) == 0 {
+		return metas, nil
+	}
+	conn := g.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	values, err := redis.ByteSlices(conn.Do("HMGET", redis.Args{}.Add(g.MetaKey).AddFlat(members)...))
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch metadata failed, key=%s", g.MetaKey)
+	}
+	codec := g.codec()
+	for i, data := range values {
+		if data == nil {
+			continue
+		}
+		var v T
+		if err = codec.Unmarshal(data, &v); err != nil {
+			return nil, errors.Wrapf(err, "decode metadata of %s failed", members[i])
+		}
+		metas[members[i]] = v
+	}
+	return metas, nil
+}
+
+// Remove removes members and their metadata.
+func (g *GeoIndex) Remove(members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	b := NewBatch(g.Pool)
+	b.Queue("ZREM", redis.Args{}.Add(g.Key).AddFlat(members)...)
+	b.Queue("HDEL", redis.Args{}.Add(g.MetaKey).AddFlat(members)...)
+	return b.ExecTx()
+}
+
+// Position returns the positions of members, nil for missing ones.
+func (g *GeoIndex) Position(members ...string) ([]*GeoLocation, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+	conn := g.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	positions, err := redis.Positions(conn.Do("GEOPOS", redis.Args{}.Add(g.Key).AddFlat(members)...))
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch positions failed, key=%s", g.Key)
+	}
+	locations := make([]*GeoLocation, len(members))
+	for i, p := range positions {
+		if p != nil {
+			locations[i] = &GeoLocation{Member: members[i], Longitude: p[0], Latitude: p[1]}
+		}
+	}
+	return locations, nil
+}
+
+// Distance returns the distance between two members in unit, meters if
+// empty. redis.ErrNil is returned if one of them is missing.
+func (g *GeoIndex) Distance(from, to string, unit GeoUnit) (float64, error) {
+	if unit == "" {
+		unit = GeoMeters
+	}
+	conn := g.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	return redis.Float64(conn.Do("GEODIST", g.Key, from, to, string(unit)))
+}
+
+// Hash returns the standard geohash strings of members, empty for missing
+// ones.
+func (g *GeoIndex) Hash(members ...string) ([]string, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+	conn := g.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	return redis.Strings(conn.Do("GEOHASH", redis.Args{}.Add(g.Key).AddFlat(members)...))
+}
+
+// Search returns a page of members matching q, with their positions and
+// distances to the center.
+func (g *GeoIndex) Search(q GeoQuery) (GeoPage, error) {
+	var page GeoPage
+	if q.Unit == "" {
+		q.Unit = GeoMeters
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	args := redis.Args{}.Add(g.Key)
+	if q.Member != "" {
+		args = args.Add("FROMMEMBER", q.Member)
+	} else {
+		args = args.Add("FROMLONLAT", q.Longitude, q.Latitude)
+	}
+	switch {
+	case q.Radius > 0:
+		args = args.Add("BYRADIUS", q.Radius, string(q.Unit))
+	case q.Width > 0 && q.Height > 0:
+		args = args.Add("BYBOX", q.Width, q.Height, string(q.Unit))
+	default:
+		return page, errors.Errorf("geo search needs a radius or a box")
+	}
+	if q.Desc {
+		args = args.Add("DESC")
+	} else {
+		args = args.Add("ASC")
+	}
+	if q.Limit > 0 {
+		// one more tells whether a next page exists
+		args = args.Add("COUNT", q.Offset+q.Limit+1)
+	}
+	args = args.Add("WITHDIST", "WITHHASH", "WITHCOORD")
+
+	conn := g.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	items, err := redis.Values(conn.Do("GEOSEARCH", args...))
+	if err != nil {
+		return page, errors.Wrapf(err, "geo search failed, key=%s", g.Key)
+	}
+	if q.Offset >= len(items) {
+		return page, nil
+	}
+	items = items[q.Offset:]
+	if q.Limit > 0 && len(items) > q.Limit {
+		items, page.HasMore = items[:q.Limit], true
+	}
+	page.Results = make([]GeoResult, 0, len(items))
+	for _, item := range items {
+		r, err := parseGeoResult(item)
+		if err != nil {
+			return GeoPage{}, errors.Wrapf(err, "geo search failed, key=%s", g.Key)
+		}
+		page.Results = append(page.Results, r)
+	}
+	return page, nil
+}
+
+// parseGeoResult parses an item replied with WITHDIST, WITHHASH and
+// WITHCOORD, in this order.
+func parseGeoResult(item interface{}) (GeoResult, error) {
+	var r GeoResult
+	values, err := redis.Values(item, nil)
+	if err != nil {
+		return r, err
+	}
+	if len(values) != 4 {
+		return r, errors.Errorf("unexpected geo search item of %d values", len(values))
+	}
+	if r.Member, err = redis.String(values[0], nil); err != nil {
+		return r, err
+	}
+	if r.Distance, err = redis.Float64(values[1], nil); err != nil {
+		return r, err
+	}
+	if r.Hash, err = redis.Int64(values[2], nil); err != nil {
+		return r, err
+	}
+	coord, err := redis.Float64s(values[3], nil)
+	if err != nil {
+		return r, err
+	}
+	if len(coord) != 2 {
+		return r, errors.Errorf("unexpected geo coordinates %v", coord)
+	}
+	r.Longitude, r.Latitude = coord[0], coord[1]
+	return r, nil
+}
+
+// Members returns the members of the results in order.
+func (p GeoPage) Members() []string {
+	members := make([]string, len(p.Results))
+	for i, r := range p.Results {
+		members[i] = r.Member
+	}
+	return members
+}
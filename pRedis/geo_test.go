@@ -0,0 +1,81 @@
+package pRedis_test
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type testShop struct {
+	Name string `json:"name"`
+}
+
+func TestGeoIndex(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+	shops := pRedis.NewGeoIndex(pool, "geo:shops")
+
+	// about 1.1km apart along the latitude
+	n, err := shops.Add(
+		pRedis.GeoLocation{Member: "a", Longitude: 116.40, Latitude: 39.900},
+		pRedis.GeoLocation{Member: "b", Longitude: 116.40, Latitude: 39.910},
+		pRedis.GeoLocation{Member: "c", Longitude: 116.40, Latitude: 39.920},
+	)
+	if err != nil || n != 3 {
+		t.Fatalf("Add = %d, %v, want 3", n, err)
+	}
+	if err = shops.AddWithMeta(pRedis.GeoLocation{Member: "far", Longitude: 121.47, Latitude: 31.23}, testShop{Name: "Shanghai"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = shops.SetMeta("a", testShop{Name: "A"}); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := shops.Distance("a", "b", pRedis.GeoKilometers)
+	if err != nil || math.Abs(d-1.11) > 0.01 {
+		t.Fatalf("Distance = %f, %v, want about 1.11km", d, err)
+	}
+	if _, err = shops.Distance("a", "missing", ""); err != redis.ErrNil {
+		t.Fatalf("Distance to a missing member = %v, want ErrNil", err)
+	}
+	positions, err := shops.Position("b", "missing")
+	if err != nil || len(positions) != 2 || positions[1] != nil || math.Abs(positions[0].Latitude-39.91) > 1e-4 {
+		t.Fatalf("Position = %v, %v, want b only", positions, err)
+	}
+
+	page, err := shops.Search(pRedis.GeoQuery{Member: "a", Radius: 5, Unit: pRedis.GeoKilometers, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(page.Members(), []string{"a", "b"}) || !page.HasMore {
+		t.Fatalf("first page = %v, more: %v, want [a b] and more", page.Members(), page.HasMore)
+	}
+	page, err = shops.Search(pRedis.GeoQuery{Longitude: 116.40, Latitude: 39.90, Radius: 5, Unit: pRedis.GeoKilometers, Offset: 2, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(page.Members(), []string{"c"}) || page.HasMore {
+		t.Fatalf("second page = %v, more: %v, want [c] and no more", page.Members(), page.HasMore)
+	}
+	if r := page.Results[0]; math.Abs(r.Distance-2.22) > 0.01 || r.Hash == 0 {
+		t.Fatalf("result = %+v, want its distance and hash", r)
+	}
+
+	metas, err := pRedis.GeoMetas[testShop](shops, "a", "b", "far")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]testShop{"a": {Name: "A"}, "far": {Name: "Shanghai"}}
+	if !reflect.DeepEqual(metas, want) {
+		t.Fatalf("GeoMetas = %v, want %v", metas, want)
+	}
+
+	if err = shops.Remove("far"); err != nil {
+		t.Fatal(err)
+	}
+	var meta testShop
+	if err = shops.Meta("far", &meta); err != redis.ErrNil {
+		t.Fatalf("Meta of a removed member = %v, want ErrNil", err)
+	}
+}
@@ -0,0 +1,138 @@
+package pRedis
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gomodule/redigo/redis"
+	"net/http"
+	"time"
+)
+
+const defaultHealthCheckTimeout = 2 * time.Second
+
+type PoolHealth struct {
+	Name    string
+	Healthy bool
+	// Latency of the `PING`.
+	Latency time.Duration
+	Error   string
+	// ActiveCount and IdleCount are the connection counts of the pool.
+	ActiveCount int
+	IdleCount   int
+}
+
+func (h PoolHealth) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name        string `json:"name"`
+		Healthy     bool   `json:"healthy"`
+		Latency     string `json:"latency"`
+		Error       string `json:"error,omitempty"`
+		ActiveCount int    `json:"active"`
+		IdleCount   int    `json:"idle"`
+	}{h.Name, h.Healthy, h.Latency.String(), h.Error, h.ActiveCount, h.IdleCount})
+}
+
+type HealthReport struct {
+	// Healthy is true when every pool is healthy.
+	Healthy bool         `json:"healthy"`
+	Pools   []PoolHealth `json:"pools"`
+}
+
+// HealthCheck
+//
+// Ping every registered pool concurrently. A pool not answering before ctx
+// is done is unhealthy, if ctx has no deadline a timeout of 2 seconds is used.
+// A pool whose circuit breaker is open is unhealthy without being pinged.
+func HealthCheck(ctx context.Context) HealthReport {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultHealthCheckTimeout)
+		defer cancel()
+	}
+	names := Names()
+	results := make(chan PoolHealth, len(names))
+	for _, name := range names {
+		go func(name string) {
+			results <- checkPool(ctx, name)
+		}(name)
+	}
+
+	report := HealthReport{Healthy: true, Pools: make([]PoolHealth, len(names))}
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		index[name] = i
+		report.Pools[i] = PoolHealth{Name: name}
+	}
+	for range names {
+		select {
+		case h := <-results:
+			report.Pools[index[h.Name]] = h
+		case <-ctx.Done():
+			// pools that did not answer keep their zero health
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	for i := range report.Pools {
+		h := &report.Pools[i]
+		if !h.Healthy {
+			if h.Error == "" && ctx.Err() != nil {
+				h.Error = ctx.Err().Error()
+			}
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+func checkPool(ctx context.Context, name string) PoolHealth {
+	h := PoolHealth{Name: name}
+	pool, err := Pool(name)
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	// commands of an open circuit may be answered by a fallback, so PING
+	// succeeds while redis is down
+	if b := poolBreaker(pool); b != nil && b.State() == BreakerOpen {
+		stats := pool.Stats()
+		h.ActiveCount, h.IdleCount = stats.ActiveCount, stats.IdleCount
+		h.Error = ErrCircuitOpen.Error()
+		return h
+	}
+	start := time.Now()
+	conn, err := pool.GetContext(ctx)
+	if err == nil {
+		timeout := defaultHealthCheckTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		_, err = redis.DoWithTimeout(conn, timeout, "PING")
+		_ = conn.Close()
+	}
+	h.Latency = time.Since(start)
+	stats := pool.Stats()
+	h.ActiveCount, h.IdleCount = stats.ActiveCount, stats.IdleCount
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	h.Healthy = true
+	return h
+}
+
+// HealthHandler returns a handler for readiness probes, answering the
+// HealthReport as JSON with status 200 if every pool is healthy, 503 if not.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := HealthCheck(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if report.Healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,69 @@
+package pRedis_test
+
+import (
+	"context"
+	"github.com/zzj-custom/pkg/pRedis"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// poolHealth runs HealthCheck and returns the health of the pool with name,
+// pools of other tests are registered too.
+func poolHealth(t *testing.T, name string) pRedis.PoolHealth {
+	t.Helper()
+	for _, h := range pRedis.HealthCheck(context.Background()).Pools {
+		if h.Name == name {
+			return h
+		}
+	}
+	t.Fatalf("no health of pool %s", name)
+	return pRedis.PoolHealth{}
+}
+
+func TestHealthCheckOpenCircuit(t *testing.T) {
+	srv := newTestServer(t)
+	var fail int32
+	config := srv.DialConfig()
+	config.Breaker = &pRedis.BreakerOptions{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Minute,
+		Fallback:         pRedis.CacheMissFallback,
+	}
+	config.Hooks = []pRedis.Hook{pRedis.FaultHook(func(cmd string, args []interface{}) error {
+		if cmd == "GET" && atomic.LoadInt32(&fail) == 1 {
+			return io.EOF
+		}
+		return nil
+	})}
+	pool, err := pRedis.InitPool("test-health", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := poolHealth(t, "test-health"); !h.Healthy {
+		t.Fatalf("health = %+v, want healthy", h)
+	}
+
+	atomic.StoreInt32(&fail, 1)
+	conn := pool.Get()
+	_, _ = conn.Do("GET", "k")
+	_ = conn.Close()
+	b, err := pRedis.Breaker("test-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.State() != pRedis.BreakerOpen {
+		t.Fatalf("state = %s, want open", b.State())
+	}
+	// the fallback answers the PING, the pool is down anyway
+	h := poolHealth(t, "test-health")
+	if h.Healthy || h.Error != pRedis.ErrCircuitOpen.Error() {
+		t.Fatalf("health with an open circuit = %+v, want unhealthy", h)
+	}
+
+	b.Reset()
+	if h = poolHealth(t, "test-health"); !h.Healthy {
+		t.Fatalf("health after Reset = %+v, want healthy", h)
+	}
+}
@@ -0,0 +1,107 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"strconv"
+	"time"
+)
+
+// HyperLogLog
+//
+// Count unique items per time bucket with `PFADD`, each bucket lives in its own
+// key, e.g. `uv:1682899200` for a daily bucket, and expires after TTL. Counts
+// over several buckets are the size of their union.
+//
+// Example:
+//
+//	uv := pRedis.NewHyperLogLog(pool, "uv", 24*time.Hour, 90*24*time.Hour)
+//	_ = uv.Add(time.Now(), userID)
+//	weekly, _ := uv.Count(time.Now().AddDate(0, 0, -6), time.Now())
+type HyperLogLog struct {
+	Pool   *redis.Pool
+	Prefix string
+	Bucket time.Duration
+	TTL    time.Duration
+}
+
+func NewHyperLogLog(pool *redis.Pool, prefix string, bucket, ttl time.Duration) *HyperLogLog {
+	if bucket <= 0 {
+		bucket = 24 * time.Hour
+	}
+	return &HyperLogLog{Pool: pool, Prefix: prefix, Bucket: bucket, TTL: ttl}
+}
+
+// Key returns the key of the bucket containing t.
+func (h *HyperLogLog) Key(t time.Time) string {
+	return h.Prefix + ":" + strconv.FormatInt(t.Truncate(h.Bucket).Unix(), 10)
+}
+
+// keys returns the keys of every bucket between from and to, both included.
+func (h *HyperLogLog) keys(from, to time.Time) []interface{} {
+	var keys []interface{}
+	for t := from.Truncate(h.Bucket); !t.After(to); t = t.Add(h.Bucket) {
+		keys = append(keys, h.Key(t))
+	}
+	return keys
+}
+
+// Add adds items to the bucket containing t.
+func (h *HyperLogLog) Add(t time.Time, items ...string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	key := h.Key(t)
+	args := redis.Args{}.Add(key).AddFlat(items)
+	conn := h.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if h.TTL <= 0 {
+		_, err := conn.Do("PFADD", args...)
+		return err
+	}
+	b := &Batch{}
+	add := b.Queue("PFADD", args...)
+	b.Queue("PEXPIRE", key, ttlMillis(h.TTL))
+	if err := b.exec(conn); err != nil {
+		return err
+	}
+	return add.Err()
+}
+
+// Count returns the approximate number of unique items added between from
+// and to.
+func (h *HyperLogLog) Count(from, to time.Time) (int64, error) {
+	keys := h.keys(from, to)
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	conn := h.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	return redis.Int64(conn.Do("PFCOUNT", keys...))
+}
+
+// Merge stores the union of the buckets between from and to into dest, which
+// expires after ttl if ttl is positive. It is useful to keep rollups of
+// windows whose buckets expire.
+func (h *HyperLogLog) Merge(dest string, from, to time.Time, ttl time.Duration) error {
+	keys := h.keys(from, to)
+	if len(keys) == 0 {
+		return nil
+	}
+	conn := h.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	b := &Batch{}
+	merge := b.Queue("PFMERGE", append([]interface{}{dest}, keys...)...)
+	if ttl > 0 {
+		b.Queue("PEXPIRE", dest, ttlMillis(ttl))
+	}
+	if err := b.exec(conn); err != nil {
+		return err
+	}
+	return merge.Err()
+}
@@ -0,0 +1,52 @@
+package pRedis_test
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"testing"
+	"time"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, nil)
+	uv := pRedis.NewHyperLogLog(pool, "uv", 24*time.Hour, 48*time.Hour)
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	if key := uv.Key(day.Add(13 * time.Hour)); key != fmt.Sprintf("uv:%d", day.Unix()) {
+		t.Fatalf("Key = %s, want the key of the day", key)
+	}
+	if err := uv.Add(day, "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := uv.Add(day.Add(25*time.Hour), "bob", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := uv.Count(day, day.Add(time.Hour)); err != nil || n != 2 {
+		t.Fatalf("Count of a day = %d, %v, want 2", n, err)
+	}
+	if n, err := uv.Count(day, day.Add(47*time.Hour)); err != nil || n != 3 {
+		t.Fatalf("Count of two days = %d, %v, want the size of the union, 3", n, err)
+	}
+	if n, err := uv.Count(day.Add(25*time.Hour), day); err != nil || n != 0 {
+		t.Fatalf("Count of an empty range = %d, %v, want 0", n, err)
+	}
+
+	if err := uv.Merge("uv:week", day, day.Add(6*24*time.Hour), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	if n, err := redis.Int(conn.Do("PFCOUNT", "uv:week")); err != nil || n != 3 {
+		t.Fatalf("PFCOUNT of the merge = %d, %v, want 3", n, err)
+	}
+
+	srv.Advance(49 * time.Hour)
+	if n, err := uv.Count(day, day.Add(47*time.Hour)); err != nil || n != 0 {
+		t.Fatalf("Count after the ttl = %d, %v, want the buckets expired", n, err)
+	}
+	if ok, _ := redis.Bool(conn.Do("EXISTS", "uv:week")); ok {
+		t.Fatal("the merge outlived its ttl")
+	}
+}
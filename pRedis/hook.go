@@ -0,0 +1,321 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// DoFunc executes a command and waits for its reply, like redis.Conn.Do.
+type DoFunc func(cmd string, args ...interface{}) (interface{}, error)
+
+// SendFunc writes a command to the output buffer, like redis.Conn.Send.
+type SendFunc func(cmd string, args ...interface{}) error
+
+// ReceiveFunc reads a single reply, like redis.Conn.Receive.
+type ReceiveFunc func() (interface{}, error)
+
+// Hook
+//
+// Middleware around the commands issued through a connection. Every field is
+// optional, a nil field leaves the matching method untouched. Hooks are set by
+// `DialConfig.Hooks` and applied to every connection dialed by the pool, the
+// first hook is the outermost one.
+//
+// Pipeline observes the commands written with Send, which are the ones of
+// batches, transactions and scripts loaded in bulk. It is called when the
+// command is sent and returns the function called with its reply, read by
+// Receive. Replies read by `Do` for pending commands, like `EXEC` does for a
+// transaction, are not split by command, the function gets the error of `Do`.
+//
+// Example:
+// config.Hooks = []pRedis.Hook{pRedis.SlowLogHook(100 * time.Millisecond)}
+// pool, _ := pRedis.NewPool(config)
+type Hook struct {
+	Do       func(next DoFunc) DoFunc
+	Send     func(next SendFunc) SendFunc
+	Receive  func(next ReceiveFunc) ReceiveFunc
+	Pipeline func(cmd string, args []interface{}) func(reply interface{}, err error)
+}
+
+type hookConn struct {
+	redis.Conn
+	hooks []Hook
+
+	// Send and Receive may be called by two goroutines, like a publisher and
+	// a subscriber do.
+	mu      sync.Mutex
+	pending [][]func(reply interface{}, err error)
+}
+
+// WrapConn
+//
+// Wrap a connection so that commands pass through the given hooks. It is
+// called by pools created with NewPool, use it directly for connections that
+// are dialed by yourself.
+func WrapConn(conn redis.Conn, hooks ...Hook) redis.Conn {
+	if len(hooks) == 0 {
+		return conn
+	}
+	return &hookConn{Conn: conn, hooks: hooks}
+}
+
+func (c *hookConn) do(base DoFunc) DoFunc {
+	for i := len(c.hooks) - 1; i >= 0; i-- {
+		if c.hooks[i].Do != nil {
+			base = c.hooks[i].Do(base)
+		}
+	}
+	return base
+}
+
+func (c *hookConn) send(base SendFunc) SendFunc {
+	for i := len(c.hooks) - 1; i >= 0; i-- {
+		if c.hooks[i].Send != nil {
+			base = c.hooks[i].Send(base)
+		}
+	}
+	return base
+}
+
+func (c *hookConn) receive(base ReceiveFunc) ReceiveFunc {
+	for i := len(c.hooks) - 1; i >= 0; i-- {
+		if c.hooks[i].Receive != nil {
+			base = c.hooks[i].Receive(base)
+		}
+	}
+	return base
+}
+
+func (c *hookConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	// An empty command only flushes pending replies, the pool issues it when
+	// a connection is given back. It is not a command worth observing.
+	if cmd == "" {
+		reply, err := c.Conn.Do(cmd, args...)
+		c.donePending(err)
+		return reply, err
+	}
+	reply, err := c.do(c.Conn.Do)(cmd, args...)
+	c.donePending(err)
+	return reply, err
+}
+
+func (c *hookConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	base := func(cmd string, args ...interface{}) (interface{}, error) {
+		return redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+	}
+	if cmd == "" {
+		reply, err := base(cmd, args...)
+		c.donePending(err)
+		return reply, err
+	}
+	reply, err := c.do(base)(cmd, args...)
+	c.donePending(err)
+	return reply, err
+}
+
+func (c *hookConn) Send(cmd string, args ...interface{}) error {
+	var done []func(reply interface{}, err error)
+	for _, h := range c.hooks {
+		if h.Pipeline != nil {
+			if f := h.Pipeline(cmd, args); f != nil {
+				done = append(done, f)
+			}
+		}
+	}
+	if err := c.send(c.Conn.Send)(cmd, args...); err != nil {
+		for _, f := range done {
+			f(nil, err)
+		}
+		return err
+	}
+	if len(done) > 0 {
+		c.mu.Lock()
+		c.pending = append(c.pending, done)
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *hookConn) Receive() (interface{}, error) {
+	reply, err := c.receive(c.Conn.Receive)()
+	c.doneReply(reply, err)
+	return reply, err
+}
+
+func (c *hookConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	reply, err := c.receive(func() (interface{}, error) {
+		return redis.ReceiveWithTimeout(c.Conn, timeout)
+	})()
+	c.doneReply(reply, err)
+	return reply, err
+}
+
+// doneReply reports reply to the Pipeline hooks of the oldest pending
+// command. Pub/sub messages are pushed by redis, not replies to a command, so
+// they are not reported.
+func (c *hookConn) doneReply(reply interface{}, err error) {
+	if isPushMessage(reply) {
+		return
+	}
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	done := c.pending[0]
+	c.pending = c.pending[1:]
+	c.mu.Unlock()
+	for _, f := range done {
+		f(reply, err)
+	}
+}
+
+func isPushMessage(reply interface{}) bool {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) < 3 {
+		return false
+	}
+	kind, ok := values[0].([]byte)
+	return ok && (string(kind) == "message" || string(kind) == "pmessage")
+}
+
+// donePending reports err to the Pipeline hooks of every pending command,
+// their replies were read by Do.
+func (c *hookConn) donePending(err error) {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for _, done := range pending {
+		for _, f := range done {
+			f(nil, err)
+		}
+	}
+}
+
+// SlowLogHook
+//
+// Log commands that take longer than threshold with logrus. Pipelined
+// commands are timed from Send to their reply.
+func SlowLogHook(threshold time.Duration) Hook {
+	logSlow := func(cmd string, args []interface{}, start time.Time, err error) {
+		if elapsed := time.Since(start); elapsed >= threshold {
+			entry := log.WithFields(log.Fields{
+				"cmd":     cmd,
+				"key":     CommandKey(cmd, args...),
+				"elapsed": elapsed,
+			})
+			if err != nil {
+				entry = entry.WithError(err)
+			}
+			entry.Warn("redis slow command")
+		}
+	}
+	return Hook{
+		Do: func(next DoFunc) DoFunc {
+			return func(cmd string, args ...interface{}) (interface{}, error) {
+				start := time.Now()
+				reply, err := next(cmd, args...)
+				logSlow(cmd, args, start, err)
+				return reply, err
+			}
+		},
+		Pipeline: func(cmd string, args []interface{}) func(interface{}, error) {
+			start := time.Now()
+			return func(_ interface{}, err error) {
+				logSlow(cmd, args, start, err)
+			}
+		},
+	}
+}
+
+// MetricsHook
+//
+// Report name, duration and error of every command to observe, so it can be
+// fed to any metrics library. Pipelined commands are timed from Send to their
+// reply.
+func MetricsHook(observe func(cmd string, elapsed time.Duration, err error)) Hook {
+	return Hook{
+		Do: func(next DoFunc) DoFunc {
+			return func(cmd string, args ...interface{}) (interface{}, error) {
+				start := time.Now()
+				reply, err := next(cmd, args...)
+				observe(cmd, time.Since(start), err)
+				return reply, err
+			}
+		},
+		Pipeline: func(cmd string, args []interface{}) func(interface{}, error) {
+			start := time.Now()
+			return func(_ interface{}, err error) {
+				observe(cmd, time.Since(start), err)
+			}
+		},
+	}
+}
+
+// TraceHook
+//
+// Call start before every command with its name and first key, and the
+// returned function after the command is done. Pipelined commands start with
+// Send and are done with their reply. It fits tracing libraries like
+// OpenTelemetry:
+//
+//	pRedis.TraceHook(func(cmd, key string) func(error) {
+//	    _, span := tracer.Start(ctx, cmd, trace.WithAttributes(attribute.String("db.redis.key", key)))
+//	    return func(err error) {
+//	        if err != nil {
+//	            span.RecordError(err)
+//	        }
+//	        span.End()
+//	    }
+//	})
+func TraceHook(start func(cmd, key string) func(err error)) Hook {
+	return Hook{
+		Do: func(next DoFunc) DoFunc {
+			return func(cmd string, args ...interface{}) (interface{}, error) {
+				end := start(cmd, CommandKey(cmd, args...))
+				reply, err := next(cmd, args...)
+				if end != nil {
+					end(err)
+				}
+				return reply, err
+			}
+		},
+		Pipeline: func(cmd string, args []interface{}) func(interface{}, error) {
+			end := start(cmd, CommandKey(cmd, args...))
+			if end == nil {
+				return nil
+			}
+			return func(_ interface{}, err error) {
+				end(err)
+			}
+		},
+	}
+}
+
+// FaultHook
+//
+// Fail commands without sending them when inject returns an error. It is
+// meant for tests that need to simulate a broken redis.
+func FaultHook(inject func(cmd string, args []interface{}) error) Hook {
+	return Hook{
+		Do: func(next DoFunc) DoFunc {
+			return func(cmd string, args ...interface{}) (interface{}, error) {
+				if err := inject(cmd, args); err != nil {
+					return nil, err
+				}
+				return next(cmd, args...)
+			}
+		},
+		Send: func(next SendFunc) SendFunc {
+			return func(cmd string, args ...interface{}) error {
+				if err := inject(cmd, args); err != nil {
+					return err
+				}
+				return next(cmd, args...)
+			}
+		},
+	}
+}
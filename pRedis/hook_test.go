@@ -0,0 +1,118 @@
+package pRedis_test
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/zzj-custom/pkg/pRedis"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricsHook(t *testing.T) {
+	var mu sync.Mutex
+	var observed []string
+	var failed []string
+	metrics := pRedis.MetricsHook(func(cmd string, elapsed time.Duration, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cmd == "SCRIPT" || cmd == "PING" {
+			return
+		}
+		observed = append(observed, cmd)
+		if err != nil {
+			failed = append(failed, cmd)
+		}
+	})
+	pool := newTestPool(t, newTestServer(t), func(config *pRedis.DialConfig) {
+		config.Hooks = []pRedis.Hook{metrics}
+	})
+
+	conn := pool.Get()
+	if _, err := conn.Do("SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	b := pRedis.NewBatch(pool)
+	b.Queue("GET", "k")
+	b.Queue("INCR", "k")
+	if err := b.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	b.Queue("INCR", "n")
+	if err := b.ExecTx(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the queued commands of a transaction are done with EXEC
+	want := []string{"SET", "GET", "INCR", "EXEC", "MULTI", "INCR"}
+	if !reflect.DeepEqual(observed, want) {
+		t.Fatalf("observed %v, want %v", observed, want)
+	}
+	if !reflect.DeepEqual(failed, []string{"INCR"}) {
+		t.Fatalf("failed %v, want the INCR of a string", failed)
+	}
+}
+
+func TestTraceHook(t *testing.T) {
+	var mu sync.Mutex
+	var spans []string
+	trace := pRedis.TraceHook(func(cmd, key string) func(error) {
+		if cmd == "SCRIPT" {
+			return nil
+		}
+		return func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			span := cmd + " " + key
+			if err != nil {
+				span += " failed"
+			}
+			spans = append(spans, span)
+		}
+	})
+	fault := pRedis.FaultHook(func(cmd string, args []interface{}) error {
+		if cmd == "DEL" {
+			return errors.New("injected")
+		}
+		return nil
+	})
+	pool := newTestPool(t, newTestServer(t), func(config *pRedis.DialConfig) {
+		config.KeyPrefix = "app:"
+		config.Hooks = []pRedis.Hook{trace, fault}
+	})
+
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("GET", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("DEL", "a"); err == nil {
+		t.Fatal("DEL passed the fault hook")
+	}
+	if err := conn.Send("SET", "b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Send("DEL", "b"); err == nil {
+		t.Fatal("DEL passed the fault hook")
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(conn.Do("INCR", "b")); err != nil || n != 2 {
+		t.Fatalf("INCR = %d, %v, want 2", n, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"GET a", "DEL a failed", "DEL b failed", "SET b", "INCR b"}
+	if !reflect.DeepEqual(spans, want) {
+		t.Fatalf("spans %v, want %v", spans, want)
+	}
+}
@@ -0,0 +1,192 @@
+package pRedis
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHotKeyWindow  = time.Minute
+	defaultHotKeyTopN    = 10
+	defaultHotKeyMaxKeys = 100000
+)
+
+type HotKeyOptions struct {
+	// SampleRate is the fraction of commands sampled, in (0, 1]. 1 by default.
+	SampleRate float64
+	// Window is the length of a report period, 1 minute by default.
+	Window time.Duration
+	// TopN is the number of keys reported per window, 10 by default.
+	TopN int
+	// MaxKeys bounds the number of distinct keys counted per window, keys seen
+	// for the first time after it is reached are not counted. 100000 by default.
+	MaxKeys int
+	// OnReport is called with the report of every finished window, e.g. to
+	// feed metrics or logs. It is called synchronously by the command that
+	// closed the window, so it should be quick.
+	OnReport func(report HotKeyReport)
+}
+
+type HotKey struct {
+	Key string
+	// Sampled is the number of sampled accesses.
+	Sampled int64
+	// Estimated is the number of accesses extrapolated from the sample rate.
+	Estimated int64
+}
+
+type HotKeyReport struct {
+	Start time.Time
+	End   time.Time
+	// Sampled is the number of sampled key accesses during the window.
+	Sampled int64
+	// Keys are the most accessed keys, the hottest first.
+	Keys []HotKey
+}
+
+// HotKeyDetector
+//
+// Sample key accesses of the commands issued through pooled connections and
+// report the top-N hottest keys per window. Keys are counted before the key
+// prefix is applied.
+//
+// Example:
+//
+//	detector := pRedis.NewHotKeyDetector(pRedis.HotKeyOptions{
+//		SampleRate: 0.1,
+//		OnReport: func(report pRedis.HotKeyReport) {
+//			for _, k := range report.Keys {
+//				hotKeyGauge.WithLabelValues(k.Key).Set(float64(k.Estimated))
+//			}
+//		},
+//	})
+//	config.Hooks = append(config.Hooks, detector.Hook())
+type HotKeyDetector struct {
+	opts HotKeyOptions
+
+	mu      sync.Mutex
+	start   time.Time
+	sampled int64
+	counts  map[string]int64
+	last    HotKeyReport
+}
+
+func NewHotKeyDetector(opts HotKeyOptions) *HotKeyDetector {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	if opts.Window <= 0 {
+		opts.Window = defaultHotKeyWindow
+	}
+	if opts.TopN <= 0 {
+		opts.TopN = defaultHotKeyTopN
+	}
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = defaultHotKeyMaxKeys
+	}
+	return &HotKeyDetector{
+		opts:   opts,
+		start:  time.Now(),
+		counts: map[string]int64{},
+	}
+}
+
+// Hook returns the hook sampling the commands, add it to `DialConfig.Hooks`.
+func (d *HotKeyDetector) Hook() Hook {
+	return Hook{
+		Do: func(next DoFunc) DoFunc {
+			return func(cmd string, args ...interface{}) (interface{}, error) {
+				d.observe(cmd, args)
+				return next(cmd, args...)
+			}
+		},
+		Send: func(next SendFunc) SendFunc {
+			return func(cmd string, args ...interface{}) error {
+				d.observe(cmd, args)
+				return next(cmd, args...)
+			}
+		},
+	}
+}
+
+func (d *HotKeyDetector) observe(cmd string, args []interface{}) {
+	if cmd == "" {
+		return
+	}
+	if d.opts.SampleRate < 1 && rand.Float64() >= d.opts.SampleRate {
+		return
+	}
+	keys := CommandKeys(cmd, args...)
+	if len(keys) == 0 {
+		return
+	}
+	d.mu.Lock()
+	report, rotated := d.rotate(time.Now())
+	for _, key := range keys {
+		if _, ok := d.counts[key]; !ok && len(d.counts) >= d.opts.MaxKeys {
+			continue
+		}
+		d.counts[key]++
+		d.sampled++
+	}
+	d.mu.Unlock()
+	if rotated && d.opts.OnReport != nil {
+		d.opts.OnReport(report)
+	}
+}
+
+// rotate closes the current window if it is over, d.mu must be held.
+func (d *HotKeyDetector) rotate(now time.Time) (HotKeyReport, bool) {
+	end := d.start.Add(d.opts.Window)
+	if now.Before(end) {
+		return HotKeyReport{}, false
+	}
+	d.last = d.report(end)
+	// skip windows without any command
+	d.start = now.Add(-now.Sub(end) % d.opts.Window)
+	d.sampled = 0
+	d.counts = map[string]int64{}
+	return d.last, true
+}
+
+func (d *HotKeyDetector) report(end time.Time) HotKeyReport {
+	keys := make([]HotKey, 0, len(d.counts))
+	for key, n := range d.counts {
+		keys = append(keys, HotKey{
+			Key:       key,
+			Sampled:   n,
+			Estimated: int64(float64(n) / d.opts.SampleRate),
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Sampled != keys[j].Sampled {
+			return keys[i].Sampled > keys[j].Sampled
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > d.opts.TopN {
+		keys = keys[:d.opts.TopN]
+	}
+	return HotKeyReport{Start: d.start, End: end, Sampled: d.sampled, Keys: keys}
+}
+
+// Report returns the report of the last finished window.
+func (d *HotKeyDetector) Report() HotKeyReport {
+	d.mu.Lock()
+	report, rotated := d.rotate(time.Now())
+	last := d.last
+	d.mu.Unlock()
+	if rotated && d.opts.OnReport != nil {
+		d.opts.OnReport(report)
+	}
+	return last
+}
+
+// Current returns the hottest keys of the window in progress.
+func (d *HotKeyDetector) Current() HotKeyReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.report(time.Now())
+}
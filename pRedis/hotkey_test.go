@@ -0,0 +1,70 @@
+package pRedis_test
+
+import (
+	"github.com/zzj-custom/pkg/pRedis"
+	"testing"
+	"time"
+)
+
+func TestHotKeyDetector(t *testing.T) {
+	detector := pRedis.NewHotKeyDetector(pRedis.HotKeyOptions{Window: time.Hour, TopN: 2})
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, func(config *pRedis.DialConfig) {
+		config.KeyPrefix = "app:"
+		config.Hooks = []pRedis.Hook{detector.Hook()}
+	})
+
+	conn := pool.Get()
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Do("GET", "hot"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.Do("GET", "cold"); err != nil {
+		t.Fatal(err)
+	}
+	// pipelined commands are sampled too
+	b := pRedis.NewBatch(pool)
+	b.Queue("INCR", "warm")
+	b.Queue("INCR", "warm")
+	if err := b.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	report := detector.Current()
+	if report.Sampled != 6 {
+		t.Errorf("Sampled = %d, want 6", report.Sampled)
+	}
+	if len(report.Keys) != 2 || report.Keys[0].Key != "hot" || report.Keys[0].Estimated != 3 || report.Keys[1].Key != "warm" {
+		t.Fatalf("Keys = %+v, want hot then warm, unprefixed", report.Keys)
+	}
+}
+
+func TestHotKeyDetectorWindows(t *testing.T) {
+	reports := make(chan pRedis.HotKeyReport, 1)
+	detector := pRedis.NewHotKeyDetector(pRedis.HotKeyOptions{
+		Window:   20 * time.Millisecond,
+		OnReport: func(report pRedis.HotKeyReport) { reports <- report },
+	})
+	do := detector.Hook().Do(func(cmd string, args ...interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	_, _ = do("GET", "k")
+	time.Sleep(30 * time.Millisecond)
+	if report := detector.Report(); len(report.Keys) != 1 || report.Keys[0].Key != "k" {
+		t.Fatalf("Report = %+v, want the finished window", report)
+	}
+	select {
+	case report := <-reports:
+		if report.Sampled != 1 {
+			t.Fatalf("OnReport got %+v, want the finished window", report)
+		}
+	default:
+		t.Fatal("OnReport was not called")
+	}
+	if current := detector.Current(); current.Sampled != 0 {
+		t.Fatalf("Current = %+v, want an empty window", current)
+	}
+}
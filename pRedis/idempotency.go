@@ -0,0 +1,213 @@
+package pRedis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	IdempotencyProcessing = "processing"
+	IdempotencyCompleted  = "completed"
+
+	defaultIdempotencyKeyPrefix = "idempotency:"
+	defaultIdempotencyLockTTL   = time.Minute
+	defaultIdempotencyTTL       = 24 * time.Hour
+)
+
+var (
+	// ErrRequestInProgress is returned when the same request is being
+	// processed by someone else.
+	ErrRequestInProgress = errors.New("request is in progress")
+	// ErrIdempotencyClaimLost is returned by Complete and Abort when the claim
+	// of the caller expired, and the request was claimed again meanwhile.
+	ErrIdempotencyClaimLost = errors.New("idempotency claim lost")
+)
+
+var (
+	idempotencyBeginScript = RegisterScript("pRedis:idempotency:begin", 1, `
+local state = redis.call('HGET', KEYS[1], 'state')
+if not state then
+	redis.call('HMSET', KEYS[1], 'state', ARGV[1], 'token', ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return {}
+end
+return {state, redis.call('HGET', KEYS[1], 'result')}
+`)
+	// idempotencyCompleteScript and idempotencyAbortScript act only if the
+	// request is still claimed with the token of the caller.
+	idempotencyCompleteScript = RegisterScript("pRedis:idempotency:complete", 1, `
+if redis.call('HGET', KEYS[1], 'token') ~= ARGV[1] then
+	return 0
+end
+redis.call('HMSET', KEYS[1], 'state', ARGV[2], 'result', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return 1
+`)
+	idempotencyAbortScript = RegisterScript("pRedis:idempotency:abort", 1, `
+if redis.call('HGET', KEYS[1], 'token') ~= ARGV[1] or redis.call('HGET', KEYS[1], 'state') ~= ARGV[2] then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
+)
+
+type IdempotencyOptions struct {
+	Pool *redis.Pool
+	// KeyPrefix of the records, "idempotency:" by default.
+	KeyPrefix string
+	// LockTTL is how long a record stays in progress, so a request whose
+	// processor crashed can be retried after it. 1 minute by default.
+	LockTTL time.Duration
+	// TTL is how long a completed record is kept for replay. 24 hours by default.
+	TTL time.Duration
+}
+
+type IdempotencyRecord struct {
+	State  string
+	Result []byte
+	// Token identifies the claim of the caller owning the request, it must be
+	// given to Complete or Abort. It is empty for the records of others.
+	Token string
+}
+
+// Idempotency
+//
+// Process a request at most once per idempotency key. The first caller of
+// Begin owns the request, and should call Complete with the serialized result,
+// or Abort if it failed and may be retried, with the token of its claim. Later
+// callers get the stored result back, or ErrRequestInProgress while the owner
+// is still working. An owner working longer than LockTTL loses its claim,
+// Complete and Abort then return ErrIdempotencyClaimLost and leave the record
+// of the new owner alone.
+//
+// Example:
+//
+//	idem, _ := pRedis.NewIdempotency(pRedis.IdempotencyOptions{Pool: pool})
+//	result, replayed, err := idem.Do(r.Header.Get("Idempotency-Key"), func() ([]byte, error) {
+//	    return json.Marshal(createOrder(r))
+//	})
+type Idempotency struct {
+	opts IdempotencyOptions
+}
+
+func NewIdempotency(opts IdempotencyOptions) (*Idempotency, error) {
+	if opts.Pool == nil {
+		return nil, errors.Errorf("redis pool must be specified")
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = defaultIdempotencyKeyPrefix
+	}
+	if opts.LockTTL <= 0 {
+		opts.LockTTL = defaultIdempotencyLockTTL
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultIdempotencyTTL
+	}
+	return &Idempotency{opts: opts}, nil
+}
+
+// Begin claims key. It returns a record in progress with the token of the
+// claim if the caller owns the request now, the completed record if the
+// request was already processed, and ErrRequestInProgress if it is being
+// processed.
+func (i *Idempotency) Begin(key string) (*IdempotencyRecord, error) {
+	token, err := newIdempotencyToken()
+	if err != nil {
+		return nil, err
+	}
+	values, err := redis.Values(idempotencyBeginScript.Exec(
+		i.opts.Pool,
+		i.opts.KeyPrefix+key,
+		IdempotencyProcessing,
+		ttlMillis(i.opts.LockTTL),
+		token,
+	))
+	if err != nil {
+		return nil, errors.Wrapf(err, "begin idempotent request failed, key=%s", key)
+	}
+	if len(values) == 0 {
+		return &IdempotencyRecord{State: IdempotencyProcessing, Token: token}, nil
+	}
+	record := new(IdempotencyRecord)
+	if record.State, err = redis.String(values[0], nil); err != nil {
+		return nil, errors.Wrapf(err, "begin idempotent request failed, key=%s", key)
+	}
+	if record.State != IdempotencyCompleted {
+		return record, ErrRequestInProgress
+	}
+	if len(values) > 1 && values[1] != nil {
+		record.Result, _ = redis.Bytes(values[1], nil)
+	}
+	return record, nil
+}
+
+func newIdempotencyToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate idempotency token failed")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Complete stores the result of the request claimed with token, it is kept
+// for TTL.
+func (i *Idempotency) Complete(key, token string, result []byte) error {
+	ok, err := redis.Bool(idempotencyCompleteScript.Exec(
+		i.opts.Pool,
+		i.opts.KeyPrefix+key,
+		token,
+		IdempotencyCompleted,
+		result,
+		ttlMillis(i.opts.TTL),
+	))
+	if err != nil {
+		return errors.Wrapf(err, "complete idempotent request failed, key=%s", key)
+	}
+	if !ok {
+		return errors.Wrapf(ErrIdempotencyClaimLost, "complete idempotent request failed, key=%s", key)
+	}
+	return nil
+}
+
+// Abort drops the record of the request claimed with token, so that it can be
+// retried at once.
+func (i *Idempotency) Abort(key, token string) error {
+	ok, err := redis.Bool(idempotencyAbortScript.Exec(
+		i.opts.Pool,
+		i.opts.KeyPrefix+key,
+		token,
+		IdempotencyProcessing,
+	))
+	if err != nil {
+		return errors.Wrapf(err, "abort idempotent request failed, key=%s", key)
+	}
+	if !ok {
+		return errors.Wrapf(ErrIdempotencyClaimLost, "abort idempotent request failed, key=%s", key)
+	}
+	return nil
+}
+
+// Do runs fn once per key. If the request was already processed, the stored
+// result is returned with replayed set to true and fn is not called. If fn
+// fails, the record is dropped so the request can be retried.
+func (i *Idempotency) Do(key string, fn func() ([]byte, error)) (result []byte, replayed bool, err error) {
+	record, err := i.Begin(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if record.Token == "" {
+		return record.Result, true, nil
+	}
+	result, err = fn()
+	if err != nil {
+		_ = i.Abort(key, record.Token)
+		return nil, false, err
+	}
+	if err = i.Complete(key, record.Token, result); err != nil {
+		return result, false, err
+	}
+	return result, false, nil
+}
@@ -0,0 +1,94 @@
+package pRedis_test
+
+import (
+	"github.com/pkg/errors"
+	"github.com/zzj-custom/pkg/pRedis"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyDo(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	idem, err := pRedis.NewIdempotency(pRedis.IdempotencyOptions{Pool: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	fn := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte("created"), nil
+	}
+	result, replayed, err := idem.Do("order", fn)
+	if err != nil || replayed || string(result) != "created" {
+		t.Fatalf("Do = %q, %v, %v", result, replayed, err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, replayed, err := idem.Do("order", fn)
+			if err != nil || !replayed || string(result) != "created" {
+				t.Errorf("replayed Do = %q, %v, %v", result, replayed, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("fn called %d times", calls)
+	}
+
+	failure := errors.New("failed")
+	if _, _, err = idem.Do("retry", func() ([]byte, error) { return nil, failure }); err != failure {
+		t.Fatalf("Do = %v, want the error of fn", err)
+	}
+	if _, replayed, err = idem.Do("retry", fn); err != nil || replayed {
+		t.Fatalf("Do after a failure = %v, %v, want a new run", replayed, err)
+	}
+}
+
+func TestIdempotencyClaim(t *testing.T) {
+	srv := newTestServer(t)
+	idem, err := pRedis.NewIdempotency(pRedis.IdempotencyOptions{
+		Pool:    newTestPool(t, srv, nil),
+		LockTTL: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := idem.Begin("order")
+	if err != nil || first.Token == "" {
+		t.Fatalf("Begin = %+v, %v, want a claim", first, err)
+	}
+	if _, err = idem.Begin("order"); err != pRedis.ErrRequestInProgress {
+		t.Fatalf("Begin of a claimed key = %v, want ErrRequestInProgress", err)
+	}
+
+	// the first owner is too slow, a retry claims the request again
+	srv.Advance(2 * time.Second)
+	second, err := idem.Begin("order")
+	if err != nil || second.Token == "" || second.Token == first.Token {
+		t.Fatalf("Begin after LockTTL = %+v, %v, want a new claim", second, err)
+	}
+	if err = idem.Complete("order", first.Token, []byte("first")); errors.Cause(err) != pRedis.ErrIdempotencyClaimLost {
+		t.Fatalf("Complete with the lost claim = %v, want ErrIdempotencyClaimLost", err)
+	}
+	if err = idem.Abort("order", first.Token); errors.Cause(err) != pRedis.ErrIdempotencyClaimLost {
+		t.Fatalf("Abort with the lost claim = %v, want ErrIdempotencyClaimLost", err)
+	}
+	if err = idem.Complete("order", second.Token, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := idem.Begin("order")
+	if err != nil || record.Token != "" || record.State != pRedis.IdempotencyCompleted || string(record.Result) != "second" {
+		t.Fatalf("Begin of a completed key = %+v, %v", record, err)
+	}
+	if err = idem.Abort("order", second.Token); errors.Cause(err) != pRedis.ErrIdempotencyClaimLost {
+		t.Fatalf("Abort of a completed request = %v, want ErrIdempotencyClaimLost", err)
+	}
+}
@@ -0,0 +1,291 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeWorkerBits   = 10
+	snowflakeSequenceBits = 12
+	snowflakeMaxWorkerID  = 1<<snowflakeWorkerBits - 1
+	snowflakeMaxSequence  = 1<<snowflakeSequenceBits - 1
+
+	defaultSnowflakeKeyPrefix    = "snowflake:worker:"
+	defaultSnowflakeLeaseSeconds = 30
+	defaultSnowflakeMaxBackwards = 5 * time.Millisecond
+)
+
+var (
+	// ErrClockBackwards is returned when the clock moved backwards further
+	// than `SnowflakeOptions.MaxBackwards`.
+	ErrClockBackwards = errors.New("clock moved backwards")
+	// ErrWorkerLeaseLost is returned when the worker id lease was not renewed
+	// for LeaseSeconds, or was taken by another generator, so that ids may not
+	// be unique any more.
+	ErrWorkerLeaseLost = errors.New("worker id lease lost")
+	// ErrNoWorkerID is returned when every worker id is leased.
+	ErrNoWorkerID = errors.New("no worker id available")
+
+	defaultSnowflakeEpoch = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type SnowflakeOptions struct {
+	Pool *redis.Pool
+	// KeyPrefix of the keys leasing worker ids, "snowflake:worker:" by default.
+	// The fencing counter of a worker id, see FenceKey, is created the first
+	// time it is leased and never expires nor is deleted: its tokens must keep
+	// growing across leases. There are at most 1024 of them per prefix.
+	KeyPrefix string
+	// LeaseSeconds is the ttl of the worker id lease, which is renewed every
+	// third of it. 30 by default.
+	LeaseSeconds int
+	// Epoch is the start time of the timestamp part, 2023-01-01 UTC by default.
+	// It must never change once ids are issued.
+	Epoch time.Time
+	// MaxBackwards is how far the clock may move backwards before NextID fails,
+	// smaller rollbacks are waited out. 5ms by default.
+	MaxBackwards time.Duration
+}
+
+// Snowflake
+//
+// Generate 64-bit ids made of a 41-bit millisecond timestamp, a 10-bit worker
+// id and a 12-bit sequence. The worker id is leased from redis by a fenced Lock
+// and kept as long as the generator is not closed, so replicas need no
+// configuration. NextID fails with ErrWorkerLeaseLost while the lease may have
+// expired, i.e. when it was not renewed for LeaseSeconds.
+//
+// Example:
+//
+//	sf, err := pRedis.NewSnowflake(pRedis.SnowflakeOptions{Pool: pool})
+//	if err != nil {
+//	    return err
+//	}
+//	defer sf.Close()
+//	id, err := sf.NextID()
+type Snowflake struct {
+	opts     SnowflakeOptions
+	lock     *Lock
+	lockKey  string
+	workerID int64
+	epochMs  int64
+
+	mu    sync.Mutex
+	token int64
+	// renewed is when the last successful renewal of the lease was sent.
+	renewed time.Time
+	lastMs  int64
+	seq     int64
+	lost    bool
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewSnowflake(opts SnowflakeOptions) (*Snowflake, error) {
+	if opts.Pool == nil {
+		return nil, errors.Errorf("redis pool must be specified")
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = defaultSnowflakeKeyPrefix
+	}
+	if opts.LeaseSeconds <= 0 {
+		opts.LeaseSeconds = defaultSnowflakeLeaseSeconds
+	}
+	if opts.Epoch.IsZero() {
+		opts.Epoch = defaultSnowflakeEpoch
+	}
+	if opts.MaxBackwards <= 0 {
+		opts.MaxBackwards = defaultSnowflakeMaxBackwards
+	}
+	s := &Snowflake{
+		opts:    opts,
+		lock:    NewLock(opts.Pool),
+		epochMs: opts.Epoch.UnixMilli(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := s.lease(); err != nil {
+		return nil, err
+	}
+	go s.renew()
+	return s, nil
+}
+
+// lease takes the first free worker id, starting from a random one so that
+// replicas starting together do not race for the same ids.
+func (s *Snowflake) lease() error {
+	start := rand.Intn(snowflakeMaxWorkerID + 1)
+	for i := 0; i <= snowflakeMaxWorkerID; i++ {
+		id := (start + i) % (snowflakeMaxWorkerID + 1)
+		key := s.opts.KeyPrefix + strconv.Itoa(id)
+		start := time.Now()
+		token, err := s.lock.AcquireWithFence(key, s.opts.LeaseSeconds)
+		if err == nil {
+			s.workerID = int64(id)
+			s.lockKey = key
+			s.token = token
+			s.renewed = start
+			return nil
+		}
+		if errors.Cause(err) != redis.ErrNil {
+			return err
+		}
+	}
+	return ErrNoWorkerID
+}
+
+func (s *Snowflake) renew() {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Duration(s.opts.LeaseSeconds) * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			token := s.token
+			s.mu.Unlock()
+			start := time.Now()
+			err := s.lock.RefreshWithFence(s.lockKey, token, s.opts.LeaseSeconds)
+			if err == nil {
+				s.mu.Lock()
+				s.renewed = start
+				s.mu.Unlock()
+				continue
+			}
+			log.WithError(err).WithField("worker", s.workerID).Error("failed to renew snowflake worker id")
+			if errors.Cause(err) != ErrStaleFencingToken {
+				// NextID fails once the lease may have expired
+				continue
+			}
+			// The lease expired, take it again if nobody else did.
+			token, err = s.lock.AcquireWithFence(s.lockKey, s.opts.LeaseSeconds)
+			lost := errors.Cause(err) == redis.ErrNil
+			s.mu.Lock()
+			if err == nil {
+				s.token, s.renewed = token, start
+			}
+			s.lost = lost
+			s.mu.Unlock()
+			if lost {
+				return
+			}
+		}
+	}
+}
+
+// WorkerID returns the leased worker id.
+func (s *Snowflake) WorkerID() int64 {
+	return s.workerID
+}
+
+// NextID returns a new id.
+func (s *Snowflake) NextID() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lost {
+		return 0, ErrWorkerLeaseLost
+	}
+	if time.Since(s.renewed) >= time.Duration(s.opts.LeaseSeconds)*time.Second {
+		return 0, errors.Wrapf(ErrWorkerLeaseLost, "not renewed since %s", s.renewed.Format(time.RFC3339))
+	}
+
+	now := time.Now().UnixMilli()
+	if now < s.lastMs {
+		backwards := time.Duration(s.lastMs-now) * time.Millisecond
+		if backwards > s.opts.MaxBackwards {
+			return 0, errors.Wrapf(ErrClockBackwards, "moved back %s", backwards)
+		}
+		time.Sleep(backwards)
+		now = time.Now().UnixMilli()
+	}
+	if now == s.lastMs {
+		s.seq = (s.seq + 1) & snowflakeMaxSequence
+		if s.seq == 0 {
+			for now <= s.lastMs {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Now().UnixMilli()
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	if now < s.lastMs {
+		return 0, ErrClockBackwards
+	}
+	s.lastMs = now
+	return (now-s.epochMs)<<(snowflakeWorkerBits+snowflakeSequenceBits) |
+		s.workerID<<snowflakeSequenceBits |
+		s.seq, nil
+}
+
+// Close stops renewing the lease and releases the worker id, its fencing
+// counter is kept, see `SnowflakeOptions.KeyPrefix`. Closing again does
+// nothing.
+func (s *Snowflake) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+	s.mu.Lock()
+	lost, token := s.lost, s.token
+	s.lost = true
+	s.mu.Unlock()
+	if lost {
+		return nil
+	}
+	err := s.lock.ReleaseWithFence(s.lockKey, token)
+	if errors.Cause(err) == ErrStaleFencingToken {
+		// the lease expired already
+		return nil
+	}
+	return err
+}
+
+// Segment
+//
+// Allocate ids by segments of Step reserved with `INCRBY` on Key, so most ids
+// cost no round trip. Ids are unique across processes and increasing within a
+// process, with a Step of 1 they are strictly increasing across processes too.
+type Segment struct {
+	Pool *redis.Pool
+	Key  string
+	Step int64
+
+	mu  sync.Mutex
+	cur int64
+	max int64
+}
+
+func NewSegment(pool *redis.Pool, key string, step int64) *Segment {
+	if step <= 0 {
+		step = 1
+	}
+	return &Segment{Pool: pool, Key: key, Step: step}
+}
+
+// Next returns a new id.
+func (s *Segment) Next() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur >= s.max {
+		conn := s.Pool.Get()
+		max, err := redis.Int64(conn.Do("INCRBY", s.Key, s.Step))
+		_ = conn.Close()
+		if err != nil {
+			return 0, errors.Wrapf(err, "allocate segment failed, key=%s", s.Key)
+		}
+		s.max = max
+		s.cur = max - s.Step
+	}
+	s.cur++
+	return s.cur, nil
+}
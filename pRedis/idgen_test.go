@@ -0,0 +1,168 @@
+package pRedis_test
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/zzj-custom/pkg/pRedis"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnowflake(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	a, err := pRedis.NewSnowflake(pRedis.SnowflakeOptions{Pool: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := pRedis.NewSnowflake(pRedis.SnowflakeOptions{Pool: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if a.WorkerID() == b.WorkerID() {
+		t.Fatalf("both generators leased worker id %d", a.WorkerID())
+	}
+
+	const workers, perWorker = 8, 500
+	ids := make(chan int64, workers*perWorker)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		sf := a
+		if i%2 == 1 {
+			sf = b
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last int64
+			for j := 0; j < perWorker; j++ {
+				id, err := sf.NextID()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if id <= last {
+					errs <- errors.Errorf("id %d after %d", id, last)
+					return
+				}
+				last = id
+				ids <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	seen := map[int64]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate id %d", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestSnowflakeLeaseLost(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, nil)
+	sf, err := pRedis.NewSnowflake(pRedis.SnowflakeOptions{Pool: pool, LeaseSeconds: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sf.NextID(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lease expires and another process takes the worker id before the
+	// next renewal
+	srv.Advance(4 * time.Second)
+	key := "snowflake:worker:" + strconv.FormatInt(sf.WorkerID(), 10)
+	if _, err = pRedis.NewLock(pool).AcquireWithFence(key, 60); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_, err = sf.NextID()
+		if errors.Cause(err) == pRedis.ErrWorkerLeaseLost {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("NextID still succeeds after the lease was taken over")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err = sf.Close(); err != nil {
+		t.Fatalf("Close after the lease was lost: %v", err)
+	}
+}
+
+func TestSnowflakeCloseTwice(t *testing.T) {
+	sf, err := pRedis.NewSnowflake(pRedis.SnowflakeOptions{Pool: newTestPool(t, newTestServer(t), nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = sf.Close(); err != nil {
+			t.Fatalf("Close #%d: %v", i+1, err)
+		}
+	}
+}
+
+func TestSnowflakeFenceKeys(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	conn := pool.Get()
+	defer conn.Close()
+
+	// only leased worker ids get a fencing counter, which outlives the lease
+	workers := map[int64]bool{}
+	for i := 0; i < 5; i++ {
+		sf, err := pRedis.NewSnowflake(pRedis.SnowflakeOptions{Pool: pool})
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers[sf.WorkerID()] = true
+		if err = sf.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := redis.Strings(conn.Do("KEYS", "snowflake:worker:*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(workers) {
+		t.Fatalf("keys %v, want a fencing counter per leased worker id %v", keys, workers)
+	}
+	for _, key := range keys {
+		if ttl, _ := redis.Int(conn.Do("TTL", key)); ttl != -1 {
+			t.Fatalf("TTL of %s = %d, want no expiry", key, ttl)
+		}
+	}
+}
+
+func TestSegment(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	segments := []*pRedis.Segment{pRedis.NewSegment(pool, "ids", 10), pRedis.NewSegment(pool, "ids", 10)}
+	seen := map[int64]bool{}
+	for i := 0; i < 50; i++ {
+		for _, s := range segments {
+			id, err := s.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seen[id] {
+				t.Fatalf("duplicate id %d", id)
+			}
+			seen[id] = true
+		}
+	}
+}
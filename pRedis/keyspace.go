@@ -0,0 +1,326 @@
+package pRedis
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Usage:
+// Create a listener by NewKeyspaceListener, register handlers by On, then call
+// Start, which blocks like Subscription.Start until the context is done.
+//
+// Example:
+// l, _ := pRedis.NewKeyspaceListener(pRedis.KeyspaceOptions{Ctx: ctx, Pool: pool, NotifyEvents: "Egx"})
+// l.On(pRedis.KeyEventExpired, "session:*", func(ctx context.Context, evt pRedis.KeyEvent) error {
+//     log.Info("session timeout: ", evt.Key)
+//     return nil
+// })
+// go l.Start()
+
+const (
+	KeyEventExpired = "expired"
+	KeyEventEvicted = "evicted"
+	KeyEventDel     = "del"
+	KeyEventSet     = "set"
+	KeyEventExpire  = "expire"
+	KeyEventRename  = "rename_to"
+)
+
+const (
+	keyspaceChannelPrefix = "__keyspace@"
+	keyeventChannelPrefix = "__keyevent@"
+)
+
+const (
+	// notifyEventsAll are the classes of events the flag A stands for in
+	// `notify-keyspace-events`.
+	notifyEventsAll   = "g$lshzxetd"
+	notifyEventsOrder = "KEg$lshzxetdmn"
+)
+
+type KeyEvent struct {
+	DB    int
+	Event string
+	Key   string
+}
+
+type KeyEventHandler func(ctx context.Context, evt KeyEvent) error
+
+// ParseKeyEvent parses a message of a `__keyevent@<db>__:<event>` or
+// `__keyspace@<db>__:<key>` channel.
+func ParseKeyEvent(msg redis.Message) (KeyEvent, bool) {
+	var evt KeyEvent
+	var rest string
+	var keyspace bool
+	switch {
+	case strings.HasPrefix(msg.Channel, keyeventChannelPrefix):
+		rest = msg.Channel[len(keyeventChannelPrefix):]
+	case strings.HasPrefix(msg.Channel, keyspaceChannelPrefix):
+		rest = msg.Channel[len(keyspaceChannelPrefix):]
+		keyspace = true
+	default:
+		return evt, false
+	}
+	i := strings.Index(rest, "__:")
+	if i < 0 {
+		return evt, false
+	}
+	db, err := strconv.Atoi(rest[:i])
+	if err != nil {
+		return evt, false
+	}
+	evt.DB = db
+	if keyspace {
+		evt.Key = rest[i+3:]
+		evt.Event = string(msg.Data)
+	} else {
+		evt.Event = rest[i+3:]
+		evt.Key = string(msg.Data)
+	}
+	return evt, true
+}
+
+type KeyspaceOptions struct {
+	Ctx  context.Context
+	Pool *redis.Pool
+	// DB is the database whose events are listened to.
+	DB int
+	// NotifyEvents are the flags of `notify-keyspace-events` enabled before
+	// listening when not empty, e.g. "Egx" for generic and expired events.
+	// They are merged with the flags already enabled on the server, which are
+	// never disabled. Leave it empty if CONFIG is disabled on the server.
+	NotifyEvents    string
+	RestartDuration time.Duration
+	PingDuration    time.Duration
+}
+
+type keyEventRoute struct {
+	event   string
+	pattern string
+	handler KeyEventHandler
+}
+
+type KeyspaceListener struct {
+	opts KeyspaceOptions
+	sub  *Subscription
+
+	mu     sync.RWMutex
+	routes []keyEventRoute
+}
+
+func NewKeyspaceListener(opts KeyspaceOptions) (*KeyspaceListener, error) {
+	sub, err := NewSubscription(Options{
+		Ctx:             opts.Ctx,
+		Pool:            opts.Pool,
+		RestartDuration: opts.RestartDuration,
+		PingDuration:    opts.PingDuration,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &KeyspaceListener{opts: opts, sub: sub}, nil
+}
+
+// On registers handler for event on keys matching pattern. An empty event or
+// "*" matches every event, an empty pattern matches every key. Patterns use
+// the glob-style syntax of redis, see MatchPattern.
+func (l *KeyspaceListener) On(event, pattern string, handler KeyEventHandler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.routes = append(l.routes, keyEventRoute{event: event, pattern: pattern, handler: handler})
+}
+
+// Start enables notifications if asked and listens to key events until the
+// context is done.
+func (l *KeyspaceListener) Start() error {
+	if l.opts.NotifyEvents != "" {
+		if err := l.enableNotifications(); err != nil {
+			return err
+		}
+	}
+	pattern := fmt.Sprintf("%s%d__:*", keyeventChannelPrefix, l.opts.DB)
+	if err := l.sub.PSubscribe(pattern, l.dispatch); err != nil {
+		return err
+	}
+	l.sub.Start()
+	return nil
+}
+
+// enableNotifications adds NotifyEvents to the flags enabled on the server,
+// so that the events other clients rely on are kept.
+func (l *KeyspaceListener) enableNotifications() error {
+	conn := l.opts.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	config, err := redis.StringMap(conn.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil {
+		return errors.Wrap(err, "read keyspace notifications config failed")
+	}
+	current := mergeNotifyEvents(config["notify-keyspace-events"], "")
+	merged := mergeNotifyEvents(current, l.opts.NotifyEvents)
+	if merged == current {
+		return nil
+	}
+	if _, err = conn.Do("CONFIG", "SET", "notify-keyspace-events", merged); err != nil {
+		return errors.Wrap(err, "enable keyspace notifications failed")
+	}
+	return nil
+}
+
+// mergeNotifyEvents returns the union of two sets of flags of
+// `notify-keyspace-events`, with A expanded. Unknown flags are kept for redis
+// to reject them.
+func mergeNotifyEvents(a, b string) string {
+	flags := map[rune]bool{}
+	for _, c := range a + b {
+		if c == 'A' {
+			for _, e := range notifyEventsAll {
+				flags[e] = true
+			}
+			continue
+		}
+		flags[c] = true
+	}
+	var merged strings.Builder
+	for _, c := range notifyEventsOrder {
+		if flags[c] {
+			merged.WriteRune(c)
+			delete(flags, c)
+		}
+	}
+	unknown := make([]string, 0, len(flags))
+	for c := range flags {
+		unknown = append(unknown, string(c))
+	}
+	sort.Strings(unknown)
+	return merged.String() + strings.Join(unknown, "")
+}
+
+func (l *KeyspaceListener) dispatch(ctx context.Context, msg redis.Message) error {
+	evt, ok := ParseKeyEvent(msg)
+	if !ok {
+		return nil
+	}
+	l.mu.RLock()
+	routes := l.routes
+	l.mu.RUnlock()
+	for _, route := range routes {
+		if !route.match(evt) {
+			continue
+		}
+		if err := route.handler(ctx, evt); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"event": evt.Event,
+				"key":   evt.Key,
+			}).Error("failed to handle key event")
+		}
+	}
+	return nil
+}
+
+func (r keyEventRoute) match(evt KeyEvent) bool {
+	if r.event != "" && r.event != "*" && r.event != evt.Event {
+		return false
+	}
+	if r.pattern == "" {
+		return true
+	}
+	return MatchPattern(r.pattern, evt.Key)
+}
+
+// MatchPattern
+//
+// Match s against a glob-style pattern as redis does for `KEYS`, `SCAN` and
+// `PSUBSCRIBE`: `*` and `?` match any bytes including `/`, `[a-z]` and
+// `[^abc]` match a class, and `\` escapes the next byte.
+func MatchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if MatchPattern(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(pattern) {
+				return false
+			}
+			if !classMatch(pattern[1:end], s[0]) {
+				return false
+			}
+			pattern, s = pattern[end+1:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+func classMatch(class string, c byte) bool {
+	negate := false
+	if len(class) > 0 && class[0] == '^' {
+		negate = true
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if class[i] == '\\' && i+1 < len(class) {
+			i++
+			if class[i] == c {
+				matched = true
+			}
+			continue
+		}
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				matched = true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			matched = true
+		}
+	}
+	return matched != negate
+}
@@ -0,0 +1,120 @@
+package pRedis_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/zzj-custom/pkg/pRedis"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyspaceListener(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, func(config *pRedis.DialConfig) { config.KeyPrefix = "a:" })
+	other := newTestPool(t, srv, func(config *pRedis.DialConfig) { config.KeyPrefix = "b:" })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := pRedis.NewKeyspaceListener(pRedis.KeyspaceOptions{Ctx: ctx, Pool: pool, NotifyEvents: "E$g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var keys, deleted []string
+	l.On(pRedis.KeyEventSet, "user:*", func(ctx context.Context, evt pRedis.KeyEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, evt.Key)
+		return nil
+	})
+	l.On(pRedis.KeyEventDel, "", func(ctx context.Context, evt pRedis.KeyEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		deleted = append(deleted, evt.Key)
+		return nil
+	})
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- l.Start()
+	}()
+
+	conn, otherConn := pool.Get(), other.Get()
+	defer conn.Close()
+	defer otherConn.Close()
+	// write until the listener is subscribed, the key of the other tenant is
+	// written first, so its event would come first
+	for i := 0; ; i++ {
+		if _, err = otherConn.Do("SET", "user:1", i); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Do("SET", "user:1", i); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		n := len(keys)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("no key event received")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err = otherConn.Do("DEL", "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Do("SET", "order:1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Do("DEL", "order:1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the del event", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(deleted) > 0
+	})
+	cancel()
+	select {
+	case err = <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after the context was done")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, key := range keys {
+		if key != "user:1" {
+			t.Fatalf("set events %v, want user:1 only, unprefixed", keys)
+		}
+	}
+	if fmt.Sprint(deleted) != "[order:1]" {
+		t.Fatalf("del events %v, want order:1 only, the del of the other tenant dropped", deleted)
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	for _, c := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"user:*", "user:1", true},
+		{"user:*", "users:1", false},
+		{"*", "a/b", true},
+		{"h?llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+	} {
+		if got := pRedis.MatchPattern(c.pattern, c.s); got != c.want {
+			t.Errorf("MatchPattern(%q, %q) = %v, want %v", c.pattern, c.s, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var leaderboardIncrScript = RegisterScript("pRedis:leaderboard:incr", 1, `
+local old = redis.call('ZSCORE', KEYS[1], ARGV[1])
+local score = 0
+if old then
+	score = math.floor(tonumber(old))
+end
+score = score + tonumber(ARGV[2])
+redis.call('ZADD', KEYS[1], score + tonumber(ARGV[3]), ARGV[1])
+return tostring(score)
+`)
+
+type LeaderboardEntry struct {
+	Member string
+	Score  float64
+	// Rank starts from 1.
+	Rank int64
+}
+
+// Leaderboard
+//
+// A ranking over a sorted set, higher scores rank first. With TieBreak, equal
+// scores are ranked by the time they were reached, the earlier the better.
+// It is done by adding a fraction derived from the timestamp to the stored
+// score, so scores must be integers whose absolute value is below 2^20.
+//
+// Example:
+//
+//	lb := pRedis.NewLeaderboard(pool, "rank:weekly", true)
+//	_, _ = lb.IncrScore("user:1", 10)
+//	top, _ := lb.Top(10)
+//	around, _ := lb.Around("user:1", 5)
+type Leaderboard struct {
+	Pool     *redis.Pool
+	Key      string
+	TieBreak bool
+}
+
+func NewLeaderboard(pool *redis.Pool, key string, tieBreak bool) *Leaderboard {
+	return &Leaderboard{Pool: pool, Key: key, TieBreak: tieBreak}
+}
+
+// tieFraction decreases as time goes, it stays in (0, 1) until 2106.
+func tieFraction(t time.Time) float64 {
+	return float64(uint64(1)<<32-uint64(t.Unix())) / float64(uint64(1)<<32)
+}
+
+func (l *Leaderboard) encode(score float64) float64 {
+	if !l.TieBreak {
+		return score
+	}
+	return math.Floor(score) + tieFraction(time.Now())
+}
+
+func (l *Leaderboard) decode(score float64) float64 {
+	if !l.TieBreak {
+		return score
+	}
+	return math.Floor(score)
+}
+
+// SetScore sets the score of member.
+func (l *Leaderboard) SetScore(member string, score float64) error {
+	conn := l.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err := conn.Do("ZADD", l.Key, l.encode(score), member)
+	return err
+}
+
+// IncrScore adds delta to the score of member and returns the new score.
+func (l *Leaderboard) IncrScore(member string, delta float64) (float64, error) {
+	if !l.TieBreak {
+		conn := l.Pool.Get()
+		defer func() {
+			_ = conn.Close()
+		}()
+		return redis.Float64(conn.Do("ZINCRBY", l.Key, delta, member))
+	}
+	return redis.Float64(leaderboardIncrScript.Exec(
+		l.Pool, l.Key, member, math.Floor(delta), tieFraction(time.Now()),
+	))
+}
+
+// Score returns the score of member, redis.ErrNil if member is not ranked.
+func (l *Leaderboard) Score(member string) (float64, error) {
+	conn := l.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	score, err := redis.Float64(conn.Do("ZSCORE", l.Key, member))
+	if err != nil {
+		return 0, err
+	}
+	return l.decode(score), nil
+}
+
+// Rank returns the rank of member starting from 1, redis.ErrNil if member is
+// not ranked.
+func (l *Leaderboard) Rank(member string) (int64, error) {
+	conn := l.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	rank, err := redis.Int64(conn.Do("ZREVRANK", l.Key, member))
+	if err != nil {
+		return 0, err
+	}
+	return rank + 1, nil
+}
+
+// Top returns the first n entries.
+func (l *Leaderboard) Top(n int64) ([]LeaderboardEntry, error) {
+	return l.Page(0, n)
+}
+
+// Page returns limit entries starting from offset, which starts from 0.
+func (l *Leaderboard) Page(offset, limit int64) ([]LeaderboardEntry, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return l.entries(offset, offset+limit-1)
+}
+
+// Around returns the entries ranked within radius around member, member
+// included. redis.ErrNil is returned if member is not ranked.
+func (l *Leaderboard) Around(member string, radius int64) ([]LeaderboardEntry, error) {
+	rank, err := l.Rank(member)
+	if err != nil {
+		return nil, err
+	}
+	start := rank - 1 - radius
+	if start < 0 {
+		start = 0
+	}
+	return l.entries(start, rank-1+radius)
+}
+
+func (l *Leaderboard) entries(start, stop int64) ([]LeaderboardEntry, error) {
+	conn := l.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	values, err := redis.Strings(conn.Do("ZREVRANGE", l.Key, start, stop, "WITHSCORES"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch leaderboard failed, key=%s", l.Key)
+	}
+	entries := make([]LeaderboardEntry, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetch leaderboard failed, key=%s", l.Key)
+		}
+		entries = append(entries, LeaderboardEntry{
+			Member: values[i],
+			Score:  l.decode(score),
+			Rank:   start + int64(i/2) + 1,
+		})
+	}
+	return entries, nil
+}
+
+// Remove removes members from the leaderboard.
+func (l *Leaderboard) Remove(members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	conn := l.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err := conn.Do("ZREM", redis.Args{}.Add(l.Key).AddFlat(members)...)
+	return err
+}
+
+// Len returns the number of ranked members.
+func (l *Leaderboard) Len() (int64, error) {
+	conn := l.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	return redis.Int64(conn.Do("ZCARD", l.Key))
+}
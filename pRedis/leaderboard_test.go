@@ -0,0 +1,65 @@
+package pRedis_test
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"reflect"
+	"testing"
+)
+
+func TestLeaderboard(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	for _, tieBreak := range []bool{false, true} {
+		lb := pRedis.NewLeaderboard(pool, "rank", tieBreak)
+		for member, score := range map[string]float64{"a": 10, "b": 30, "c": 20, "d": 5} {
+			if err := lb.SetScore(member, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if score, err := lb.IncrScore("d", 30); err != nil || score != 35 {
+			t.Fatalf("IncrScore = %v, %v, want 35", score, err)
+		}
+		if score, err := lb.IncrScore("e", 1); err != nil || score != 1 {
+			t.Fatalf("IncrScore of a new member = %v, %v, want 1", score, err)
+		}
+		if score, err := lb.Score("c"); err != nil || score != 20 {
+			t.Fatalf("Score = %v, %v, want 20", score, err)
+		}
+		if _, err := lb.Score("missing"); err != redis.ErrNil {
+			t.Fatalf("Score of a missing member = %v, want ErrNil", err)
+		}
+		if rank, err := lb.Rank("b"); err != nil || rank != 2 {
+			t.Fatalf("Rank = %d, %v, want 2", rank, err)
+		}
+
+		top, err := lb.Top(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []pRedis.LeaderboardEntry{{Member: "d", Score: 35, Rank: 1}, {Member: "b", Score: 30, Rank: 2}}
+		if !reflect.DeepEqual(top, want) {
+			t.Fatalf("Top = %v, want %v", top, want)
+		}
+		around, err := lb.Around("a", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = []pRedis.LeaderboardEntry{{Member: "c", Score: 20, Rank: 3}, {Member: "a", Score: 10, Rank: 4}, {Member: "e", Score: 1, Rank: 5}}
+		if !reflect.DeepEqual(around, want) {
+			t.Fatalf("Around = %v, want %v", around, want)
+		}
+		if page, err := lb.Page(4, 10); err != nil || len(page) != 1 || page[0].Member != "e" {
+			t.Fatalf("Page = %v, %v, want the last entry", page, err)
+		}
+
+		if err = lb.Remove("a", "e"); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := lb.Len(); err != nil || n != 3 {
+			t.Fatalf("Len = %d, %v, want 3", n, err)
+		}
+		if err = lb.Remove("b", "c", "d"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -5,6 +5,35 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrStaleFencingToken is returned when a fencing token is older than the
+// latest one seen, i.e. its holder lost the lock.
+var ErrStaleFencingToken = errors.New("stale fencing token")
+
+var (
+	// lockAcquireFencedScript sets the lock to a new token of the companion
+	// key, if the lock is free.
+	lockAcquireFencedScript = RegisterScript("pRedis:lock:acquire", 2, `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return false
+end
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], token, 'EX', ARGV[1])
+return token
+`)
+	lockReleaseFencedScript = RegisterScript("pRedis:lock:release", 1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+	lockRefreshFencedScript = RegisterScript("pRedis:lock:refresh", 1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
 type Lock struct {
 	Pool        *redis.Pool
 	LockSeconds int
@@ -39,9 +68,129 @@ func (r *Lock) Release(lock string) error {
 	conn := r.Pool.Get()
 	defer func() { _ = conn.Close() }()
 
-	_, err := conn.Do("DEL", conn)
+	_, err := conn.Do("DEL", lock)
 	if err != nil {
 		return errors.Wrapf(err, "删除锁失败,key=%s", lock)
 	}
 	return nil
 }
+
+// Refresh resets the ttl of a lock still held, so that a long task can keep it.
+func (r *Lock) Refresh(lock string, lockSeconds int) error {
+	if lockSeconds <= 0 {
+		lockSeconds = r.LockSeconds
+	}
+	conn := r.Pool.Get()
+	defer func() { _ = conn.Close() }()
+
+	ok, err := redis.Bool(conn.Do("EXPIRE", lock, lockSeconds))
+	if err != nil {
+		return errors.Wrapf(err, "续期锁失败，lock=%s", lock)
+	}
+	if !ok {
+		return errors.Errorf("续期锁失败，锁已失效，lock=%s", lock)
+	}
+	return nil
+}
+
+// FenceKey returns the companion key of lock holding its last fencing token.
+func FenceKey(lock string) string {
+	return lock + ":fence"
+}
+
+// AcquireWithFence
+//
+// Acquire lock like Acquire, and return a fencing token, which increases with
+// every acquisition of lock. Pass it to the resources written while holding
+// the lock, so that they can reject a holder whose lock expired meanwhile,
+// e.g. after a long GC pause, see CheckFencingToken.
+//
+// Example:
+//
+//	token, err := lock.AcquireWithFence("lock:order:1", 10)
+//	if err != nil {
+//		return err
+//	}
+//	defer lock.ReleaseWithFence("lock:order:1", token)
+//	// UPDATE orders SET state = ?, fence = ? WHERE id = ? AND fence <= ?
+//	res, err := db.Exec(query, state, token, id, token)
+func (r *Lock) AcquireWithFence(lock string, lockSeconds int) (int64, error) {
+	if lockSeconds <= 0 {
+		lockSeconds = r.LockSeconds
+	}
+	token, err := redis.Int64(lockAcquireFencedScript.Exec(r.Pool, lock, FenceKey(lock), lockSeconds))
+	if err != nil {
+		return 0, errors.Wrapf(err, "获取锁失败，lock=%s", lock)
+	}
+	return token, nil
+}
+
+// ReleaseWithFence releases lock only if it is still held with token, so that
+// a holder whose lock expired does not release the lock of another one.
+func (r *Lock) ReleaseWithFence(lock string, token int64) error {
+	n, err := redis.Int(lockReleaseFencedScript.Exec(r.Pool, lock, token))
+	if err != nil {
+		return errors.Wrapf(err, "删除锁失败,key=%s", lock)
+	}
+	if n == 0 {
+		return errors.Wrapf(ErrStaleFencingToken, "删除锁失败，锁已失效，lock=%s，token=%d", lock, token)
+	}
+	return nil
+}
+
+// RefreshWithFence is like Refresh, but only if lock is still held with token.
+func (r *Lock) RefreshWithFence(lock string, token int64, lockSeconds int) error {
+	if lockSeconds <= 0 {
+		lockSeconds = r.LockSeconds
+	}
+	n, err := redis.Int(lockRefreshFencedScript.Exec(r.Pool, lock, token, lockSeconds))
+	if err != nil {
+		return errors.Wrapf(err, "续期锁失败，lock=%s", lock)
+	}
+	if n == 0 {
+		return errors.Wrapf(ErrStaleFencingToken, "续期锁失败，锁已失效，lock=%s，token=%d", lock, token)
+	}
+	return nil
+}
+
+// CheckFence returns ErrStaleFencingToken if lock is no longer held with
+// token.
+func (r *Lock) CheckFence(lock string, token int64) error {
+	conn := r.Pool.Get()
+	defer func() { _ = conn.Close() }()
+
+	holder, err := redis.Int64(conn.Do("GET", lock))
+	if err != nil && err != redis.ErrNil {
+		return errors.Wrapf(err, "查询锁失败，lock=%s", lock)
+	}
+	if err == redis.ErrNil || holder != token {
+		return errors.Wrapf(ErrStaleFencingToken, "锁已失效，lock=%s，token=%d", lock, token)
+	}
+	return nil
+}
+
+// CompareFencingTokens returns -1, 0 or 1 if a was issued before, is or was
+// issued after b.
+func CompareFencingTokens(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// CheckFencingToken
+//
+// Check token against latest, the highest token accepted so far by a
+// resource. ErrStaleFencingToken is returned if token was issued before, the
+// resource should then reject the write and keep latest, otherwise it should
+// store token as its new latest. Equal tokens are accepted, since a holder
+// may write several times.
+func CheckFencingToken(latest, token int64) error {
+	if CompareFencingTokens(token, latest) < 0 {
+		return errors.Wrapf(ErrStaleFencingToken, "token %d is older than %d", token, latest)
+	}
+	return nil
+}
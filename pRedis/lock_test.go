@@ -0,0 +1,73 @@
+package pRedis_test
+
+import (
+	"github.com/pkg/errors"
+	"github.com/zzj-custom/pkg/pRedis"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	lock := pRedis.NewLock(pool)
+
+	if err := lock.Acquire("l", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Acquire("l", 10); err == nil {
+		t.Fatal("acquired a held lock")
+	}
+	if err := lock.Refresh("l", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release("l"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Acquire("l", 10); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+}
+
+func TestLockWithFence(t *testing.T) {
+	srv := newTestServer(t)
+	lock := pRedis.NewLock(newTestPool(t, srv, nil))
+
+	first, err := lock.AcquireWithFence("l", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lock.AcquireWithFence("l", 10); err == nil {
+		t.Fatal("acquired a held lock")
+	}
+	if err = lock.RefreshWithFence("l", first, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock of the first holder expires, a second one takes it
+	srv.Advance(11 * time.Second)
+	second, err := lock.AcquireWithFence("l", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pRedis.CompareFencingTokens(second, first) <= 0 {
+		t.Fatalf("token %d is not newer than %d", second, first)
+	}
+	for name, err := range map[string]error{
+		"RefreshWithFence": lock.RefreshWithFence("l", first, 10),
+		"ReleaseWithFence": lock.ReleaseWithFence("l", first),
+		"CheckFence":       lock.CheckFence("l", first),
+	} {
+		if errors.Cause(err) != pRedis.ErrStaleFencingToken {
+			t.Errorf("%s with the stale token = %v, want ErrStaleFencingToken", name, err)
+		}
+	}
+	if err = lock.CheckFence("l", second); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.ReleaseWithFence("l", second); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.CheckFence("l", second); errors.Cause(err) != pRedis.ErrStaleFencingToken {
+		t.Fatalf("CheckFence after release = %v, want ErrStaleFencingToken", err)
+	}
+}
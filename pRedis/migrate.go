@@ -0,0 +1,243 @@
+package pRedis
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+const maxMigrateFailures = 100
+
+type MigrateOptions struct {
+	// Match, Count and Type are passed to Scan, every key is migrated if
+	// Match is empty. Count is also the size of the batches of DUMP and
+	// RESTORE, about 10 keys if 0.
+	Match string
+	Count int
+	Type  string
+	// Interval is the minimum delay between two batches, use it to limit the
+	// load on production servers.
+	Interval time.Duration
+	// Cursor resumes a migration from a cursor saved by Checkpoint, or read
+	// from `MigrateReport.Cursor`.
+	Cursor uint64
+	// Replace overwrites keys existing in the target, they are skipped and
+	// counted as conflicts otherwise.
+	Replace bool
+	// DryRun reports what would be copied without writing to the target.
+	DryRun bool
+	// Checkpoint is called after each batch with the cursor to resume from,
+	// e.g. to save it. The migration stops if it returns an error.
+	Checkpoint func(cursor uint64) error
+}
+
+type MigrateFailure struct {
+	Key string
+	Err error
+}
+
+type MigrateReport struct {
+	// Scanned is the number of keys found in the source.
+	Scanned int64
+	// Copied is the number of keys restored in the target, or that would be
+	// restored in a dry run.
+	Copied int64
+	// Bytes is the size of the serialized values of the copied keys.
+	Bytes int64
+	// Conflicts is the number of keys skipped because they exist in the
+	// target, always 0 with Replace.
+	Conflicts int64
+	// Expired is the number of keys that expired before being copied.
+	Expired int64
+	// Failures are the keys that failed to be copied, the first 100 only are
+	// kept, Failed counts all of them.
+	Failures []MigrateFailure
+	Failed   int64
+	// Cursor resumes the migration where it stopped, see
+	// `MigrateOptions.Cursor`. Completed is true once the whole keyspace has
+	// been walked.
+	Cursor    uint64
+	Completed bool
+	Elapsed   time.Duration
+}
+
+func (r *MigrateReport) fail(key string, err error) {
+	r.Failed++
+	if len(r.Failures) < maxMigrateFailures {
+		r.Failures = append(r.Failures, MigrateFailure{Key: key, Err: err})
+	}
+}
+
+// MigrateKeys
+//
+// Copy the keys of the pool named from to the pool named to with `DUMP` and
+// `RESTORE`, keeping their ttl. Both pools must run compatible versions of
+// redis, and key prefixes of the pools are applied on both sides, so a
+// migration can also move keys to another prefix.
+//
+// Keys are walked with `SCAN` by batches, so a key written during the
+// migration may be missed or copied twice, and the last write wins. If ctx
+// is done, the report is returned with the error of ctx and the cursor to
+// resume from.
+//
+// Example:
+//
+//	report, err := pRedis.MigrateKeys(ctx, "old", "new", pRedis.MigrateOptions{
+//		Match:    "user:*",
+//		Count:    500,
+//		Interval: 10 * time.Millisecond,
+//		Cursor:   savedCursor,
+//		Checkpoint: func(cursor uint64) error {
+//			return saveCursor(cursor)
+//		},
+//	})
+func MigrateKeys(ctx context.Context, from, to string, opts MigrateOptions) (*MigrateReport, error) {
+	src, err := Pool(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := Pool(to)
+	if err != nil {
+		return nil, err
+	}
+	if src == dst {
+		return nil, errors.Errorf("cannot migrate pool %s to itself", from)
+	}
+
+	start := time.Now()
+	report := &MigrateReport{Cursor: opts.Cursor}
+	it := Scan(src, ScanOptions{
+		Match:    opts.Match,
+		Count:    opts.Count,
+		Type:     opts.Type,
+		Interval: opts.Interval,
+		Cursor:   opts.Cursor,
+	})
+	// keys fetched by one round trip of SCAN form a batch, cursor is the one
+	// to resume from once the batch is copied
+	var batch []string
+	cursor := it.Cursor()
+	flush := func(next uint64) error {
+		if err := migrateBatch(src, dst, batch, opts, report); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		report.Cursor = next
+		if opts.Checkpoint != nil {
+			if err := opts.Checkpoint(next); err != nil {
+				return errors.Wrap(err, "checkpoint failed")
+			}
+		}
+		return nil
+	}
+	for it.Next(ctx) {
+		if it.Cursor() != cursor {
+			if len(batch) > 0 {
+				if err = flush(cursor); err != nil {
+					report.Elapsed = time.Since(start)
+					return report, err
+				}
+			}
+			cursor = it.Cursor()
+		}
+		batch = append(batch, it.Key())
+		report.Scanned++
+	}
+	if err = it.Err(); err == nil {
+		// the cursor is 0 once the iteration is over
+		if err = flush(it.Cursor()); err == nil {
+			report.Completed = true
+		}
+	}
+	report.Elapsed = time.Since(start)
+	return report, err
+}
+
+// migrateBatch copies keys with two pipelines, one reading the source and one
+// writing the target.
+func migrateBatch(src, dst *redis.Pool, keys []string, opts MigrateOptions, report *MigrateReport) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	read := NewBatch(src)
+	ttls := make([]*Result, len(keys))
+	dumps := make([]*Result, len(keys))
+	for i, key := range keys {
+		ttls[i] = read.Queue("PTTL", key)
+		dumps[i] = read.Queue("DUMP", key)
+	}
+	if err := read.Exec(); err != nil {
+		return errors.Wrap(err, "dump keys failed")
+	}
+
+	write := NewBatch(dst)
+	queued := make([]string, 0, len(keys))
+	sizes := make([]int64, 0, len(keys))
+	results := make([]*Result, 0, len(keys))
+	for i, key := range keys {
+		ttl, err := ttls[i].Int64()
+		if err != nil {
+			report.fail(key, err)
+			continue
+		}
+		data, err := dumps[i].Bytes()
+		if err == redis.ErrNil || ttl == -2 {
+			report.Expired++
+			continue
+		}
+		if err != nil {
+			report.fail(key, err)
+			continue
+		}
+		if ttl < 0 {
+			// -1 means no ttl, RESTORE takes 0 for it
+			ttl = 0
+		}
+		args := redis.Args{}.Add(key, ttl, data)
+		if opts.Replace {
+			args = args.Add("REPLACE")
+		}
+		if opts.DryRun {
+			results = append(results, write.Queue("EXISTS", key))
+		} else {
+			results = append(results, write.Queue("RESTORE", args...))
+		}
+		queued = append(queued, key)
+		sizes = append(sizes, int64(len(data)))
+	}
+	if write.Len() == 0 {
+		return nil
+	}
+	if err := write.Exec(); err != nil {
+		return errors.Wrap(err, "restore keys failed")
+	}
+
+	for i, r := range results {
+		if opts.DryRun {
+			exists, err := r.Bool()
+			switch {
+			case err != nil:
+				report.fail(queued[i], err)
+			case exists && !opts.Replace:
+				report.Conflicts++
+			default:
+				report.Copied++
+				report.Bytes += sizes[i]
+			}
+			continue
+		}
+		err := r.Err()
+		switch {
+		case err == nil:
+			report.Copied++
+			report.Bytes += sizes[i]
+		case strings.HasPrefix(err.Error(), "BUSYKEY"):
+			report.Conflicts++
+		default:
+			report.fail(queued[i], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package pRedis_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"testing"
+)
+
+func TestMigrateKeys(t *testing.T) {
+	src, dst := newTestServer(t), newTestServer(t)
+	if _, err := src.InitPool("test-migrate-src"); err != nil {
+		t.Fatal(err)
+	}
+	dstConfig := dst.DialConfig()
+	dstConfig.KeyPrefix = "new:"
+	if _, err := pRedis.InitPool("test-migrate-dst", dstConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	srcConn := newTestPool(t, src, nil).Get()
+	defer srcConn.Close()
+	for i := 0; i < 25; i++ {
+		if _, err := srcConn.Do("SET", fmt.Sprintf("user:%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := srcConn.Do("HSET", "user:ttl", "name", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srcConn.Do("EXPIRE", "user:ttl", 60); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srcConn.Do("SET", "other", "skipped"); err != nil {
+		t.Fatal(err)
+	}
+	dstConn := newTestPool(t, dst, nil).Get()
+	defer dstConn.Close()
+	if _, err := dstConn.Do("SET", "new:user:0", "kept"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := pRedis.MigrateKeys(context.Background(), "test-migrate-src", "test-migrate-dst", pRedis.MigrateOptions{
+		Match:  "user:*",
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 25 || report.Conflicts != 1 {
+		t.Fatalf("dry run = %+v, want 25 copies and a conflict", report)
+	}
+	if n, _ := redis.Int(dstConn.Do("DBSIZE")); n != 1 {
+		t.Fatalf("DBSIZE after a dry run = %d, want nothing written", n)
+	}
+
+	var checkpoints []uint64
+	report, err = pRedis.MigrateKeys(context.Background(), "test-migrate-src", "test-migrate-dst", pRedis.MigrateOptions{
+		Match: "user:*",
+		Count: 5,
+		Checkpoint: func(cursor uint64) error {
+			checkpoints = append(checkpoints, cursor)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Completed || report.Scanned != 26 || report.Copied != 25 || report.Conflicts != 1 || report.Failed != 0 {
+		t.Fatalf("report = %+v, want 25 keys copied and a conflict", report)
+	}
+	if len(checkpoints) < 2 || checkpoints[len(checkpoints)-1] != 0 {
+		t.Fatalf("checkpoints = %v, want one per batch, ending with 0", checkpoints)
+	}
+	if v, _ := redis.String(dstConn.Do("GET", "new:user:0")); v != "kept" {
+		t.Errorf("GET new:user:0 = %q, want the conflicting key kept", v)
+	}
+	if v, _ := redis.String(dstConn.Do("HGET", "new:user:ttl", "name")); v != "alice" {
+		t.Errorf("HGET new:user:ttl = %q, want the hash copied", v)
+	}
+	if ttl, _ := redis.Int(dstConn.Do("TTL", "new:user:ttl")); ttl <= 0 || ttl > 60 {
+		t.Errorf("TTL new:user:ttl = %d, want the ttl of the source", ttl)
+	}
+	if ok, _ := redis.Bool(dstConn.Do("EXISTS", "new:other")); ok {
+		t.Error("a key not matching was copied")
+	}
+
+	report, err = pRedis.MigrateKeys(context.Background(), "test-migrate-src", "test-migrate-dst", pRedis.MigrateOptions{
+		Match:   "user:0",
+		Replace: true,
+	})
+	if err != nil || report.Copied != 1 {
+		t.Fatalf("MigrateKeys with Replace = %+v, %v, want the key copied", report, err)
+	}
+	if v, _ := redis.String(dstConn.Do("GET", "new:user:0")); v != "0" {
+		t.Errorf("GET new:user:0 = %q, want the key replaced", v)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = pRedis.MigrateKeys(ctx, "test-migrate-src", "test-migrate-dst", pRedis.MigrateOptions{})
+	if err != context.Canceled || report.Completed {
+		t.Fatalf("MigrateKeys with a cancelled context = %+v, %v, want it stopped", report, err)
+	}
+}
@@ -0,0 +1,1557 @@
+package pRedisFake
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var inf = math.Inf(1)
+
+type command struct {
+	minArgs int
+	fn      func(s *Server, c *client, args []string) interface{}
+}
+
+// noReply is returned by commands that write their replies by themselves.
+type noReply struct{}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		// connection and server
+		"PING":     {0, cmdPing},
+		"ECHO":     {1, func(s *Server, c *client, args []string) interface{} { return args[0] }},
+		"SELECT":   {1, cmdSelect},
+		"CONFIG":   {2, cmdConfig},
+		"FLUSHDB":  {0, cmdFlushDB},
+		"FLUSHALL": {0, cmdFlushAll},
+		"DBSIZE":   {0, func(s *Server, c *client, args []string) interface{} { return len(s.keys(c.db)) }},
+		"CLIENT":   {1, cmdClient},
+		"TIME":     {0, cmdTime},
+		"MEMORY":   {1, cmdMemory},
+
+		// keys
+		"DEL":       {1, cmdDel},
+		"UNLINK":    {1, cmdDel},
+		"EXISTS":    {1, cmdExists},
+		"EXPIRE":    {2, cmdExpire(time.Second, false)},
+		"PEXPIRE":   {2, cmdExpire(time.Millisecond, false)},
+		"EXPIREAT":  {2, cmdExpire(time.Second, true)},
+		"PEXPIREAT": {2, cmdExpire(time.Millisecond, true)},
+		"TTL":       {1, cmdTTL(time.Second)},
+		"PTTL":      {1, cmdTTL(time.Millisecond)},
+		"PERSIST":   {1, cmdPersist},
+		"DUMP":      {1, cmdDump},
+		"RESTORE":   {3, cmdRestore},
+		"TYPE":      {1, cmdType},
+		"KEYS":      {1, cmdKeys},
+		"SCAN":      {1, cmdScan},
+		"RENAME":    {2, cmdRename},
+
+		// strings
+		"GET": {1, cmdGet},
+		"SET": {2, cmdSet},
+		"SETNX": {2, func(s *Server, c *client, args []string) interface{} {
+			return boolReply(cmdSet(s, c, []string{args[0], args[1], "NX"}) != nil)
+		}},
+		"SETEX": {3, func(s *Server, c *client, args []string) interface{} {
+			return cmdSet(s, c, []string{args[0], args[2], "EX", args[1]})
+		}},
+		"PSETEX": {3, func(s *Server, c *client, args []string) interface{} {
+			return cmdSet(s, c, []string{args[0], args[2], "PX", args[1]})
+		}},
+		"GETSET": {2, func(s *Server, c *client, args []string) interface{} {
+			return cmdSet(s, c, []string{args[0], args[1], "GET"})
+		}},
+		"GETDEL":      {1, cmdGetDel},
+		"MGET":        {1, cmdMGet},
+		"MSET":        {2, cmdMSet},
+		"INCR":        {1, func(s *Server, c *client, args []string) interface{} { return incrBy(s, c, args[0], 1) }},
+		"DECR":        {1, func(s *Server, c *client, args []string) interface{} { return incrBy(s, c, args[0], -1) }},
+		"INCRBY":      {2, cmdIncrBy(1)},
+		"DECRBY":      {2, cmdIncrBy(-1)},
+		"INCRBYFLOAT": {2, cmdIncrByFloat},
+		"APPEND":      {2, cmdAppend},
+		"STRLEN":      {1, cmdStrlen},
+		"SETBIT":      {3, cmdSetBit},
+		"GETBIT":      {2, cmdGetBit},
+		"BITCOUNT":    {1, cmdBitCount},
+
+		// hashes
+		"HSET":         {3, cmdHSet},
+		"HMSET":        {3, func(s *Server, c *client, args []string) interface{} { return okOr(cmdHSet(s, c, args)) }},
+		"HSETNX":       {3, cmdHSetNX},
+		"HGET":         {2, cmdHGet},
+		"HMGET":        {2, cmdHMGet},
+		"HGETALL":      {1, cmdHGetAll},
+		"HDEL":         {2, cmdHDel},
+		"HEXISTS":      {2, cmdHExists},
+		"HLEN":         {1, cmdHLen},
+		"HKEYS":        {1, cmdHKeys},
+		"HVALS":        {1, cmdHVals},
+		"HINCRBY":      {3, cmdHIncrBy},
+		"HINCRBYFLOAT": {3, cmdHIncrByFloat},
+		"HSCAN":        {2, cmdHScan},
+
+		// lists
+		"LPUSH":  {2, cmdPush(true)},
+		"RPUSH":  {2, cmdPush(false)},
+		"LPOP":   {1, cmdPop(true)},
+		"RPOP":   {1, cmdPop(false)},
+		"LLEN":   {1, cmdLLen},
+		"LRANGE": {3, cmdLRange},
+		"LINDEX": {2, cmdLIndex},
+
+		// sets
+		"SADD":      {2, cmdSAdd},
+		"SREM":      {2, cmdSRem},
+		"SMEMBERS":  {1, cmdSMembers},
+		"SISMEMBER": {2, cmdSIsMember},
+		"SCARD":     {1, cmdSCard},
+		"SSCAN":     {2, cmdSScan},
+
+		// sorted sets
+		"ZADD":             {3, cmdZAdd},
+		"ZINCRBY":          {3, cmdZIncrBy},
+		"ZSCORE":           {2, cmdZScore},
+		"ZREM":             {2, cmdZRem},
+		"ZCARD":            {1, cmdZCard},
+		"ZRANK":            {2, cmdZRank(false)},
+		"ZREVRANK":         {2, cmdZRank(true)},
+		"ZRANGE":           {3, cmdZRange(false)},
+		"ZREVRANGE":        {3, cmdZRange(true)},
+		"ZRANGEBYSCORE":    {3, cmdZRangeByScore(false)},
+		"ZREVRANGEBYSCORE": {3, cmdZRangeByScore(true)},
+		"ZCOUNT":           {3, cmdZCount},
+		"ZREMRANGEBYSCORE": {3, cmdZRemRangeByScore},
+		"ZREMRANGEBYRANK":  {3, cmdZRemRangeByRank},
+		"ZSCAN":            {2, cmdZScan},
+
+		// geo
+		"GEOADD":    {4, cmdGeoAdd},
+		"GEOPOS":    {1, cmdGeoPos},
+		"GEOHASH":   {1, cmdGeoHash},
+		"GEODIST":   {3, cmdGeoDist},
+		"GEOSEARCH": {5, cmdGeoSearch},
+
+		// streams
+		"XADD":   {4, cmdXAdd},
+		"XLEN":   {1, cmdXLen},
+		"XRANGE": {3, cmdXRange},
+
+		// hyperloglog, counted exactly
+		"PFADD":   {1, cmdPFAdd},
+		"PFCOUNT": {1, cmdPFCount},
+		"PFMERGE": {1, cmdPFMerge},
+
+		// transactions
+		"WATCH": {1, cmdWatch},
+		"UNWATCH": {0, func(s *Server, c *client, args []string) interface{} {
+			c.watched = map[watchKey]uint64{}
+			return status("OK")
+		}},
+
+		// scripts
+		"EVAL":    {2, cmdEval},
+		"EVALSHA": {2, cmdEvalSHA},
+		"SCRIPT":  {1, cmdScript},
+
+		// pub/sub
+		"SUBSCRIBE":    {1, cmdSubscribe(false)},
+		"PSUBSCRIBE":   {1, cmdSubscribe(true)},
+		"UNSUBSCRIBE":  {0, cmdUnsubscribe(false)},
+		"PUNSUBSCRIBE": {0, cmdUnsubscribe(true)},
+		"PUBLISH":      {2, cmdPublish},
+	}
+}
+
+func boolReply(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// okOr turns any non error reply into OK.
+func okOr(reply interface{}) interface{} {
+	if e, ok := reply.(redisError); ok {
+		return e
+	}
+	return status("OK")
+}
+
+func cmdPing(s *Server, c *client, args []string) interface{} {
+	if c.subscribed() {
+		data := ""
+		if len(args) > 0 {
+			data = args[0]
+		}
+		return []interface{}{"pong", data}
+	}
+	if len(args) > 0 {
+		return args[0]
+	}
+	return status("PONG")
+}
+
+func cmdSelect(s *Server, c *client, args []string) interface{} {
+	index, ok := parseInt(args[0])
+	if !ok || index < 0 || index > 15 {
+		return redisError("ERR DB index is out of range")
+	}
+	c.db = int(index)
+	return status("OK")
+}
+
+func cmdConfig(s *Server, c *client, args []string) interface{} {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		var reply []interface{}
+		for name, value := range s.config {
+			if globMatch(args[1], name) {
+				reply = append(reply, name, value)
+			}
+		}
+		return reply
+	case "SET":
+		if len(args) < 3 {
+			return errWrongArgs("config|set")
+		}
+		s.config[strings.ToLower(args[1])] = args[2]
+		return status("OK")
+	}
+	return errSyntax
+}
+
+func cmdFlushDB(s *Server, c *client, args []string) interface{} {
+	for _, key := range s.keys(c.db) {
+		s.remove(c.db, key)
+	}
+	return status("OK")
+}
+
+func cmdFlushAll(s *Server, c *client, args []string) interface{} {
+	for index := range s.dbs {
+		for _, key := range s.keys(index) {
+			s.remove(index, key)
+		}
+	}
+	return status("OK")
+}
+
+func cmdClient(s *Server, c *client, args []string) interface{} {
+	switch strings.ToUpper(args[0]) {
+	case "ID":
+		return c.id
+	case "SETNAME":
+		return status("OK")
+	case "GETNAME":
+		return nil
+	case "TRACKING":
+		if len(args) < 2 {
+			return errWrongArgs("client|tracking")
+		}
+		return cmdClientTracking(s, c, args[1:])
+	}
+	return errSyntax
+}
+
+func cmdTime(s *Server, c *client, args []string) interface{} {
+	now := s.now()
+	return []interface{}{
+		strconv.FormatInt(now.Unix(), 10),
+		strconv.Itoa(now.Nanosecond() / 1000),
+	}
+}
+
+// cmdMemory supports `MEMORY USAGE`, the size is a rough estimate.
+func cmdMemory(s *Server, c *client, args []string) interface{} {
+	if strings.ToUpper(args[0]) != "USAGE" || len(args) < 2 {
+		return errSyntax
+	}
+	it := s.lookup(c.db, args[1])
+	if it == nil {
+		return nil
+	}
+	size := int64(len(args[1]) + 48)
+	size += int64(len(it.str))
+	for k, v := range it.hash {
+		size += int64(len(k) + len(v) + 16)
+	}
+	for _, v := range it.list {
+		size += int64(len(v) + 8)
+	}
+	for k := range it.set {
+		size += int64(len(k) + 8)
+	}
+	for k := range it.zset {
+		size += int64(len(k) + 24)
+	}
+	for _, e := range it.stream {
+		size += 16
+		for _, f := range e.fields {
+			size += int64(len(f) + 8)
+		}
+	}
+	return size
+}
+
+func cmdDel(s *Server, c *client, args []string) interface{} {
+	var n int64
+	for _, key := range args {
+		if s.remove(c.db, key) {
+			s.notify(c.db, 'g', "del", key)
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Server, c *client, args []string) interface{} {
+	var n int64
+	for _, key := range args {
+		if s.lookup(c.db, key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExpire(unit time.Duration, at bool) func(s *Server, c *client, args []string) interface{} {
+	return func(s *Server, c *client, args []string) interface{} {
+		n, ok := parseInt(args[1])
+		if !ok {
+			return errNotInt
+		}
+		it := s.lookup(c.db, args[0])
+		if it == nil {
+			return int64(0)
+		}
+		var expireAt time.Time
+		if at {
+			expireAt = time.Unix(0, 0).Add(time.Duration(n) * unit)
+		} else {
+			expireAt = s.now().Add(time.Duration(n) * unit)
+		}
+		s.touch(c.db, args[0])
+		if !expireAt.After(s.now()) {
+			s.remove(c.db, args[0])
+			s.notify(c.db, 'g', "del", args[0])
+			return int64(1)
+		}
+		it.expireAt = expireAt
+		s.notify(c.db, 'g', "expire", args[0])
+		return int64(1)
+	}
+}
+
+func cmdTTL(unit time.Duration) func(s *Server, c *client, args []string) interface{} {
+	return func(s *Server, c *client, args []string) interface{} {
+		it := s.lookup(c.db, args[0])
+		if it == nil {
+			return int64(-2)
+		}
+		if it.expireAt.IsZero() {
+			return int64(-1)
+		}
+		left := it.expireAt.Sub(s.now())
+		return int64((left + unit/2) / unit)
+	}
+}
+
+func cmdPersist(s *Server, c *client, args []string) interface{} {
+	it := s.lookup(c.db, args[0])
+	if it == nil || it.expireAt.IsZero() {
+		return int64(0)
+	}
+	it.expireAt = time.Time{}
+	s.touch(c.db, args[0])
+	return int64(1)
+}
+
+func cmdType(s *Server, c *client, args []string) interface{} {
+	it := s.lookup(c.db, args[0])
+	if it == nil {
+		return status("none")
+	}
+	return status(it.kind)
+}
+
+func cmdKeys(s *Server, c *client, args []string) interface{} {
+	keys := []string{}
+	for _, key := range s.keys(c.db) {
+		if globMatch(args[0], key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+type scanArgs struct {
+	cursor int
+	match  string
+	count  int
+	kind   string
+}
+
+func parseScanArgs(args []string) (scanArgs, interface{}) {
+	sa := scanArgs{count: 10}
+	cursor, ok := parseInt(args[0])
+	if !ok || cursor < 0 {
+		return sa, redisError("ERR invalid cursor")
+	}
+	sa.cursor = int(cursor)
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return sa, errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			sa.match = args[i+1]
+		case "COUNT":
+			n, ok := parseInt(args[i+1])
+			if !ok || n < 1 {
+				return sa, errSyntax
+			}
+			sa.count = int(n)
+		case "TYPE":
+			sa.kind = strings.ToLower(args[i+1])
+		default:
+			return sa, errSyntax
+		}
+	}
+	return sa, nil
+}
+
+func cmdScan(s *Server, c *client, args []string) interface{} {
+	sa, errReply := parseScanArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	keys := s.keys(c.db)
+	end := sa.cursor + sa.count
+	next := end
+	if end >= len(keys) {
+		end = len(keys)
+		next = 0
+	}
+	found := []string{}
+	for i := sa.cursor; i < end; i++ {
+		if sa.match != "" && !globMatch(sa.match, keys[i]) {
+			continue
+		}
+		if sa.kind != "" && s.lookup(c.db, keys[i]).kind != sa.kind {
+			continue
+		}
+		found = append(found, keys[i])
+	}
+	return []interface{}{strconv.Itoa(next), found}
+}
+
+func cmdRename(s *Server, c *client, args []string) interface{} {
+	it := s.lookup(c.db, args[0])
+	if it == nil {
+		return redisError("ERR no such key")
+	}
+	d := s.db(c.db)
+	delete(d.items, args[0])
+	d.items[args[1]] = it
+	s.touch(c.db, args[0])
+	s.touch(c.db, args[1])
+	s.notify(c.db, 'g', "rename_from", args[0])
+	s.notify(c.db, 'g', "rename_to", args[1])
+	return status("OK")
+}
+
+func cmdGet(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeString)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return nil
+	}
+	return it.str
+}
+
+func cmdSet(s *Server, c *client, args []string) interface{} {
+	key, value := args[0], args[1]
+	var nx, xx, keepTTL, get bool
+	var ttl time.Duration
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "GET":
+			get = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return errNotInt
+			}
+			if n <= 0 {
+				return redisError("ERR invalid expire time in 'set' command")
+			}
+			ttl = time.Duration(n) * time.Millisecond
+			if strings.ToUpper(args[i]) == "EX" {
+				ttl = time.Duration(n) * time.Second
+			}
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	if nx && xx {
+		return errSyntax
+	}
+	old := s.lookup(c.db, key)
+	var oldValue interface{}
+	if get && old != nil {
+		if old.kind != typeString {
+			return errWrongType
+		}
+		oldValue = old.str
+	}
+	if (nx && old != nil) || (xx && old == nil) {
+		if get {
+			return oldValue
+		}
+		return nil
+	}
+	it := &item{kind: typeString, str: []byte(value)}
+	if ttl > 0 {
+		it.expireAt = s.now().Add(ttl)
+	} else if keepTTL && old != nil {
+		it.expireAt = old.expireAt
+	}
+	s.db(c.db).items[key] = it
+	s.touch(c.db, key)
+	s.notify(c.db, '$', "set", key)
+	if get {
+		return oldValue
+	}
+	return status("OK")
+}
+
+func cmdGetDel(s *Server, c *client, args []string) interface{} {
+	reply := cmdGet(s, c, args)
+	if b, ok := reply.([]byte); ok {
+		s.remove(c.db, args[0])
+		s.notify(c.db, 'g', "del", args[0])
+		return b
+	}
+	return reply
+}
+
+func cmdMGet(s *Server, c *client, args []string) interface{} {
+	reply := make([]interface{}, len(args))
+	for i, key := range args {
+		it := s.lookup(c.db, key)
+		if it != nil && it.kind == typeString {
+			reply[i] = it.str
+		}
+	}
+	return reply
+}
+
+func cmdMSet(s *Server, c *client, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return errWrongArgs("mset")
+	}
+	for i := 0; i < len(args); i += 2 {
+		cmdSet(s, c, args[i:i+2])
+	}
+	return status("OK")
+}
+
+func incrBy(s *Server, c *client, key string, delta int64) interface{} {
+	it, errReply := s.create(c.db, key, typeString)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	if len(it.str) > 0 {
+		var ok bool
+		if n, ok = parseInt(string(it.str)); !ok {
+			return errNotInt
+		}
+	}
+	n += delta
+	it.str = []byte(strconv.FormatInt(n, 10))
+	s.touch(c.db, key)
+	s.notify(c.db, '$', "incrby", key)
+	return n
+}
+
+func cmdIncrBy(sign int64) func(s *Server, c *client, args []string) interface{} {
+	return func(s *Server, c *client, args []string) interface{} {
+		delta, ok := parseInt(args[1])
+		if !ok {
+			return errNotInt
+		}
+		return incrBy(s, c, args[0], sign*delta)
+	}
+}
+
+func cmdIncrByFloat(s *Server, c *client, args []string) interface{} {
+	delta, ok := parseFloat(args[1])
+	if !ok {
+		return errNotFloat
+	}
+	it, errReply := s.create(c.db, args[0], typeString)
+	if errReply != nil {
+		return errReply
+	}
+	var f float64
+	if len(it.str) > 0 {
+		if f, ok = parseFloat(string(it.str)); !ok {
+			return errNotFloat
+		}
+	}
+	f += delta
+	it.str = []byte(formatFloat(f))
+	s.touch(c.db, args[0])
+	s.notify(c.db, '$', "incrbyfloat", args[0])
+	return it.str
+}
+
+func cmdAppend(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.create(c.db, args[0], typeString)
+	if errReply != nil {
+		return errReply
+	}
+	it.str = append(it.str, args[1]...)
+	s.touch(c.db, args[0])
+	s.notify(c.db, '$', "append", args[0])
+	return int64(len(it.str))
+}
+
+func cmdStrlen(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeString)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.str))
+}
+
+func cmdSetBit(s *Server, c *client, args []string) interface{} {
+	offset, ok := parseInt(args[1])
+	if !ok || offset < 0 || offset >= 1<<32 {
+		return redisError("ERR bit offset is not an integer or out of range")
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return redisError("ERR bit is not an integer or out of range")
+	}
+	it, errReply := s.create(c.db, args[0], typeString)
+	if errReply != nil {
+		return errReply
+	}
+	index := int(offset / 8)
+	if index >= len(it.str) {
+		it.str = append(it.str, make([]byte, index+1-len(it.str))...)
+	}
+	mask := byte(1) << (7 - uint(offset%8))
+	old := it.str[index]&mask != 0
+	if args[2] == "1" {
+		it.str[index] |= mask
+	} else {
+		it.str[index] &^= mask
+	}
+	s.touch(c.db, args[0])
+	s.notify(c.db, '$', "setbit", args[0])
+	return boolReply(old)
+}
+
+func cmdGetBit(s *Server, c *client, args []string) interface{} {
+	offset, ok := parseInt(args[1])
+	if !ok || offset < 0 {
+		return redisError("ERR bit offset is not an integer or out of range")
+	}
+	it, errReply := s.lookupKind(c.db, args[0], typeString)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil || int(offset/8) >= len(it.str) {
+		return int64(0)
+	}
+	return boolReply(it.str[offset/8]&(byte(1)<<(7-uint(offset%8))) != 0)
+}
+
+func cmdBitCount(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeString)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	var n int64
+	for _, b := range it.str {
+		for ; b != 0; b &= b - 1 {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdHSet(s *Server, c *client, args []string) interface{} {
+	if len(args)%2 != 1 {
+		return errWrongArgs("hset")
+	}
+	it, errReply := s.create(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := it.hash[args[i]]; !ok {
+			n++
+		}
+		it.hash[args[i]] = args[i+1]
+	}
+	s.touch(c.db, args[0])
+	s.notify(c.db, 'h', "hset", args[0])
+	return n
+}
+
+func cmdHSetNX(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.create(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	if _, ok := it.hash[args[1]]; ok {
+		return int64(0)
+	}
+	it.hash[args[1]] = args[2]
+	s.touch(c.db, args[0])
+	s.notify(c.db, 'h', "hset", args[0])
+	return int64(1)
+}
+
+func cmdHGet(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return nil
+	}
+	if v, ok := it.hash[args[1]]; ok {
+		return v
+	}
+	return nil
+}
+
+func cmdHMGet(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	reply := make([]interface{}, len(args)-1)
+	if it == nil {
+		return reply
+	}
+	for i, field := range args[1:] {
+		if v, ok := it.hash[field]; ok {
+			reply[i] = v
+		}
+	}
+	return reply
+}
+
+func cmdHGetAll(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	reply := []string{}
+	if it == nil {
+		return reply
+	}
+	for _, field := range it.sortedKeys() {
+		reply = append(reply, field, it.hash[field])
+	}
+	return reply
+}
+
+func cmdHDel(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	var n int64
+	for _, field := range args[1:] {
+		if _, ok := it.hash[field]; ok {
+			delete(it.hash, field)
+			n++
+		}
+	}
+	if n > 0 {
+		s.removeIfEmpty(c.db, args[0], it)
+		s.touch(c.db, args[0])
+		s.notify(c.db, 'h', "hdel", args[0])
+	}
+	return n
+}
+
+func cmdHExists(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	_, ok := it.hash[args[1]]
+	return boolReply(ok)
+}
+
+func cmdHLen(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.hash))
+}
+
+func cmdHKeys(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return []string{}
+	}
+	return it.sortedKeys()
+}
+
+func cmdHVals(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	values := []string{}
+	if it == nil {
+		return values
+	}
+	for _, field := range it.sortedKeys() {
+		values = append(values, it.hash[field])
+	}
+	return values
+}
+
+func cmdHIncrBy(s *Server, c *client, args []string) interface{} {
+	delta, ok := parseInt(args[2])
+	if !ok {
+		return errNotInt
+	}
+	it, errReply := s.create(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	if v, exists := it.hash[args[1]]; exists {
+		if n, ok = parseInt(v); !ok {
+			return redisError("ERR hash value is not an integer")
+		}
+	}
+	n += delta
+	it.hash[args[1]] = strconv.FormatInt(n, 10)
+	s.touch(c.db, args[0])
+	s.notify(c.db, 'h', "hincrby", args[0])
+	return n
+}
+
+func cmdHIncrByFloat(s *Server, c *client, args []string) interface{} {
+	delta, ok := parseFloat(args[2])
+	if !ok {
+		return errNotFloat
+	}
+	it, errReply := s.create(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	var f float64
+	if v, exists := it.hash[args[1]]; exists {
+		if f, ok = parseFloat(v); !ok {
+			return redisError("ERR hash value is not a float")
+		}
+	}
+	f += delta
+	it.hash[args[1]] = formatFloat(f)
+	s.touch(c.db, args[0])
+	s.notify(c.db, 'h', "hincrbyfloat", args[0])
+	return it.hash[args[1]]
+}
+
+// The collections of the fake are small, so HSCAN, SSCAN and ZSCAN return every
+// matching element in a single round trip.
+func cmdHScan(s *Server, c *client, args []string) interface{} {
+	sa, errReply := parseScanArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	it, errReply := s.lookupKind(c.db, args[0], typeHash)
+	if errReply != nil {
+		return errReply
+	}
+	found := []string{}
+	if it != nil {
+		for _, field := range it.sortedKeys() {
+			if sa.match == "" || globMatch(sa.match, field) {
+				found = append(found, field, it.hash[field])
+			}
+		}
+	}
+	return []interface{}{"0", found}
+}
+
+func cmdPush(left bool) func(s *Server, c *client, args []string) interface{} {
+	return func(s *Server, c *client, args []string) interface{} {
+		it, errReply := s.create(c.db, args[0], typeList)
+		if errReply != nil {
+			return errReply
+		}
+		for _, v := range args[1:] {
+			if left {
+				it.list = append([]string{v}, it.list...)
+			} else {
+				it.list = append(it.list, v)
+			}
+		}
+		s.touch(c.db, args[0])
+		if left {
+			s.notify(c.db, 'l', "lpush", args[0])
+		} else {
+			s.notify(c.db, 'l', "rpush", args[0])
+		}
+		return int64(len(it.list))
+	}
+}
+
+func cmdPop(left bool) func(s *Server, c *client, args []string) interface{} {
+	return func(s *Server, c *client, args []string) interface{} {
+		it, errReply := s.lookupKind(c.db, args[0], typeList)
+		if errReply != nil {
+			return errReply
+		}
+		if it == nil {
+			return nil
+		}
+		var v string
+		if left {
+			v, it.list = it.list[0], it.list[1:]
+		} else {
+			v, it.list = it.list[len(it.list)-1], it.list[:len(it.list)-1]
+		}
+		s.removeIfEmpty(c.db, args[0], it)
+		s.touch(c.db, args[0])
+		return v
+	}
+}
+
+func cmdLLen(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeList)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.list))
+}
+
+// rangeIndexes converts redis start/stop indexes, which may be negative, into
+// a slice range of a collection of n elements.
+func rangeIndexes(start, stop int64, n int) (int, int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+func cmdLRange(s *Server, c *client, args []string) interface{} {
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		return errNotInt
+	}
+	it, errReply := s.lookupKind(c.db, args[0], typeList)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return []string{}
+	}
+	from, to := rangeIndexes(start, stop, len(it.list))
+	return append([]string{}, it.list[from:to]...)
+}
+
+func cmdLIndex(s *Server, c *client, args []string) interface{} {
+	index, ok := parseInt(args[1])
+	if !ok {
+		return errNotInt
+	}
+	it, errReply := s.lookupKind(c.db, args[0], typeList)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return nil
+	}
+	if index < 0 {
+		index += int64(len(it.list))
+	}
+	if index < 0 || index >= int64(len(it.list)) {
+		return nil
+	}
+	return it.list[index]
+}
+
+func cmdSAdd(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.create(c.db, args[0], typeSet)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	for _, m := range args[1:] {
+		if _, ok := it.set[m]; !ok {
+			it.set[m] = struct{}{}
+			n++
+		}
+	}
+	s.touch(c.db, args[0])
+	s.notify(c.db, 's', "sadd", args[0])
+	return n
+}
+
+func cmdSRem(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeSet)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	var n int64
+	for _, m := range args[1:] {
+		if _, ok := it.set[m]; ok {
+			delete(it.set, m)
+			n++
+		}
+	}
+	if n > 0 {
+		s.removeIfEmpty(c.db, args[0], it)
+		s.touch(c.db, args[0])
+		s.notify(c.db, 's', "srem", args[0])
+	}
+	return n
+}
+
+func cmdSMembers(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeSet)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return []string{}
+	}
+	return it.sortedKeys()
+}
+
+func cmdSIsMember(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeSet)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	_, ok := it.set[args[1]]
+	return boolReply(ok)
+}
+
+func cmdSCard(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeSet)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.set))
+}
+
+func cmdSScan(s *Server, c *client, args []string) interface{} {
+	sa, errReply := parseScanArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	it, errReply := s.lookupKind(c.db, args[0], typeSet)
+	if errReply != nil {
+		return errReply
+	}
+	found := []string{}
+	if it != nil {
+		for _, m := range it.sortedKeys() {
+			if sa.match == "" || globMatch(sa.match, m) {
+				found = append(found, m)
+			}
+		}
+	}
+	return []interface{}{"0", found}
+}
+
+func cmdZAdd(s *Server, c *client, args []string) interface{} {
+	var nx, xx, ch, incr bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break flags
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 || (nx && xx) || (incr && len(pairs) != 2) {
+		return errSyntax
+	}
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		f, ok := parseFloat(pairs[2*j])
+		if !ok {
+			return errNotFloat
+		}
+		scores[j] = f
+	}
+	it, errReply := s.create(c.db, args[0], typeZSet)
+	if errReply != nil {
+		return errReply
+	}
+	var added, changed int64
+	var result interface{}
+	for j, score := range scores {
+		member := pairs[2*j+1]
+		old, exists := it.zset[member]
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if incr {
+			score += old
+			result = score
+		}
+		if !exists {
+			added++
+		} else if old != score {
+			changed++
+		}
+		it.zset[member] = score
+	}
+	s.removeIfEmpty(c.db, args[0], it)
+	s.touch(c.db, args[0])
+	s.notify(c.db, 'z', "zadd", args[0])
+	if incr {
+		return result
+	}
+	if ch {
+		return added + changed
+	}
+	return added
+}
+
+func cmdZIncrBy(s *Server, c *client, args []string) interface{} {
+	return cmdZAdd(s, c, []string{args[0], "INCR", args[1], args[2]})
+}
+
+func cmdZScore(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return nil
+	}
+	if score, ok := it.zset[args[1]]; ok {
+		return score
+	}
+	return nil
+}
+
+func cmdZRem(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	var n int64
+	for _, m := range args[1:] {
+		if _, ok := it.zset[m]; ok {
+			delete(it.zset, m)
+			n++
+		}
+	}
+	if n > 0 {
+		s.removeIfEmpty(c.db, args[0], it)
+		s.touch(c.db, args[0])
+		s.notify(c.db, 'z', "zrem", args[0])
+	}
+	return n
+}
+
+func cmdZCard(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.zset))
+}
+
+func cmdZRank(reverse bool) func(s *Server, c *client, args []string) interface{} {
+	return func(s *Server, c *client, args []string) interface{} {
+		it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+		if errReply != nil {
+			return errReply
+		}
+		if it == nil {
+			return nil
+		}
+		members := it.sorted()
+		for i, m := range members {
+			if m.member == args[1] {
+				if reverse {
+					return int64(len(members) - 1 - i)
+				}
+				return int64(i)
+			}
+		}
+		return nil
+	}
+}
+
+func zmembersReply(members []zmember, withScores bool) []interface{} {
+	reply := []interface{}{}
+	for _, m := range members {
+		reply = append(reply, m.member)
+		if withScores {
+			reply = append(reply, m.score)
+		}
+	}
+	return reply
+}
+
+func reverseZMembers(members []zmember) {
+	for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+		members[i], members[j] = members[j], members[i]
+	}
+}
+
+func cmdZRange(reverse bool) func(s *Server, c *client, args []string) interface{} {
+	return func(s *Server, c *client, args []string) interface{} {
+		start, ok1 := parseInt(args[1])
+		stop, ok2 := parseInt(args[2])
+		if !ok1 || !ok2 {
+			return errNotInt
+		}
+		withScores := len(args) > 3 && strings.EqualFold(args[3], "WITHSCORES")
+		it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+		if errReply != nil {
+			return errReply
+		}
+		if it == nil {
+			return []interface{}{}
+		}
+		members := it.sorted()
+		if reverse {
+			reverseZMembers(members)
+		}
+		from, to := rangeIndexes(start, stop, len(members))
+		return zmembersReply(members[from:to], withScores)
+	}
+}
+
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(s string) (scoreBound, bool) {
+	b := scoreBound{}
+	if strings.HasPrefix(s, "(") {
+		b.exclusive = true
+		s = s[1:]
+	}
+	f, ok := parseFloat(s)
+	b.value = f
+	return b, ok
+}
+
+func (b scoreBound) below(score float64) bool {
+	if b.exclusive {
+		return b.value < score
+	}
+	return b.value <= score
+}
+
+func (b scoreBound) above(score float64) bool {
+	if b.exclusive {
+		return score < b.value
+	}
+	return score <= b.value
+}
+
+func (s *Server) zrangeByScore(c *client, key, minArg, maxArg string) ([]zmember, interface{}) {
+	min, ok1 := parseScoreBound(minArg)
+	max, ok2 := parseScoreBound(maxArg)
+	if !ok1 || !ok2 {
+		return nil, redisError("ERR min or max is not a float")
+	}
+	it, errReply := s.lookupKind(c.db, key, typeZSet)
+	if errReply != nil || it == nil {
+		return nil, errReply
+	}
+	var members []zmember
+	for _, m := range it.sorted() {
+		if min.below(m.score) && max.above(m.score) {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func cmdZRangeByScore(reverse bool) func(s *Server, c *client, args []string) interface{} {
+	return func(s *Server, c *client, args []string) interface{} {
+		minArg, maxArg := args[1], args[2]
+		if reverse {
+			minArg, maxArg = maxArg, minArg
+		}
+		withScores := false
+		offset, count := int64(0), int64(-1)
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "WITHSCORES":
+				withScores = true
+			case "LIMIT":
+				if i+2 >= len(args) {
+					return errSyntax
+				}
+				var ok1, ok2 bool
+				offset, ok1 = parseInt(args[i+1])
+				count, ok2 = parseInt(args[i+2])
+				if !ok1 || !ok2 {
+					return errNotInt
+				}
+				i += 2
+			default:
+				return errSyntax
+			}
+		}
+		members, errReply := s.zrangeByScore(c, args[0], minArg, maxArg)
+		if errReply != nil {
+			return errReply
+		}
+		if reverse {
+			reverseZMembers(members)
+		}
+		if offset > int64(len(members)) {
+			offset = int64(len(members))
+		}
+		members = members[offset:]
+		if count >= 0 && count < int64(len(members)) {
+			members = members[:count]
+		}
+		return zmembersReply(members, withScores)
+	}
+}
+
+func cmdZCount(s *Server, c *client, args []string) interface{} {
+	members, errReply := s.zrangeByScore(c, args[0], args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	return int64(len(members))
+}
+
+func cmdZRemRangeByScore(s *Server, c *client, args []string) interface{} {
+	members, errReply := s.zrangeByScore(c, args[0], args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	rem := []string{args[0]}
+	for _, m := range members {
+		rem = append(rem, m.member)
+	}
+	if len(rem) == 1 {
+		return int64(0)
+	}
+	return cmdZRem(s, c, rem)
+}
+
+func cmdZRemRangeByRank(s *Server, c *client, args []string) interface{} {
+	members, ok := cmdZRange(false)(s, c, args[:3]).([]interface{})
+	if !ok {
+		return cmdZRange(false)(s, c, args[:3])
+	}
+	if len(members) == 0 {
+		return int64(0)
+	}
+	rem := []string{args[0]}
+	for _, m := range members {
+		rem = append(rem, m.(string))
+	}
+	return cmdZRem(s, c, rem)
+}
+
+func cmdZScan(s *Server, c *client, args []string) interface{} {
+	sa, errReply := parseScanArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+	if errReply != nil {
+		return errReply
+	}
+	found := []interface{}{}
+	if it != nil {
+		for _, m := range it.sorted() {
+			if sa.match == "" || globMatch(sa.match, m.member) {
+				found = append(found, m.member, m.score)
+			}
+		}
+	}
+	return []interface{}{"0", found}
+}
+
+func (s *Server) hll(index int, key string, create bool) (*item, interface{}) {
+	it, errReply := s.lookupKind(index, key, typeString)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if it != nil && !it.hll {
+		return nil, redisError("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	}
+	if it == nil && create {
+		it = &item{kind: typeString, hll: true, set: map[string]struct{}{}}
+		s.db(index).items[key] = it
+	}
+	return it, nil
+}
+
+func cmdPFAdd(s *Server, c *client, args []string) interface{} {
+	_, exists := s.db(c.db).items[args[0]]
+	it, errReply := s.hll(c.db, args[0], true)
+	if errReply != nil {
+		return errReply
+	}
+	changed := !exists
+	for _, v := range args[1:] {
+		if _, ok := it.set[v]; !ok {
+			it.set[v] = struct{}{}
+			changed = true
+		}
+	}
+	if changed {
+		s.touch(c.db, args[0])
+	}
+	return boolReply(changed)
+}
+
+func cmdPFCount(s *Server, c *client, args []string) interface{} {
+	union := map[string]struct{}{}
+	for _, key := range args {
+		it, errReply := s.hll(c.db, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		if it == nil {
+			continue
+		}
+		for v := range it.set {
+			union[v] = struct{}{}
+		}
+	}
+	return int64(len(union))
+}
+
+func cmdPFMerge(s *Server, c *client, args []string) interface{} {
+	dest, errReply := s.hll(c.db, args[0], true)
+	if errReply != nil {
+		return errReply
+	}
+	for _, key := range args[1:] {
+		it, errReply := s.hll(c.db, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		if it == nil {
+			continue
+		}
+		for v := range it.set {
+			dest.set[v] = struct{}{}
+		}
+	}
+	s.touch(c.db, args[0])
+	return status("OK")
+}
+
+func cmdWatch(s *Server, c *client, args []string) interface{} {
+	for _, key := range args {
+		s.lookup(c.db, key)
+		c.watched[watchKey{db: c.db, key: key}] = s.db(c.db).versions[key]
+	}
+	return status("OK")
+}
+
+// sortedSet returns the members of a set ordered, for stable replies.
+func sortedSet(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for m := range set {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
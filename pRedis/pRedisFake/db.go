@@ -0,0 +1,207 @@
+package pRedisFake
+
+import (
+	"github.com/zzj-custom/pkg/pRedis"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	typeString = "string"
+	typeHash   = "hash"
+	typeList   = "list"
+	typeSet    = "set"
+	typeZSet   = "zset"
+	typeStream = "stream"
+)
+
+type item struct {
+	kind     string
+	str      []byte
+	hash     map[string]string
+	list     []string
+	set      map[string]struct{}
+	zset     map[string]float64
+	stream   []streamEntry
+	hll      bool
+	expireAt time.Time
+}
+
+type db struct {
+	items    map[string]*item
+	versions map[string]uint64
+}
+
+func (s *Server) db(index int) *db {
+	d, ok := s.dbs[index]
+	if !ok {
+		d = &db{items: map[string]*item{}, versions: map[string]uint64{}}
+		s.dbs[index] = d
+	}
+	return d
+}
+
+// touch marks key as modified, which fails transactions watching it and
+// invalidates clients tracking it.
+func (s *Server) touch(index int, key string) {
+	s.version++
+	s.db(index).versions[key] = s.version
+	s.invalidate(key)
+}
+
+// lookup returns the live item of key, expiring it if its ttl elapsed.
+func (s *Server) lookup(index int, key string) *item {
+	d := s.db(index)
+	it, ok := d.items[key]
+	if !ok {
+		return nil
+	}
+	if !it.expireAt.IsZero() && !s.now().Before(it.expireAt) {
+		delete(d.items, key)
+		s.touch(index, key)
+		s.notify(index, 'x', "expired", key)
+		return nil
+	}
+	return it
+}
+
+// lookupKind is lookup, failing with errWrongType if key holds another type.
+func (s *Server) lookupKind(index int, key, kind string) (*item, interface{}) {
+	it := s.lookup(index, key)
+	if it != nil && it.kind != kind {
+		return nil, errWrongType
+	}
+	return it, nil
+}
+
+// create returns the item of key, creating it with kind if it does not exist.
+func (s *Server) create(index int, key, kind string) (*item, interface{}) {
+	it, errReply := s.lookupKind(index, key, kind)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if it == nil {
+		it = &item{kind: kind}
+		switch kind {
+		case typeHash:
+			it.hash = map[string]string{}
+		case typeSet:
+			it.set = map[string]struct{}{}
+		case typeZSet:
+			it.zset = map[string]float64{}
+		}
+		s.db(index).items[key] = it
+	}
+	return it, nil
+}
+
+func (s *Server) remove(index int, key string) bool {
+	if s.lookup(index, key) == nil {
+		return false
+	}
+	delete(s.db(index).items, key)
+	s.touch(index, key)
+	return true
+}
+
+// removeIfEmpty drops containers left without elements, as redis does.
+func (s *Server) removeIfEmpty(index int, key string, it *item) {
+	empty := false
+	switch it.kind {
+	case typeHash:
+		empty = len(it.hash) == 0
+	case typeList:
+		empty = len(it.list) == 0
+	case typeSet:
+		empty = len(it.set) == 0
+	case typeZSet:
+		empty = len(it.zset) == 0
+	}
+	if empty {
+		delete(s.db(index).items, key)
+	}
+}
+
+// expireAll drops every key whose ttl elapsed.
+func (s *Server) expireAll() {
+	for index, d := range s.dbs {
+		keys := make([]string, 0, len(d.items))
+		for key := range d.items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s.lookup(index, key)
+		}
+	}
+}
+
+func (s *Server) keys(index int) []string {
+	d := s.db(index)
+	keys := make([]string, 0, len(d.items))
+	for key := range d.items {
+		if s.lookup(index, key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type zmember struct {
+	member string
+	score  float64
+}
+
+// sorted returns the members of a sorted set ordered by score, then member.
+func (it *item) sorted() []zmember {
+	members := make([]zmember, 0, len(it.zset))
+	for m, score := range it.zset {
+		members = append(members, zmember{member: m, score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].score != members[j].score {
+			return members[i].score < members[j].score
+		}
+		return members[i].member < members[j].member
+	})
+	return members
+}
+
+func (it *item) sortedKeys() []string {
+	var keys []string
+	switch it.kind {
+	case typeHash:
+		for k := range it.hash {
+			keys = append(keys, k)
+		}
+	case typeSet:
+		for k := range it.set {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// globMatch matches s against a redis glob-style pattern.
+func globMatch(pattern, s string) bool {
+	return pRedis.MatchPattern(pattern, s)
+}
+
+func parseInt(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+func parseFloat(s string) (float64, bool) {
+	switch s {
+	case "+inf", "inf":
+		return inf, true
+	case "-inf":
+		return -inf, true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
@@ -0,0 +1,100 @@
+package pRedisFake
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// dumpHeader marks the payloads of DUMP, which are not the RDB format of
+// redis, they can only be restored by a fake server.
+const dumpHeader = "pRedisFake\x00"
+
+type dumpedItem struct {
+	Kind   string             `json:"kind"`
+	Str    []byte             `json:"str,omitempty"`
+	Hash   map[string]string  `json:"hash,omitempty"`
+	List   []string           `json:"list,omitempty"`
+	Set    []string           `json:"set,omitempty"`
+	ZSet   map[string]float64 `json:"zset,omitempty"`
+	Stream []dumpedEntry      `json:"stream,omitempty"`
+	HLL    bool               `json:"hll,omitempty"`
+}
+
+type dumpedEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+func cmdDump(s *Server, c *client, args []string) interface{} {
+	it := s.lookup(c.db, args[0])
+	if it == nil {
+		return nil
+	}
+	d := dumpedItem{Kind: it.kind, Str: it.str, Hash: it.hash, List: it.list, ZSet: it.zset, HLL: it.hll}
+	for m := range it.set {
+		d.Set = append(d.Set, m)
+	}
+	for _, e := range it.stream {
+		d.Stream = append(d.Stream, dumpedEntry{ID: e.id.String(), Fields: e.fields})
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return redisError("ERR " + err.Error())
+	}
+	return dumpHeader + string(data)
+}
+
+// cmdRestore supports `RESTORE key ttl payload [REPLACE] [ABSTTL]`.
+func cmdRestore(s *Server, c *client, args []string) interface{} {
+	ttl, ok := parseInt(args[1])
+	if !ok || ttl < 0 {
+		return redisError("ERR Invalid TTL value, must be >= 0")
+	}
+	var replace, absTTL bool
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			return errSyntax
+		}
+	}
+	var d dumpedItem
+	if !strings.HasPrefix(args[2], dumpHeader) || json.Unmarshal([]byte(args[2][len(dumpHeader):]), &d) != nil {
+		return redisError("ERR DUMP payload version or checksum are wrong")
+	}
+	if s.lookup(c.db, args[0]) != nil {
+		if !replace {
+			return redisError("BUSYKEY Target key name already exists.")
+		}
+		s.remove(c.db, args[0])
+	}
+	it := &item{kind: d.Kind, str: d.Str, hash: d.Hash, list: d.List, zset: d.ZSet, hll: d.HLL}
+	if d.Kind == typeSet {
+		it.set = make(map[string]struct{}, len(d.Set))
+		for _, m := range d.Set {
+			it.set[m] = struct{}{}
+		}
+	}
+	for _, e := range d.Stream {
+		id, _ := parseStreamID(e.ID, 0)
+		it.stream = append(it.stream, streamEntry{id: id, fields: e.Fields})
+	}
+	if ttl > 0 {
+		if absTTL {
+			it.expireAt = time.Unix(0, 0).Add(time.Duration(ttl) * time.Millisecond)
+		} else {
+			it.expireAt = s.now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		if !it.expireAt.After(s.now()) {
+			return status("OK")
+		}
+	}
+	s.db(c.db).items[args[0]] = it
+	s.touch(c.db, args[0])
+	s.notify(c.db, 'g', "restore", args[0])
+	return status("OK")
+}
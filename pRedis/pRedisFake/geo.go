@@ -0,0 +1,358 @@
+package pRedisFake
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Geo sets are sorted sets scored by 52 bits geohashes, as in redis.
+const (
+	geoStep      = 26
+	geoLatLimit  = 85.05112878
+	earthRadiusM = 6372797.560856
+)
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+func geoEncode(lon, lat, latLimit float64) uint64 {
+	latOffset := (lat + latLimit) / (2 * latLimit)
+	lonOffset := (lon + 180) / 360
+	latBits := uint64(latOffset * float64(uint64(1)<<geoStep))
+	lonBits := uint64(lonOffset * float64(uint64(1)<<geoStep))
+	var hash uint64
+	for i := geoStep - 1; i >= 0; i-- {
+		hash = hash<<2 | (lonBits>>uint(i)&1)<<1 | latBits>>uint(i)&1
+	}
+	return hash
+}
+
+// geoDecode returns the center of the cell of hash.
+func geoDecode(hash uint64) (lon, lat float64) {
+	var latBits, lonBits uint64
+	for i := geoStep - 1; i >= 0; i-- {
+		lonBits = lonBits<<1 | hash>>uint(2*i+1)&1
+		latBits = latBits<<1 | hash>>uint(2*i)&1
+	}
+	cell := float64(uint64(1) << geoStep)
+	lonMin, lonMax := -180+360*float64(lonBits)/cell, -180+360*float64(lonBits+1)/cell
+	latMin := -geoLatLimit + 2*geoLatLimit*float64(latBits)/cell
+	latMax := -geoLatLimit + 2*geoLatLimit*float64(latBits+1)/cell
+	lon, lat = (lonMin+lonMax)/2, (latMin+latMax)/2
+	return math.Max(-180, math.Min(180, lon)), math.Max(-geoLatLimit, math.Min(geoLatLimit, lat))
+}
+
+// geoHashString is the standard 11 characters geohash, the last one is
+// always built with zero bits like redis does.
+func geoHashString(score float64) string {
+	lon, lat := geoDecode(uint64(score))
+	hash := geoEncode(lon, lat, 90)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		if shift := 52 - (i+1)*5; shift >= 0 {
+			idx = int(hash >> uint(shift) & 0x1f)
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := math.Pi / 180
+	u := math.Sin((lat2 - lat1) * rad / 2)
+	v := math.Sin((lon2 - lon1) * rad / 2)
+	a := u*u + math.Cos(lat1*rad)*math.Cos(lat2*rad)*v*v
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(a))
+}
+
+func geoUnit(unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "mi":
+		return 1609.34, true
+	case "ft":
+		return 0.3048, true
+	}
+	return 0, false
+}
+
+func formatDistance(d float64) string {
+	return strconv.FormatFloat(d, 'f', 4, 64)
+}
+
+func cmdGeoAdd(s *Server, c *client, args []string) interface{} {
+	zadd := []string{args[0]}
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX", "XX", "CH":
+			zadd = append(zadd, args[i])
+		default:
+			break flags
+		}
+	}
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return errSyntax
+	}
+	for j := 0; j < len(triples); j += 3 {
+		lon, ok1 := parseFloat(triples[j])
+		lat, ok2 := parseFloat(triples[j+1])
+		if !ok1 || !ok2 {
+			return errNotFloat
+		}
+		if lon < -180 || lon > 180 || lat < -geoLatLimit || lat > geoLatLimit {
+			return redisError("ERR invalid longitude,latitude pair " + triples[j] + "," + triples[j+1])
+		}
+		zadd = append(zadd, strconv.FormatUint(geoEncode(lon, lat, geoLatLimit), 10), triples[j+2])
+	}
+	return cmdZAdd(s, c, zadd)
+}
+
+func cmdGeoPos(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+	if errReply != nil {
+		return errReply
+	}
+	reply := make([]interface{}, 0, len(args)-1)
+	for _, m := range args[1:] {
+		score, ok := 0.0, false
+		if it != nil {
+			score, ok = it.zset[m]
+		}
+		if !ok {
+			reply = append(reply, nilArray{})
+			continue
+		}
+		lon, lat := geoDecode(uint64(score))
+		reply = append(reply, []interface{}{formatFloat(lon), formatFloat(lat)})
+	}
+	return reply
+}
+
+func cmdGeoHash(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+	if errReply != nil {
+		return errReply
+	}
+	reply := make([]interface{}, 0, len(args)-1)
+	for _, m := range args[1:] {
+		score, ok := 0.0, false
+		if it != nil {
+			score, ok = it.zset[m]
+		}
+		if !ok {
+			reply = append(reply, nil)
+			continue
+		}
+		reply = append(reply, geoHashString(score))
+	}
+	return reply
+}
+
+func cmdGeoDist(s *Server, c *client, args []string) interface{} {
+	unit := 1.0
+	if len(args) > 4 {
+		return errSyntax
+	}
+	if len(args) == 4 {
+		var ok bool
+		if unit, ok = geoUnit(args[3]); !ok {
+			return redisError("ERR unsupported unit provided. please use M, KM, FT, MI")
+		}
+	}
+	it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+	if errReply != nil || it == nil {
+		return errReply
+	}
+	a, ok1 := it.zset[args[1]]
+	b, ok2 := it.zset[args[2]]
+	if !ok1 || !ok2 {
+		return nil
+	}
+	lon1, lat1 := geoDecode(uint64(a))
+	lon2, lat2 := geoDecode(uint64(b))
+	return formatDistance(geoDistance(lon1, lat1, lon2, lat2) / unit)
+}
+
+type geoMatch struct {
+	member   string
+	score    float64
+	lon, lat float64
+	dist     float64
+}
+
+// cmdGeoSearch supports FROMMEMBER, FROMLONLAT, BYRADIUS, BYBOX, ASC, DESC,
+// COUNT [ANY], WITHCOORD, WITHDIST and WITHHASH.
+func cmdGeoSearch(s *Server, c *client, args []string) interface{} {
+	var (
+		fromMember, hasCenter, byRadius, byBox bool
+		member                                 string
+		lon, lat, radius, width, height        float64
+		unit                                   = 1.0
+		order                                  int
+		count                                  int64
+		withCoord, withDist, withHash          bool
+	)
+	readUnit := func(s string) bool {
+		var ok bool
+		unit, ok = geoUnit(s)
+		return ok
+	}
+	for i := 1; i < len(args); i++ {
+		left := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "FROMMEMBER":
+			if left < 1 {
+				return errSyntax
+			}
+			fromMember, member = true, args[i+1]
+			i++
+		case "FROMLONLAT":
+			var ok1, ok2 bool
+			if left < 2 {
+				return errSyntax
+			}
+			lon, ok1 = parseFloat(args[i+1])
+			lat, ok2 = parseFloat(args[i+2])
+			if !ok1 || !ok2 {
+				return errNotFloat
+			}
+			hasCenter = true
+			i += 2
+		case "BYRADIUS":
+			var ok bool
+			if left < 2 {
+				return errSyntax
+			}
+			if radius, ok = parseFloat(args[i+1]); !ok || radius < 0 {
+				return redisError("ERR radius cannot be negative")
+			}
+			if !readUnit(args[i+2]) {
+				return redisError("ERR unsupported unit provided. please use M, KM, FT, MI")
+			}
+			byRadius = true
+			i += 2
+		case "BYBOX":
+			var ok1, ok2 bool
+			if left < 3 {
+				return errSyntax
+			}
+			width, ok1 = parseFloat(args[i+1])
+			height, ok2 = parseFloat(args[i+2])
+			if !ok1 || !ok2 || width < 0 || height < 0 {
+				return redisError("ERR height or width cannot be negative")
+			}
+			if !readUnit(args[i+3]) {
+				return redisError("ERR unsupported unit provided. please use M, KM, FT, MI")
+			}
+			byBox = true
+			i += 3
+		case "ASC":
+			order = 1
+		case "DESC":
+			order = -1
+		case "COUNT":
+			var ok bool
+			if left < 1 {
+				return errSyntax
+			}
+			if count, ok = parseInt(args[i+1]); !ok || count <= 0 {
+				return redisError("ERR COUNT must be > 0")
+			}
+			i++
+			if i+1 < len(args) && strings.ToUpper(args[i+1]) == "ANY" {
+				i++
+			}
+		case "WITHCOORD":
+			withCoord = true
+		case "WITHDIST":
+			withDist = true
+		case "WITHHASH":
+			withHash = true
+		default:
+			return errSyntax
+		}
+	}
+	if fromMember == hasCenter {
+		return redisError("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if byRadius == byBox {
+		return redisError("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+
+	it, errReply := s.lookupKind(c.db, args[0], typeZSet)
+	if errReply != nil {
+		return errReply
+	}
+	if fromMember {
+		score, ok := 0.0, false
+		if it != nil {
+			score, ok = it.zset[member]
+		}
+		if !ok {
+			return redisError("ERR could not decode requested zset member")
+		}
+		lon, lat = geoDecode(uint64(score))
+	}
+	if it == nil {
+		return []interface{}{}
+	}
+
+	var matches []geoMatch
+	for m, score := range it.zset {
+		mlon, mlat := geoDecode(uint64(score))
+		dist := geoDistance(lon, lat, mlon, mlat)
+		if byRadius && dist > radius*unit {
+			continue
+		}
+		if byBox {
+			if math.Abs(mlat-lat)*math.Pi/180*earthRadiusM > height*unit/2 {
+				continue
+			}
+			if geoDistance(lon, mlat, mlon, mlat) > width*unit/2 {
+				continue
+			}
+		}
+		matches = append(matches, geoMatch{member: m, score: score, lon: mlon, lat: mlat, dist: dist})
+	}
+	if order == 0 && count > 0 {
+		order = 1
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.dist != b.dist && order != 0 {
+			return (a.dist < b.dist) == (order > 0)
+		}
+		return a.member < b.member
+	})
+	if count > 0 && int64(len(matches)) > count {
+		matches = matches[:count]
+	}
+
+	reply := make([]interface{}, 0, len(matches))
+	for _, m := range matches {
+		if !withCoord && !withDist && !withHash {
+			reply = append(reply, m.member)
+			continue
+		}
+		item := []interface{}{m.member}
+		if withDist {
+			item = append(item, formatDistance(m.dist/unit))
+		}
+		if withHash {
+			item = append(item, int64(m.score))
+		}
+		if withCoord {
+			item = append(item, []interface{}{formatFloat(m.lon), formatFloat(m.lat)})
+		}
+		reply = append(reply, item)
+	}
+	return reply
+}
@@ -0,0 +1,88 @@
+package pRedisFake
+
+import (
+	"strconv"
+	"strings"
+)
+
+func cmdSubscribe(pattern bool) func(s *Server, c *client, args []string) interface{} {
+	kind, subs := "subscribe", func(c *client) map[string]struct{} { return c.channels }
+	if pattern {
+		kind, subs = "psubscribe", func(c *client) map[string]struct{} { return c.patterns }
+	}
+	return func(s *Server, c *client, args []string) interface{} {
+		for _, name := range args {
+			subs(c)[name] = struct{}{}
+			c.write([]interface{}{kind, name, int64(len(c.channels) + len(c.patterns))})
+		}
+		return noReply{}
+	}
+}
+
+func cmdUnsubscribe(pattern bool) func(s *Server, c *client, args []string) interface{} {
+	kind, subs := "unsubscribe", func(c *client) map[string]struct{} { return c.channels }
+	if pattern {
+		kind, subs = "punsubscribe", func(c *client) map[string]struct{} { return c.patterns }
+	}
+	return func(s *Server, c *client, args []string) interface{} {
+		names := args
+		if len(names) == 0 {
+			names = sortedSet(subs(c))
+		}
+		if len(names) == 0 {
+			c.write([]interface{}{kind, nil, int64(len(c.channels) + len(c.patterns))})
+			return noReply{}
+		}
+		for _, name := range names {
+			delete(subs(c), name)
+			c.write([]interface{}{kind, name, int64(len(c.channels) + len(c.patterns))})
+		}
+		return noReply{}
+	}
+}
+
+func cmdPublish(s *Server, c *client, args []string) interface{} {
+	return s.publish(args[0], args[1])
+}
+
+// publish delivers message to the subscribers of channel, s.mu must be held.
+func (s *Server) publish(channel, message string) int64 {
+	var receivers int64
+	for sub := range s.clients {
+		if _, ok := sub.channels[channel]; ok {
+			sub.write([]interface{}{"message", channel, message})
+			receivers++
+		}
+		for _, pattern := range sortedSet(sub.patterns) {
+			if globMatch(pattern, channel) {
+				sub.write([]interface{}{"pmessage", pattern, channel, message})
+				receivers++
+			}
+		}
+	}
+	return receivers
+}
+
+// notify publishes a keyspace notification if enabled by the
+// notify-keyspace-events config, class is the flag of the event type as in
+// redis.conf, e.g. 'g' for generic commands and 'x' for expired events.
+func (s *Server) notify(index int, class byte, event, key string) {
+	flags := s.config["notify-keyspace-events"]
+	if flags == "" {
+		return
+	}
+	enabled := strings.IndexByte(flags, class) >= 0
+	if !enabled && strings.IndexByte(flags, 'A') >= 0 {
+		enabled = strings.IndexByte("g$lshzxe", class) >= 0
+	}
+	if !enabled {
+		return
+	}
+	db := strconv.Itoa(index)
+	if strings.IndexByte(flags, 'K') >= 0 {
+		s.publish("__keyspace@"+db+"__:"+key, event)
+	}
+	if strings.IndexByte(flags, 'E') >= 0 {
+		s.publish("__keyevent@"+db+"__:"+event, key)
+	}
+}
@@ -0,0 +1,297 @@
+package pRedisFake
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ScriptFunc emulates a Lua script. call runs a command like `redis.call`,
+// replies are strings, int64, []interface{} or nil. The returned value is
+// sent to the client, an error fails the script. Scripts run atomically.
+type ScriptFunc func(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error)
+
+// RegisterScript makes src runnable by `EVAL` and `EVALSHA`, emulated by fn.
+func (s *Server) RegisterScript(src string, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emulate[scriptHash(src)] = fn
+}
+
+func scriptHash(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+type builtinScript struct {
+	hash string
+	fn   ScriptFunc
+}
+
+// builtinScripts emulate the scripts registered by pRedis by name. Each one is
+// pinned to the sha1 of the Lua source it was written after, so a script whose
+// Lua changed fails to run until its emulation is updated, instead of running
+// an outdated one. TestBuiltinScripts lists the scripts out of sync.
+var builtinScripts = map[string]builtinScript{
+	"pRedis:idempotency:abort":    {"af5a1cfaf3ec9fbf6e5d14859dfb894d44138178", idempotencyAbort},
+	"pRedis:idempotency:begin":    {"7e534cea55579f01a77ed0a46452491ffdff6682", idempotencyBegin},
+	"pRedis:idempotency:complete": {"6dc58be23e9f905732e4b875bbdfe672c3d9b1fd", idempotencyComplete},
+	"pRedis:leaderboard:incr":     {"96ce2681dc1559a5ee714fc991dbf90c121bcab0", leaderboardIncr},
+	"pRedis:lock:acquire":         {"aed6cf0072d6ec0afe5c81e18f5c56cd9a3f7261", lockAcquire},
+	"pRedis:lock:release":         {"4130e6fa85cd247cdb50bd65e8e82d96e47cacd1", lockIfHeld("DEL")},
+	"pRedis:lock:refresh":         {"9c3b293525412cd36bd121057285fdd4fada0e1a", lockIfHeld("EXPIRE")},
+	"pRedis:scheduler:start":      {"48355660ed11435e0d14918602891f88644f9191", schedulerStart},
+	"pRedis:scheduler:finish":     {"bc8be1903337bafbf0999045cc3a7567492073d3", schedulerFinish},
+}
+
+// registerBuiltinScripts emulates the scripts registered by pRedis.
+func registerBuiltinScripts(s *Server) {
+	for _, script := range builtinScripts {
+		s.emulate[script.hash] = script.fn
+	}
+}
+
+func idempotencyBegin(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+	state, err := call("HGET", keys[0], "state")
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		if _, err = call("HMSET", keys[0], "state", args[0], "token", args[2]); err != nil {
+			return nil, err
+		}
+		if _, err = call("PEXPIRE", keys[0], args[1]); err != nil {
+			return nil, err
+		}
+		return []interface{}{}, nil
+	}
+	result, err := call("HGET", keys[0], "result")
+	if err != nil {
+		return nil, err
+	}
+	// HGET of a missing field is false in Lua, which ends up as a nil reply
+	// rather than ending the table like a Lua nil does
+	return []interface{}{state, result}, nil
+}
+
+func idempotencyComplete(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+	token, err := call("HGET", keys[0], "token")
+	if err != nil {
+		return nil, err
+	}
+	if token != args[0] {
+		return int64(0), nil
+	}
+	if _, err = call("HMSET", keys[0], "state", args[1], "result", args[2]); err != nil {
+		return nil, err
+	}
+	if _, err = call("PEXPIRE", keys[0], args[3]); err != nil {
+		return nil, err
+	}
+	return int64(1), nil
+}
+
+func idempotencyAbort(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+	values, err := call("HMGET", keys[0], "token", "state")
+	if err != nil {
+		return nil, err
+	}
+	if fields := values.([]interface{}); fields[0] != args[0] || fields[1] != args[1] {
+		return int64(0), nil
+	}
+	return call("DEL", keys[0])
+}
+
+func leaderboardIncr(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+	old, err := call("ZSCORE", keys[0], args[0])
+	if err != nil {
+		return nil, err
+	}
+	score := 0.0
+	if old != nil {
+		f, _ := strconv.ParseFloat(old.(string), 64)
+		score = math.Floor(f)
+	}
+	delta, _ := strconv.ParseFloat(args[1], 64)
+	fraction, _ := strconv.ParseFloat(args[2], 64)
+	score += delta
+	if _, err = call("ZADD", keys[0], formatFloat(score+fraction), args[0]); err != nil {
+		return nil, err
+	}
+	return formatFloat(score), nil
+}
+
+func lockAcquire(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+	exists, err := call("EXISTS", keys[0])
+	if err != nil {
+		return nil, err
+	}
+	if exists.(int64) == 1 {
+		return nil, nil
+	}
+	token, err := call("INCR", keys[1])
+	if err != nil {
+		return nil, err
+	}
+	if _, err = call("SET", keys[0], strconv.FormatInt(token.(int64), 10), "EX", args[0]); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// lockIfHeld runs cmd on the lock if it holds the token, with the arguments
+// following the token.
+func lockIfHeld(cmd string) ScriptFunc {
+	return func(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+		holder, err := call("GET", keys[0])
+		if err != nil {
+			return nil, err
+		}
+		if holder != args[0] {
+			return int64(0), nil
+		}
+		return call(cmd, append([]string{keys[0]}, args[1:]...)...)
+	}
+}
+
+func schedulerStart(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+	values, err := call("HMGET", keys[0], "token", "last_scheduled")
+	if err != nil {
+		return nil, err
+	}
+	for i, field := range values.([]interface{}) {
+		last := 0.0
+		if field != nil {
+			last, _ = strconv.ParseFloat(field.(string), 64)
+		}
+		if next, _ := strconv.ParseFloat(args[i], 64); next <= last {
+			return int64(0), nil
+		}
+	}
+	_, err = call("HMSET", keys[0], "token", args[0], "last_scheduled", args[1], "last_started", args[2],
+		"runner", args[3], "status", "running", "missed", args[4], "next_run", args[5], "error", "")
+	if err != nil {
+		return nil, err
+	}
+	return int64(1), nil
+}
+
+func schedulerFinish(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+	token, err := call("HGET", keys[0], "token")
+	if err != nil {
+		return nil, err
+	}
+	if token != args[0] {
+		return int64(0), nil
+	}
+	_, err = call("HMSET", keys[0], "status", args[1], "error", args[2], "last_finished", args[3], "last_duration", args[4])
+	if err != nil {
+		return nil, err
+	}
+	return int64(1), nil
+}
+
+func cmdEval(s *Server, c *client, args []string) interface{} {
+	sha := scriptHash(args[0])
+	fn, ok := s.emulate[sha]
+	if !ok {
+		return redisError("ERR fake redis cannot run this script, see Server.RegisterScript")
+	}
+	s.scripts[sha] = args[0]
+	return s.runScript(c, fn, args[1:])
+}
+
+func cmdEvalSHA(s *Server, c *client, args []string) interface{} {
+	sha := strings.ToLower(args[0])
+	if _, ok := s.scripts[sha]; !ok {
+		return redisError("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	fn, ok := s.emulate[sha]
+	if !ok {
+		return redisError("ERR fake redis cannot run this script, see Server.RegisterScript")
+	}
+	return s.runScript(c, fn, args[1:])
+}
+
+func (s *Server) runScript(c *client, fn ScriptFunc, args []string) interface{} {
+	numKeys, ok := parseInt(args[0])
+	if !ok || numKeys < 0 {
+		return redisError("ERR value is not an integer or out of range")
+	}
+	if int(numKeys) > len(args)-1 {
+		return redisError("ERR Number of keys can't be greater than number of args")
+	}
+	keys, argv := args[1:1+numKeys], args[1+numKeys:]
+	// commands run by the script must not be queued by a transaction
+	caller := &client{id: c.id, db: c.db, watched: map[watchKey]uint64{}}
+	call := func(cmd string, args ...string) (interface{}, error) {
+		reply := s.run(caller, append([]string{cmd}, args...))
+		if e, ok := reply.(redisError); ok {
+			return nil, errors.New(string(e))
+		}
+		return scriptReply(reply), nil
+	}
+	reply, err := fn(call, keys, argv)
+	if err != nil {
+		return redisError("ERR Error running script: " + err.Error())
+	}
+	return reply
+}
+
+// scriptReply converts a reply to what a script sees on the wire, e.g. scores
+// become strings.
+func scriptReply(reply interface{}) interface{} {
+	switch v := reply.(type) {
+	case status:
+		return string(v)
+	case nilArray:
+		return nil
+	case int:
+		return int64(v)
+	case bool:
+		return boolReply(v)
+	case float64:
+		return formatFloat(v)
+	case []byte:
+		return string(v)
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = e
+		}
+		return values
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = scriptReply(e)
+		}
+		return values
+	}
+	return reply
+}
+
+func cmdScript(s *Server, c *client, args []string) interface{} {
+	switch strings.ToUpper(args[0]) {
+	case "LOAD":
+		if len(args) < 2 {
+			return errWrongArgs("script|load")
+		}
+		sha := scriptHash(args[1])
+		s.scripts[sha] = args[1]
+		return sha
+	case "EXISTS":
+		reply := make([]interface{}, 0, len(args)-1)
+		for _, sha := range args[1:] {
+			_, ok := s.scripts[strings.ToLower(sha)]
+			reply = append(reply, boolReply(ok))
+		}
+		return reply
+	case "FLUSH":
+		s.scripts = map[string]string{}
+		return status("OK")
+	}
+	return errSyntax
+}
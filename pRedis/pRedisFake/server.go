@@ -0,0 +1,474 @@
+package pRedisFake
+
+import (
+	"bufio"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/zzj-custom/pkg/pRedis"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Usage:
+// Start a fake server in tests and register it as a named pool, code under test
+// keeps calling pRedis.Pool(name), Lock and Subscription as in production.
+//
+// Example:
+// srv, _ := pRedisFake.NewServer()
+// defer srv.Close()
+// pool, _ := srv.InitPool("default")
+// ...
+// srv.Advance(time.Minute) // let keys with a ttl of one minute expire
+
+// Server
+//
+// An in-process redis speaking RESP over TCP. It implements the string, hash,
+// list, set, sorted set, geo, hyperloglog, stream, pub/sub, transaction and
+// expiry commands used by pRedis, and client tracking. Lua is not interpreted,
+// scripts are emulated by Go functions, those of pRedis are built in, others
+// can be added by RegisterScript. Tests against the fake therefore never run
+// the Lua of pRedis, only its emulation, which is pinned to the hash of the
+// Lua it was written after: a changed script fails until its emulation is
+// updated. The Lua itself is covered by TestBuiltinScriptsOnRedis of pRedis,
+// built with the integration tag, which runs on a real redis.
+type Server struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	dbs     map[int]*db
+	offset  time.Duration
+	version uint64
+	config  map[string]string
+	scripts map[string]string
+	emulate map[string]ScriptFunc
+	clients map[*client]struct{}
+	tracked map[string]map[*client]struct{}
+	nextID  int64
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a server listening on a random local port.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "listen failed")
+	}
+	s := &Server{
+		ln:      ln,
+		dbs:     map[int]*db{},
+		config:  map[string]string{"notify-keyspace-events": ""},
+		scripts: map[string]string{},
+		emulate: map[string]ScriptFunc{},
+		clients: map[*client]struct{}{},
+		tracked: map[string]map[*client]struct{}{},
+	}
+	registerBuiltinScripts(s)
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// DialConfig returns a config dialing this server.
+func (s *Server) DialConfig() *pRedis.DialConfig {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return &pRedis.DialConfig{
+		Host:      addr.IP.String(),
+		Port:      addr.Port,
+		MaxIdle:   8,
+		MaxActive: 64,
+		Wait:      true,
+	}
+}
+
+// NewPool creates a pool dialing this server.
+func (s *Server) NewPool() (*redis.Pool, error) {
+	return pRedis.NewPool(s.DialConfig())
+}
+
+// InitPool creates a pool dialing this server and registers it with name, see
+// pRedis.InitPool.
+func (s *Server) InitPool(name string) (*redis.Pool, error) {
+	return pRedis.InitPool(name, s.DialConfig())
+}
+
+// Now returns the time of the server clock.
+func (s *Server) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now()
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+// Advance moves the server clock forward by d, keys whose ttl elapsed expire
+// at once.
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+	s.expireAll()
+}
+
+// SetNow moves the server clock to t, keys whose ttl elapsed expire at once.
+func (s *Server) SetNow(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = time.Until(t)
+	s.expireAll()
+}
+
+// FlushAll drops every key of every database.
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dbs = map[int]*db{}
+}
+
+// Close stops the server and drops every connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	err := s.ln.Close()
+	for _, c := range clients {
+		_ = c.conn.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.nextID++
+		c := newClient(s.nextID, conn)
+		s.clients[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(c)
+		}()
+	}
+}
+
+func (s *Server) handle(c *client) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.untrack(c)
+		s.mu.Unlock()
+		_ = c.conn.Close()
+	}()
+	r := bufio.NewReader(c.conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if err != io.EOF {
+				c.write(redisError("ERR protocol error: " + err.Error()))
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if strings.EqualFold(args[0], "QUIT") {
+			c.write(status("OK"))
+			return
+		}
+		if reply := s.dispatch(c, args); reply != (noReply{}) {
+			c.write(reply)
+		}
+	}
+}
+
+// dispatch runs a command sent by a client, taking care of transactions and
+// pub/sub mode.
+func (s *Server) dispatch(c *client, args []string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.ToUpper(args[0])
+	if c.subscribed() {
+		switch name {
+		case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PING":
+		default:
+			return redisError("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+		}
+	}
+	if c.multi {
+		switch name {
+		case "EXEC":
+			return s.exec(c)
+		case "DISCARD":
+			c.resetMulti()
+			return status("OK")
+		case "MULTI":
+			return redisError("ERR MULTI calls can not be nested")
+		case "WATCH":
+			return redisError("ERR WATCH inside MULTI is not allowed")
+		}
+		if errReply := s.check(name, args); errReply != nil {
+			c.dirty = true
+			return errReply
+		}
+		c.queued = append(c.queued, args)
+		return status("QUEUED")
+	}
+	switch name {
+	case "MULTI":
+		c.multi = true
+		return status("OK")
+	case "EXEC":
+		return redisError("ERR EXEC without MULTI")
+	case "DISCARD":
+		return redisError("ERR DISCARD without MULTI")
+	}
+	return s.run(c, args)
+}
+
+func (s *Server) check(name string, args []string) interface{} {
+	cmd, ok := commands[name]
+	if !ok {
+		return redisError("ERR unknown command '" + args[0] + "'")
+	}
+	if len(args)-1 < cmd.minArgs {
+		return errWrongArgs(args[0])
+	}
+	return nil
+}
+
+// run executes a single command, s.mu must be held.
+func (s *Server) run(c *client, args []string) interface{} {
+	name := strings.ToUpper(args[0])
+	if errReply := s.check(name, args); errReply != nil {
+		return errReply
+	}
+	reply := commands[name].fn(s, c, args[1:])
+	s.track(c, args)
+	return reply
+}
+
+func (s *Server) exec(c *client) interface{} {
+	defer c.resetMulti()
+	if c.dirty {
+		return redisError("EXECABORT Transaction discarded because of previous errors.")
+	}
+	if !s.watchedUnchanged(c) {
+		return nilArray{}
+	}
+	replies := make([]interface{}, len(c.queued))
+	for i, args := range c.queued {
+		replies[i] = s.run(c, args)
+	}
+	return replies
+}
+
+func (s *Server) watchedUnchanged(c *client) bool {
+	for w, version := range c.watched {
+		if s.db(w.db).versions[w.key] != version {
+			return false
+		}
+	}
+	return true
+}
+
+type watchKey struct {
+	db  int
+	key string
+}
+
+type client struct {
+	id   int64
+	conn net.Conn
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	db       int
+	multi    bool
+	dirty    bool
+	queued   [][]string
+	watched  map[watchKey]uint64
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	tracking bool
+	redirect int64
+	bcast    bool
+	prefixes []string
+}
+
+func newClient(id int64, conn net.Conn) *client {
+	return &client{
+		id:       id,
+		conn:     conn,
+		w:        bufio.NewWriter(conn),
+		watched:  map[watchKey]uint64{},
+		channels: map[string]struct{}{},
+		patterns: map[string]struct{}{},
+	}
+}
+
+func (c *client) resetMulti() {
+	c.multi = false
+	c.dirty = false
+	c.queued = nil
+	c.watched = map[watchKey]uint64{}
+}
+
+func (c *client) subscribed() bool {
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+func (c *client) write(reply interface{}) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	writeReply(c.w, reply)
+	_ = c.w.Flush()
+}
+
+// replies that need a specific RESP encoding
+type (
+	status     string
+	redisError string
+	nilArray   struct{}
+)
+
+var (
+	errWrongType = redisError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInt    = redisError("ERR value is not an integer or out of range")
+	errNotFloat  = redisError("ERR value is not a valid float")
+	errSyntax    = redisError("ERR syntax error")
+)
+
+func errWrongArgs(cmd string) redisError {
+	return redisError("ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command")
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		// inline command, as sent by telnet
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, errors.Errorf("invalid multibulk length")
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errors.Errorf("expected '$', got '%s'", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errors.Errorf("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		_, _ = w.WriteString("$-1\r\n")
+	case nilArray:
+		_, _ = w.WriteString("*-1\r\n")
+	case status:
+		_, _ = w.WriteString("+" + string(v) + "\r\n")
+	case redisError:
+		_, _ = w.WriteString("-" + string(v) + "\r\n")
+	case int:
+		_, _ = w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case int64:
+		_, _ = w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case bool:
+		if v {
+			_, _ = w.WriteString(":1\r\n")
+		} else {
+			_, _ = w.WriteString(":0\r\n")
+		}
+	case float64:
+		writeBulk(w, formatFloat(v))
+	case string:
+		writeBulk(w, v)
+	case []byte:
+		writeBulk(w, string(v))
+	case []string:
+		_, _ = w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, s := range v {
+			writeBulk(w, s)
+		}
+	case []interface{}:
+		_, _ = w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	default:
+		_, _ = w.WriteString("-ERR fake redis cannot encode reply\r\n")
+	}
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	_, _ = w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,218 @@
+package pRedisFake
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestConn(t *testing.T) (*Server, redis.Conn) {
+	t.Helper()
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := srv.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := pool.Get()
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = pool.Close()
+		_ = srv.Close()
+	})
+	return srv, conn
+}
+
+func TestStringsAndExpiry(t *testing.T) {
+	srv, conn := newTestConn(t)
+
+	if _, err := conn.Do("SET", "k", "v", "EX", 10); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := redis.String(conn.Do("SET", "k", "w", "NX")); ok == "OK" {
+		t.Fatal("SET NX overwrote an existing key")
+	}
+	if v, err := redis.String(conn.Do("GET", "k")); err != nil || v != "v" {
+		t.Fatalf("GET = %q, %v", v, err)
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", "k")); ttl <= 0 || ttl > 10 {
+		t.Fatalf("TTL = %d", ttl)
+	}
+	if n, _ := redis.Int(conn.Do("INCRBY", "n", 5)); n != 5 {
+		t.Fatalf("INCRBY = %d", n)
+	}
+
+	srv.Advance(11 * time.Second)
+	if _, err := redis.String(conn.Do("GET", "k")); err != redis.ErrNil {
+		t.Fatalf("GET after expiry = %v, want ErrNil", err)
+	}
+	if n, _ := redis.Int(conn.Do("GET", "n")); n != 5 {
+		t.Fatalf("key without ttl expired, GET = %d", n)
+	}
+}
+
+func TestCollections(t *testing.T) {
+	_, conn := newTestConn(t)
+
+	if _, err := conn.Do("HSET", "h", "a", "1", "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	h, err := redis.StringMap(conn.Do("HGETALL", "h"))
+	if err != nil || !reflect.DeepEqual(h, map[string]string{"a": "1", "b": "2"}) {
+		t.Fatalf("HGETALL = %v, %v", h, err)
+	}
+
+	if _, err = conn.Do("RPUSH", "l", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	l, err := redis.Strings(conn.Do("LRANGE", "l", 0, -1))
+	if err != nil || !reflect.DeepEqual(l, []string{"a", "b", "c"}) {
+		t.Fatalf("LRANGE = %v, %v", l, err)
+	}
+
+	if _, err = conn.Do("ZADD", "z", 2, "b", 1, "a", 3, "c"); err != nil {
+		t.Fatal(err)
+	}
+	z, err := redis.Strings(conn.Do("ZREVRANGE", "z", 0, 1))
+	if err != nil || !reflect.DeepEqual(z, []string{"c", "b"}) {
+		t.Fatalf("ZREVRANGE = %v, %v", z, err)
+	}
+
+	for _, id := range []string{"1-1", "*"} {
+		if _, err = conn.Do("XADD", "x", id, "f", "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = conn.Do("XADD", "x", "1-1", "f", "v"); err == nil {
+		t.Fatal("XADD of an id below the top item succeeded")
+	}
+	if n, err := redis.Int(conn.Do("XLEN", "x")); err != nil || n != 2 {
+		t.Fatalf("XLEN = %d, %v", n, err)
+	}
+	x, err := redis.Values(conn.Do("XRANGE", "x", "-", "+", "COUNT", 1))
+	if err != nil || len(x) != 1 || !reflect.DeepEqual(x[0], []interface{}{[]byte("1-1"), []interface{}{[]byte("f"), []byte("v")}}) {
+		t.Fatalf("XRANGE = %v, %v", x, err)
+	}
+
+	if _, err = conn.Do("HGET", "l", "a"); err == nil {
+		t.Fatal("HGET on a list succeeded, want WRONGTYPE")
+	}
+}
+
+func TestTransactionWatch(t *testing.T) {
+	srv, conn := newTestConn(t)
+	pool, err := srv.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	other := pool.Get()
+	defer other.Close()
+
+	if _, err = conn.Do("WATCH", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Do("SET", "k", "other"); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Send("MULTI")
+	_ = conn.Send("SET", "k", "mine")
+	reply, err := conn.Do("EXEC")
+	if err != nil || reply != nil {
+		t.Fatalf("EXEC after a watched write = %v, %v, want a nil reply", reply, err)
+	}
+	if v, _ := redis.String(conn.Do("GET", "k")); v != "other" {
+		t.Fatalf("GET = %q, the aborted transaction was applied", v)
+	}
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("INCR", "n")
+	_ = conn.Send("INCR", "n")
+	values, err := redis.Int64s(conn.Do("EXEC"))
+	if err != nil || !reflect.DeepEqual(values, []int64{1, 2}) {
+		t.Fatalf("EXEC = %v, %v", values, err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	srv, conn := newTestConn(t)
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.PSubscribe("news.*"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := psc.Receive().(redis.Subscription); !ok {
+		t.Fatal("no subscription confirmation")
+	}
+
+	pool, err := srv.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pub := pool.Get()
+	defer pub.Close()
+	if n, err := redis.Int(pub.Do("PUBLISH", "news.sport", "goal")); err != nil || n != 1 {
+		t.Fatalf("PUBLISH = %d, %v", n, err)
+	}
+
+	switch msg := psc.Receive().(type) {
+	case redis.Message:
+		if msg.Pattern != "news.*" || msg.Channel != "news.sport" || string(msg.Data) != "goal" {
+			t.Fatalf("message = %+v", msg)
+		}
+	default:
+		t.Fatalf("received %#v, want a message", msg)
+	}
+}
+
+func TestRegisterScript(t *testing.T) {
+	srv, conn := newTestConn(t)
+	src := "return redis.call('INCRBY', KEYS[1], ARGV[1])"
+	srv.RegisterScript(src, func(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+		return call("INCRBY", keys[0], args[0])
+	})
+
+	script := redis.NewScript(1, src)
+	if n, err := redis.Int(script.Do(conn, "n", 3)); err != nil || n != 3 {
+		t.Fatalf("script = %d, %v", n, err)
+	}
+	if _, err := conn.Do("EVAL", "return 1", 0); err == nil {
+		t.Fatal("EVAL of an unknown script succeeded")
+	}
+}
+
+func TestBuiltinScripts(t *testing.T) {
+	for name, builtin := range builtinScripts {
+		script, ok := pRedis.LookupScript(name)
+		if !ok {
+			t.Errorf("%s is emulated but not registered by pRedis", name)
+			continue
+		}
+		if script.Hash() != builtin.hash {
+			t.Errorf("the Lua of %s changed, update its emulation and pin it to %s", name, script.Hash())
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"a/*", "a/b/c", true},
+		{"h?llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+	} {
+		if got := globMatch(c.pattern, c.s); got != c.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", c.pattern, c.s, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package pRedisFake
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Streams only support appending and reading entries back, consumer groups are
+// not implemented.
+
+type streamID struct {
+	ms, seq uint64
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || id.ms == other.ms && id.seq < other.seq
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// parseStreamID parses `ms-seq` or `ms`, whose seq is missingSeq.
+func parseStreamID(s string, missingSeq uint64) (streamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if !hasSeq {
+		return streamID{ms: ms, seq: missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	return streamID{ms: ms, seq: seq}, true
+}
+
+// cmdXAdd supports `XADD key <* | id> field value [field value ...]`.
+func cmdXAdd(s *Server, c *client, args []string) interface{} {
+	if len(args) < 4 || len(args)%2 != 0 {
+		return errWrongArgs("xadd")
+	}
+	it, errReply := s.create(c.db, args[0], typeStream)
+	if errReply != nil {
+		return errReply
+	}
+	var last streamID
+	if n := len(it.stream); n > 0 {
+		last = it.stream[n-1].id
+	}
+	var id streamID
+	if args[1] == "*" {
+		id = streamID{ms: uint64(s.now().UnixMilli())}
+		if !last.less(id) {
+			id = streamID{ms: last.ms, seq: last.seq + 1}
+		}
+	} else {
+		var ok bool
+		if id, ok = parseStreamID(args[1], 0); !ok {
+			return redisError("ERR Invalid stream ID specified as stream command argument")
+		}
+		if !last.less(id) {
+			return redisError("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+	}
+	it.stream = append(it.stream, streamEntry{id: id, fields: append([]string(nil), args[2:]...)})
+	s.touch(c.db, args[0])
+	s.notify(c.db, 't', "xadd", args[0])
+	return id.String()
+}
+
+func cmdXLen(s *Server, c *client, args []string) interface{} {
+	it, errReply := s.lookupKind(c.db, args[0], typeStream)
+	if errReply != nil {
+		return errReply
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.stream))
+}
+
+// cmdXRange supports `XRANGE key start end [COUNT n]`, with - and + for the
+// first and the last entries.
+func cmdXRange(s *Server, c *client, args []string) interface{} {
+	start, ok := streamID{}, true
+	if args[1] != "-" {
+		start, ok = parseStreamID(args[1], 0)
+	}
+	end, endOK := streamID{ms: ^uint64(0), seq: ^uint64(0)}, true
+	if args[2] != "+" {
+		end, endOK = parseStreamID(args[2], ^uint64(0))
+	}
+	if !ok || !endOK {
+		return redisError("ERR Invalid stream ID specified as stream command argument")
+	}
+	count := int64(-1)
+	if len(args) == 5 && strings.ToUpper(args[3]) == "COUNT" {
+		if count, ok = parseInt(args[4]); !ok {
+			return errNotInt
+		}
+	} else if len(args) != 3 {
+		return errSyntax
+	}
+	it, errReply := s.lookupKind(c.db, args[0], typeStream)
+	if errReply != nil {
+		return errReply
+	}
+	entries := []interface{}{}
+	if it == nil {
+		return entries
+	}
+	for _, e := range it.stream {
+		if count >= 0 && int64(len(entries)) >= count {
+			break
+		}
+		if e.id.less(start) || end.less(e.id) {
+			continue
+		}
+		fields := make([]interface{}, len(e.fields))
+		for i, f := range e.fields {
+			fields[i] = f
+		}
+		entries = append(entries, []interface{}{e.id.String(), fields})
+	}
+	return entries
+}
@@ -0,0 +1,130 @@
+package pRedisFake
+
+import (
+	"github.com/zzj-custom/pkg/pRedis"
+	"strings"
+)
+
+const invalidateChannel = "__redis__:invalidate"
+
+// cmdClientTracking supports `CLIENT TRACKING ON|OFF [REDIRECT id] [BCAST]
+// [PREFIX prefix]...`. Invalidations are published to the subscribers of
+// __redis__:invalidate among the redirect client, as with RESP2.
+func cmdClientTracking(s *Server, c *client, args []string) interface{} {
+	var on bool
+	switch strings.ToUpper(args[0]) {
+	case "ON":
+		on = true
+	case "OFF":
+	default:
+		return errSyntax
+	}
+	var (
+		redirect int64
+		bcast    bool
+		prefixes []string
+	)
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REDIRECT":
+			var ok bool
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			if redirect, ok = parseInt(args[i+1]); !ok {
+				return redisError("ERR value is not an integer or out of range")
+			}
+			i++
+		case "BCAST":
+			bcast = true
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			prefixes = append(prefixes, args[i+1])
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	if len(prefixes) > 0 && !bcast {
+		return redisError("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if on && redirect != 0 && s.clientByID(redirect) == nil {
+		return redisError("ERR The client ID you want redirect to does not exist")
+	}
+	c.tracking, c.redirect, c.bcast, c.prefixes = on, redirect, bcast, prefixes
+	if !on {
+		s.untrack(c)
+	}
+	return status("OK")
+}
+
+func (s *Server) clientByID(id int64) *client {
+	for c := range s.clients {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// track remembers the keys read by a client tracking them. Keys of every
+// command are tracked, which only adds spurious invalidations.
+func (s *Server) track(c *client, args []string) {
+	if !c.tracking || c.bcast {
+		return
+	}
+	cmdArgs := make([]interface{}, len(args)-1)
+	for i, a := range args[1:] {
+		cmdArgs[i] = a
+	}
+	for _, key := range pRedis.CommandKeys(args[0], cmdArgs...) {
+		clients, ok := s.tracked[key]
+		if !ok {
+			clients = map[*client]struct{}{}
+			s.tracked[key] = clients
+		}
+		clients[c] = struct{}{}
+	}
+}
+
+func (s *Server) untrack(c *client) {
+	for key, clients := range s.tracked {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(s.tracked, key)
+		}
+	}
+}
+
+// invalidate notifies the clients tracking key that it changed, s.mu must be
+// held.
+func (s *Server) invalidate(key string) {
+	targets := map[int64]struct{}{}
+	for c := range s.tracked[key] {
+		targets[c.redirect] = struct{}{}
+	}
+	delete(s.tracked, key)
+	for c := range s.clients {
+		if !c.tracking || !c.bcast {
+			continue
+		}
+		if len(c.prefixes) == 0 {
+			targets[c.redirect] = struct{}{}
+		}
+		for _, prefix := range c.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				targets[c.redirect] = struct{}{}
+				break
+			}
+		}
+	}
+	for id := range targets {
+		if target := s.clientByID(id); target != nil {
+			if _, ok := target.channels[invalidateChannel]; ok {
+				target.write([]interface{}{"message", invalidateChannel, []interface{}{key}})
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package pRedis
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+)
+
+// Channels used by redis itself for notifications, they must never be prefixed.
+var reservedChannelPrefixes = []string{keyspaceChannelPrefix, keyeventChannelPrefix, "__redis__:"}
+
+// keyPrefixer prefixes keys and channels of the commands going through one
+// connection and strips the prefix from the replies that carry them back.
+type keyPrefixer struct {
+	prefix string
+	// subscribed is 1 while the connection is in pub/sub mode. It is set by
+	// Send and read by Receive, which may run in different goroutines.
+	subscribed int32
+}
+
+// KeyPrefixHook
+//
+// Prefix keys of known commands with prefix, including Lua KEYS of EVAL and
+// EVALSHA, pub/sub channels and prefixes of `CLIENT TRACKING`. Keys and
+// channels coming back in replies of Do (KEYS, SCAN, BLPOP, BRPOP, XREAD) and
+// in pub/sub messages are stripped again, so callers never see the prefix.
+// Replies of pipelined commands read by Receive are returned as is, except
+// pub/sub messages. Keyspace notifications are published on channels shared
+// by every tenant, so the ones about keys without prefix are dropped.
+//
+// NewPool installs it by itself when `DialConfig.KeyPrefix` is set, each
+// connection must use its own hook.
+func KeyPrefixHook(prefix string) Hook {
+	p := &keyPrefixer{prefix: prefix}
+	return Hook{
+		Do: func(next DoFunc) DoFunc {
+			return func(cmd string, args ...interface{}) (interface{}, error) {
+				reply, err := next(cmd, p.args(cmd, args)...)
+				if err != nil {
+					return reply, err
+				}
+				return p.reply(cmd, reply), nil
+			}
+		},
+		Send: func(next SendFunc) SendFunc {
+			return func(cmd string, args ...interface{}) error {
+				return next(cmd, p.args(cmd, args)...)
+			}
+		},
+		Receive: func(next ReceiveFunc) ReceiveFunc {
+			return func() (interface{}, error) {
+				for {
+					reply, err := next()
+					if err != nil || atomic.LoadInt32(&p.subscribed) == 0 {
+						return reply, err
+					}
+					if msg, ok := p.message(reply); ok {
+						return msg, nil
+					}
+				}
+			}
+		},
+	}
+}
+
+func (p *keyPrefixer) args(cmd string, args []interface{}) []interface{} {
+	if strings.EqualFold(cmd, "SCAN") {
+		return p.scanArgs(args)
+	}
+	if strings.EqualFold(cmd, "CLIENT") {
+		return p.clientArgs(args)
+	}
+	indexes := keyIndexes(cmd, args)
+	if len(indexes) == 0 {
+		return args
+	}
+	info, _ := lookupCommand(cmd)
+	if info.channels {
+		switch strings.ToUpper(cmd) {
+		case "SUBSCRIBE", "PSUBSCRIBE":
+			atomic.StoreInt32(&p.subscribed, 1)
+		}
+	}
+	prefixed := make([]interface{}, len(args))
+	copy(prefixed, args)
+	for _, i := range indexes {
+		name := argString(args[i])
+		if info.channels && isReservedChannel(name) {
+			continue
+		}
+		prefixed[i] = p.prefix + name
+	}
+	return prefixed
+}
+
+// scanArgs restricts SCAN to the keys under prefix.
+func (p *keyPrefixer) scanArgs(args []interface{}) []interface{} {
+	prefixed := make([]interface{}, len(args), len(args)+2)
+	copy(prefixed, args)
+	for i := 1; i+1 < len(prefixed); i += 2 {
+		if strings.EqualFold(argString(prefixed[i]), "MATCH") {
+			prefixed[i+1] = p.prefix + argString(prefixed[i+1])
+			return prefixed
+		}
+	}
+	return append(prefixed, "MATCH", p.prefix+"*")
+}
+
+// clientArgs prefixes the prefixes of `CLIENT TRACKING ... BCAST PREFIX p`,
+// so that broadcast invalidations are restricted to the keys under prefix.
+func (p *keyPrefixer) clientArgs(args []interface{}) []interface{} {
+	if len(args) == 0 || !strings.EqualFold(argString(args[0]), "TRACKING") {
+		return args
+	}
+	prefixed := make([]interface{}, len(args), len(args)+2)
+	copy(prefixed, args)
+	bcast, hasPrefix := false, false
+	for i := 1; i < len(prefixed); i++ {
+		switch strings.ToUpper(argString(prefixed[i])) {
+		case "BCAST":
+			bcast = true
+		case "PREFIX":
+			if i+1 < len(prefixed) {
+				prefixed[i+1] = p.prefix + argString(prefixed[i+1])
+				hasPrefix = true
+				i++
+			}
+		}
+	}
+	if bcast && !hasPrefix {
+		prefixed = append(prefixed, "PREFIX", p.prefix)
+	}
+	return prefixed
+}
+
+func (p *keyPrefixer) reply(cmd string, reply interface{}) interface{} {
+	switch strings.ToUpper(cmd) {
+	case "SCAN":
+		if values, ok := reply.([]interface{}); ok && len(values) == 2 {
+			p.reply("KEYS", values[1])
+		}
+	case "KEYS":
+		if names, ok := reply.([]interface{}); ok {
+			for i := range names {
+				names[i] = p.strip(names[i])
+			}
+		}
+	case "BLPOP", "BRPOP":
+		if pair, ok := reply.([]interface{}); ok && len(pair) == 2 {
+			pair[0] = p.strip(pair[0])
+		}
+	case "XREAD", "XREADGROUP":
+		if streams, ok := reply.([]interface{}); ok {
+			for _, stream := range streams {
+				if pair, ok := stream.([]interface{}); ok && len(pair) == 2 {
+					pair[0] = p.strip(pair[0])
+				}
+			}
+		}
+	}
+	return reply
+}
+
+// message strips the prefix from channels of pub/sub replies, it returns false
+// for the ones not meant for this prefix.
+func (p *keyPrefixer) message(reply interface{}) (interface{}, bool) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) < 3 {
+		return reply, true
+	}
+	kind, ok := values[0].([]byte)
+	if !ok {
+		return reply, true
+	}
+	switch string(kind) {
+	case "message":
+		values[1] = p.strip(values[1])
+		return reply, p.notification(values[1:])
+	case "pmessage":
+		values[1] = p.strip(values[1])
+		values[2] = p.strip(values[2])
+		if len(values) == 4 {
+			return reply, p.notification(values[2:])
+		}
+	case "subscribe", "psubscribe":
+		values[1] = p.strip(values[1])
+	case "unsubscribe", "punsubscribe":
+		values[1] = p.strip(values[1])
+		if count, ok := values[2].(int64); ok && count == 0 {
+			atomic.StoreInt32(&p.subscribed, 0)
+		}
+	}
+	return reply, true
+}
+
+// notification strips the prefix from keys carried by keyspace notifications
+// and invalidation messages of client tracking, channelAndData holds the
+// channel and the payload of a message. It returns false for a keyspace
+// notification about a key of another prefix.
+func (p *keyPrefixer) notification(channelAndData []interface{}) bool {
+	channel, ok := channelAndData[0].([]byte)
+	if !ok {
+		return true
+	}
+	switch {
+	case string(channel) == invalidateChannel:
+		if keys, ok := channelAndData[1].([]interface{}); ok {
+			for i := range keys {
+				keys[i] = p.strip(keys[i])
+			}
+		}
+	case bytes.HasPrefix(channel, []byte(keyeventChannelPrefix)):
+		key, ok := channelAndData[1].([]byte)
+		if !ok || !bytes.HasPrefix(key, []byte(p.prefix)) {
+			return false
+		}
+		channelAndData[1] = key[len(p.prefix):]
+	case bytes.HasPrefix(channel, []byte(keyspaceChannelPrefix)):
+		i := bytes.Index(channel, []byte("__:"))
+		if i < 0 || !bytes.HasPrefix(channel[i+3:], []byte(p.prefix)) {
+			return false
+		}
+		key := channel[i+3+len(p.prefix):]
+		channelAndData[0] = append(channel[:i+3:i+3], key...)
+	}
+	return true
+}
+
+func (p *keyPrefixer) strip(v interface{}) interface{} {
+	switch name := v.(type) {
+	case []byte:
+		return bytes.TrimPrefix(name, []byte(p.prefix))
+	case string:
+		return strings.TrimPrefix(name, p.prefix)
+	}
+	return v
+}
+
+func isReservedChannel(name string) bool {
+	for _, prefix := range reservedChannelPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
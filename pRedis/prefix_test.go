@@ -0,0 +1,170 @@
+package pRedis_test
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+	raw := newTestPool(t, srv, nil)
+
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("MSET", "a", "1", "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := redis.Strings(conn.Do("KEYS", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] == keys[1] || (keys[0] != "a" && keys[0] != "b") {
+		t.Fatalf("KEYS = %v, want the unprefixed keys", keys)
+	}
+	if _, err = pRedis.NewLock(pool).AcquireWithFence("lock", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	rawConn := raw.Get()
+	defer rawConn.Close()
+	for _, key := range []string{"app:a", "app:b", "app:lock", "app:lock:fence"} {
+		if ok, _ := redis.Bool(rawConn.Do("EXISTS", key)); !ok {
+			t.Errorf("%s does not exist", key)
+		}
+	}
+	if ok, _ := redis.Bool(rawConn.Do("EXISTS", "a")); ok {
+		t.Error("a exists without prefix")
+	}
+}
+
+func TestKeyPrefixSubscribers(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+
+	// subscribers of the same pool must not share the state of their hook
+	const subscribers = 8
+	var wg sync.WaitGroup
+	ready := make(chan struct{}, subscribers)
+	errs := make(chan error, subscribers)
+	for i := 0; i < subscribers; i++ {
+		wg.Add(1)
+		go func(channel string) {
+			defer wg.Done()
+			conn := pool.Get()
+			defer conn.Close()
+			psc := redis.PubSubConn{Conn: conn}
+			if err := psc.Subscribe(channel); err != nil {
+				errs <- err
+				return
+			}
+			if _, ok := psc.Receive().(redis.Subscription); !ok {
+				errs <- fmt.Errorf("%s: no subscription confirmation", channel)
+				return
+			}
+			ready <- struct{}{}
+			switch msg := psc.ReceiveWithTimeout(5 * time.Second).(type) {
+			case redis.Message:
+				if msg.Channel != channel || string(msg.Data) != channel {
+					errs <- fmt.Errorf("%s: received %+v", channel, msg)
+				}
+			case error:
+				errs <- fmt.Errorf("%s: %v", channel, msg)
+			}
+		}(fmt.Sprintf("ch%d", i))
+	}
+	for i := 0; i < subscribers; i++ {
+		select {
+		case <-ready:
+		case err := <-errs:
+			t.Fatal(err)
+		}
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+	raw := newTestPool(t, srv, nil).Get()
+	defer raw.Close()
+	for i := 0; i < subscribers; i++ {
+		channel := fmt.Sprintf("ch%d", i)
+		if _, err := conn.Do("PUBLISH", channel, channel); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := redis.Int(raw.Do("PUBLISH", "ch0", "unprefixed")); n != 0 {
+		t.Errorf("a message to the unprefixed channel reached %d subscribers", n)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestKeyPrefixHookStripsReplies(t *testing.T) {
+	srv := newTestServer(t)
+	raw := newTestPool(t, srv, nil).Get()
+	defer raw.Close()
+	conn := pRedis.WrapConn(raw, pRedis.KeyPrefixHook("app:"))
+
+	if _, err := raw.Do("SET", "other", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("SET", "k", "1"); err != nil {
+		t.Fatal(err)
+	}
+	values, err := redis.Values(conn.Do("SCAN", 0, "MATCH", "*", "COUNT", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := redis.Strings(values[1], nil)
+	if err != nil || !reflect.DeepEqual(keys, []string{"k"}) {
+		t.Fatalf("SCAN = %v, %v, want [k]", keys, err)
+	}
+}
+
+func TestKeyPrefixSubscriberSendsWhileReceiving(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+	conn := pool.Get()
+	defer conn.Close()
+	psc := redis.PubSubConn{Conn: conn}
+
+	// one goroutine receives while another subscribes and pings, like
+	// Subscription does
+	kinds := make(chan string, 16)
+	go func() {
+		defer close(kinds)
+		for {
+			switch n := psc.Receive().(type) {
+			case redis.Subscription:
+				kinds <- n.Kind + " " + n.Channel
+				if n.Count == 0 {
+					return
+				}
+			case redis.Pong:
+				kinds <- "pong"
+			case error:
+				kinds <- n.Error()
+				return
+			}
+		}
+	}()
+	for _, send := range []func() error{
+		func() error { return psc.Subscribe("a") },
+		func() error { return psc.Ping("") },
+		func() error { return psc.Unsubscribe("a") },
+	} {
+		if err := send(); err != nil {
+			t.Fatal(err)
+		}
+		<-kinds
+	}
+	if _, open := <-kinds; open {
+		t.Fatal("the receiver did not stop after unsubscribing")
+	}
+}
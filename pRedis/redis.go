@@ -1,23 +1,73 @@
 package pRedis
 
 import (
+	"context"
 	"fmt"
 	"github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 var (
 	redisPool       = sync.Map{}
+	poolConfigs     = sync.Map{}
+	registryMu      sync.Mutex
 	defaultPoolName = "default"
+
+	// poolReplicas keeps the configs of the replicas of pools created by
+	// InitMultiPools, so that ReconfigurePool rebuilds their ReplicaPool.
+	poolReplicas = sync.Map{}
+)
+
+const (
+	defaultDrainTimeout  = 30 * time.Second
+	drainPollingInterval = 50 * time.Millisecond
 )
 
+// durationProblems reports the durations of config, including the ones of its
+// Breaker and ClientCache, that are negative or below a millisecond. They used
+// to be numbers of seconds, so a value like 3 is rejected rather than taken as
+// 3ns or guessed to be 3s.
+func (config *DialConfig) durationProblems() []string {
+	type duration struct {
+		key   string
+		value time.Duration
+	}
+	durations := []duration{
+		{"connect-timeout", config.ConnectTimeout},
+		{"read-timeout", config.ReadTimeout},
+		{"max-conn-lifetime", config.MaxConnLifetime},
+		{"idle-timeout", config.IdleTimeout},
+	}
+	if config.Breaker != nil {
+		durations = append(durations, duration{"breaker.open-timeout", config.Breaker.OpenTimeout})
+	}
+	if config.ClientCache != nil {
+		durations = append(durations, duration{"client-cache.ttl", config.ClientCache.TTL})
+	}
+	var problems []string
+	for _, d := range durations {
+		switch {
+		case d.value < 0:
+			problems = append(problems, fmt.Sprintf("%s %s is negative", d.key, d.value))
+		case d.value > 0 && d.value < time.Millisecond:
+			problems = append(problems, fmt.Sprintf(
+				"%s %s is below a millisecond, durations are no longer seconds, e.g. use %q",
+				d.key, d.value, (d.value*time.Second).String()))
+		}
+	}
+	return problems
+}
+
 func (config *DialConfig) getDialOption() []redis.DialOption {
 	dialOptions := []redis.DialOption{
-		redis.DialReadTimeout(config.ReadTimeout * time.Second),
-		redis.DialConnectTimeout(config.ConnectTimeout * time.Second),
+		redis.DialReadTimeout(config.ReadTimeout),
+		redis.DialConnectTimeout(config.ConnectTimeout),
 		redis.DialDatabase(config.Database),
 	}
 	if config.Password != "" {
@@ -29,19 +79,35 @@ func (config *DialConfig) getDialOption() []redis.DialOption {
 // NewPool
 //
 // Just create a redis connection pool, you should persist it by yourself.
-// RegisterPool can be used to persist it.
+// RegisterPool can be used to persist it. `DialConfig.ClientCache` is ignored,
+// since nothing would close the cache with the pool, use NewClientCache. The
+// circuit breaker of the pool is named by the address of redis.
 func NewPool(config *DialConfig) (*redis.Pool, error) {
+	return newPool("", config)
+}
+
+// newPool is NewPool, with a circuit breaker named name, or the address of
+// redis if name is empty.
+func newPool(name string, config *DialConfig) (*redis.Pool, error) {
 	if config == nil {
 		return nil, fmt.Errorf("invalid initializer provided")
 	}
+	if problems := config.durationProblems(); len(problems) > 0 {
+		return nil, errors.Errorf("invalid redis config: %s", strings.Join(problems, "; "))
+	}
+
+	addr := config.Host + ":" + strconv.Itoa(config.Port)
+	if name == "" {
+		name = addr
+	}
+	var breaker *CircuitBreaker
+	if config.Breaker != nil {
+		breaker = NewCircuitBreaker(name, *config.Breaker)
+	}
 
 	pool := redis.Pool{
 		Dial: func() (redis.Conn, error) {
-			dial, err := redis.Dial(
-				"tcp",
-				config.Host+":"+strconv.Itoa(config.Port),
-				config.getDialOption()...,
-			)
+			dial, err := redis.Dial("tcp", addr, config.getDialOption()...)
 			if err != nil {
 				return nil, err
 			}
@@ -50,7 +116,22 @@ func NewPool(config *DialConfig) (*redis.Pool, error) {
 				_ = dial.Close()
 				return nil, err
 			}
-			return dial, nil
+			// the breaker and prefix hooks keep the pipeline and pub/sub
+			// states of their connection
+			var connHooks []Hook
+			if breaker != nil {
+				connHooks = append(connHooks, breaker.Hook())
+			}
+			connHooks = append(connHooks, config.Hooks...)
+			if config.KeyPrefix != "" {
+				connHooks = append(connHooks, KeyPrefixHook(config.KeyPrefix))
+			}
+			conn := WrapConn(dial, connHooks...)
+			if err = loadScripts(conn); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			return conn, nil
 		},
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
 			_, err := c.Do("PING")
@@ -58,9 +139,13 @@ func NewPool(config *DialConfig) (*redis.Pool, error) {
 		},
 		MaxIdle:         config.MaxIdle,
 		MaxActive:       config.MaxActive,
-		IdleTimeout:     config.IdleTimeout * time.Second,
+		IdleTimeout:     config.IdleTimeout,
 		Wait:            config.Wait,
-		MaxConnLifetime: config.MaxConnLifetime * time.Second,
+		MaxConnLifetime: config.MaxConnLifetime,
+	}
+	if breaker != nil {
+		pool.Dial = breaker.dial(pool.Dial)
+		poolBreakers.Store(&pool, breaker)
 	}
 	return &pool, nil
 }
@@ -68,14 +153,120 @@ func NewPool(config *DialConfig) (*redis.Pool, error) {
 // RegisterPool
 //
 // Persist a redis connection pool with given name.
-// If this name exists, pool will be replaced by this new one, and
-// the old pool will be closed.
+// If this name exists, pool will be replaced by this new one at once, and
+// the old pool will be closed in background once the connections borrowed
+// from it are returned, or after 30 seconds. Use ReplacePool to wait for it.
+//
+// Holders of the old pool, e.g. a Lock or a Scheduler created with it, fail
+// with "redigo: get on closed pool" once it is closed. Give them PoolRef
+// instead, so that they follow replacements.
 func RegisterPool(name string, pool *redis.Pool) {
-	if p, ok := redisPool.Load(name); ok {
-		p2 := p.(*redis.Pool)
-		_ = p2.Close()
+	if old := swapPool(name, pool); old != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultDrainTimeout)
+			defer cancel()
+			if err := DrainPool(ctx, old); err != nil {
+				log.WithError(err).WithField("pool", name).Warn("redis pool closed before being drained")
+			}
+		}()
+	}
+}
+
+// ReplacePool
+//
+// Persist a redis connection pool with given name like RegisterPool, and wait
+// for the old pool to be drained and closed, see DrainPool.
+func ReplacePool(ctx context.Context, name string, pool *redis.Pool) error {
+	old := swapPool(name, pool)
+	if old == nil {
+		return nil
 	}
+	return DrainPool(ctx, old)
+}
+
+func swapPool(name string, pool *redis.Pool) *redis.Pool {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	p, ok := redisPool.Load(name)
 	redisPool.Store(name, pool)
+	if !ok || p.(*redis.Pool) == pool {
+		return nil
+	}
+	return p.(*redis.Pool)
+}
+
+// DrainPool
+//
+// Wait until every connection borrowed from pool is returned, then close it.
+// If ctx is done first, pool is closed anyway and the error of ctx returned,
+// connections still borrowed are closed when they are returned.
+func DrainPool(ctx context.Context, pool *redis.Pool) error {
+	defer func() {
+		poolBreakers.Delete(pool)
+		if c, ok := poolCaches.LoadAndDelete(pool); ok {
+			_ = c.(*ClientCache).Close()
+		}
+		if g, ok := replicaGroups.LoadAndDelete(pool); ok {
+			g.(*ReplicaGroup).close()
+		}
+	}()
+	ticker := time.NewTicker(drainPollingInterval)
+	defer ticker.Stop()
+	for {
+		stats := pool.Stats()
+		if stats.ActiveCount <= stats.IdleCount {
+			return pool.Close()
+		}
+		select {
+		case <-ctx.Done():
+			_ = pool.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReconfigurePool
+//
+// Replace the pool with given name by a new one created from config, e.g. when
+// pool sizes are changed in etcd. The old pool is drained as by RegisterPool,
+// so its holders break unless they use PoolRef. The replicas of a pool
+// created by InitMultiPools are kept, with the key prefix of config.
+//
+// Example:
+//
+//	config, _ := pRedis.PoolConfig("cache")
+//	config.MaxActive = 200
+//	err := pRedis.ReconfigurePool("cache", config)
+func ReconfigurePool(name string, config *DialConfig) error {
+	var replicas []*DialConfig
+	if r, ok := poolReplicas.Load(name); ok {
+		replicas = r.([]*DialConfig)
+	}
+	pool, err := buildPool(name, config, replicas)
+	if err != nil {
+		return err
+	}
+	poolConfigs.Store(name, config)
+	RegisterPool(name, pool)
+	return nil
+}
+
+// PoolConfig
+//
+// Fetch a copy of the config of the pool with given name, as given to
+// InitPool or ReconfigurePool. If no name given, use `defaultPoolName`.
+func PoolConfig(name ...string) (*DialConfig, error) {
+	poolName := defaultPoolName
+	if len(name) > 0 {
+		poolName = name[0]
+	}
+	c, ok := poolConfigs.Load(poolName)
+	if !ok {
+		return nil, errors.Errorf("no config of pool %s found", poolName)
+	}
+	config := *c.(*DialConfig)
+	return &config, nil
 }
 
 // UseAsDefaultPool
@@ -91,10 +282,12 @@ func UseAsDefaultPool(name string) {
 // Init redis connection pool with given name, so can fetch it again
 // by this give name through `iRedis.Pool(name string)`
 func InitPool(name string, config *DialConfig) (*redis.Pool, error) {
-	pool, err := NewPool(config)
+	pool, err := buildPool(name, config, nil)
 	if err != nil {
 		return nil, err
 	}
+	poolConfigs.Store(name, config)
+	poolReplicas.Delete(name)
 	RegisterPool(name, pool)
 	return pool, nil
 }
@@ -125,7 +318,18 @@ func InitMultiPools(configs []*MultiDialConfig) error {
 	}
 	hasDefaultFound := false
 	for _, mdc := range configs {
-		_, err := InitPool(mdc.Name, mdc.Config)
+		config := mdc.Config
+		if config != nil && mdc.KeyPrefix != "" {
+			c := *config
+			c.KeyPrefix = mdc.KeyPrefix
+			config = &c
+		}
+		var err error
+		if len(mdc.Replicas) == 0 {
+			_, err = InitPool(mdc.Name, config)
+		} else {
+			err = initReplicaPool(mdc, config)
+		}
 		if err != nil {
 			return err
 		}
@@ -140,6 +344,60 @@ func InitMultiPools(configs []*MultiDialConfig) error {
 	return nil
 }
 
+func initReplicaPool(mdc *MultiDialConfig, config *DialConfig) error {
+	pool, err := buildPool(mdc.Name, config, mdc.Replicas)
+	if err != nil {
+		return err
+	}
+	poolConfigs.Store(mdc.Name, config)
+	poolReplicas.Store(mdc.Name, mdc.Replicas)
+	RegisterPool(mdc.Name, pool)
+	return nil
+}
+
+// buildPool creates the pool of config registered by name, routing read-only
+// commands to replicas if any, see NewReplicaPool. Replicas use the key prefix
+// of config so that they read the keys written through the primary. The
+// client cache of config is created on the primary, and closed by DrainPool
+// with the pool. Circuit breakers are named after the pool, e.g. `cache` and
+// `cache/replica-0`.
+func buildPool(name string, config *DialConfig, replicas []*DialConfig) (*redis.Pool, error) {
+	primary, err := newPool(name, config)
+	if err != nil {
+		return nil, err
+	}
+	pool := primary
+	if len(replicas) > 0 {
+		pools := make([]*redis.Pool, 0, len(replicas))
+		for i, rc := range replicas {
+			c := *rc
+			c.KeyPrefix = config.KeyPrefix
+			replica, err := newPool(fmt.Sprintf("%s/replica-%d", name, i), &c)
+			if err != nil {
+				closePools(append(pools, primary))
+				return nil, err
+			}
+			pools = append(pools, replica)
+		}
+		pool = NewReplicaPool(primary, pools...)
+	}
+	if config.ClientCache != nil {
+		cache, err := NewClientCache(primary, *config.ClientCache)
+		if err != nil {
+			closePools([]*redis.Pool{pool})
+			return nil, err
+		}
+		poolCaches.Store(pool, cache)
+	}
+	return pool, nil
+}
+
+func closePools(pools []*redis.Pool) {
+	for _, p := range pools {
+		_ = DrainPool(context.Background(), p)
+	}
+}
+
 // Pool
 //
 // Fetch a redis connection pool with given name. If no name given, use
@@ -156,3 +414,81 @@ func Pool(name ...string) (*redis.Pool, error) {
 	pool := p.(*redis.Pool)
 	return pool, nil
 }
+
+// PoolRef
+//
+// Return a pool borrowing its connections from the pool registered with given
+// name at the time of each Get, so that it keeps working when the pool is
+// replaced by RegisterPool or ReconfigurePool. If no name given, use
+// `defaultPoolName` when PoolRef is called. Give it to long-lived holders of a
+// pool, like Lock or Scheduler. It keeps no connections of its own, and helpers
+// looking a pool up by pointer, like ClientCacheOf, need Pool instead.
+//
+// Example:
+//
+//	lock := pRedis.NewLock(pRedis.PoolRef("cache"))
+func PoolRef(name ...string) *redis.Pool {
+	poolName := defaultPoolName
+	if len(name) > 0 {
+		poolName = name[0]
+	}
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			pool, err := Pool(poolName)
+			if err != nil {
+				return nil, err
+			}
+			conn := pool.Get()
+			if err = conn.Err(); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		},
+	}
+}
+
+// Names
+//
+// Return the names of every registered pool, sorted.
+func Names() []string {
+	var names []string
+	redisPool.Range(func(k, _ interface{}) bool {
+		names = append(names, k.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// CloseAll
+//
+// Unregister every pool and close them once drained, see DrainPool. Pools
+// are drained concurrently, and the first error is returned.
+func CloseAll(ctx context.Context) error {
+	registryMu.Lock()
+	pools := map[string]*redis.Pool{}
+	redisPool.Range(func(k, v interface{}) bool {
+		pools[k.(string)] = v.(*redis.Pool)
+		redisPool.Delete(k)
+		poolConfigs.Delete(k)
+		poolReplicas.Delete(k)
+		return true
+	})
+	registryMu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(pools))
+	for name, pool := range pools {
+		wg.Add(1)
+		go func(name string, pool *redis.Pool) {
+			defer wg.Done()
+			if err := DrainPool(ctx, pool); err != nil {
+				errs <- errors.Wrapf(err, "close pool %s failed", name)
+			}
+		}(name, pool)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
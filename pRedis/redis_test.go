@@ -0,0 +1,104 @@
+package pRedis_test
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"github.com/zzj-custom/pkg/pRedis/pRedisFake"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *pRedisFake.Server {
+	t.Helper()
+	srv, err := pRedisFake.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv
+}
+
+// newTestPool creates a pool dialing srv, configured by configure if not nil.
+func newTestPool(t *testing.T, srv *pRedisFake.Server, configure func(config *pRedis.DialConfig)) *redis.Pool {
+	t.Helper()
+	config := srv.DialConfig()
+	if configure != nil {
+		configure(config)
+	}
+	pool, err := pRedis.NewPool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pool.Close() })
+	return pool
+}
+
+// waitFor polls cond until it holds, or fails the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNewPoolRejectsAmbiguousDurations(t *testing.T) {
+	srv := newTestServer(t)
+	config := srv.DialConfig()
+	config.ReadTimeout = 3
+	if _, err := pRedis.NewPool(config); err == nil || !strings.Contains(err.Error(), `read-timeout 3ns is below a millisecond, durations are no longer seconds, e.g. use "3s"`) {
+		t.Fatalf("NewPool with a read timeout of 3ns = %v, want an error", err)
+	}
+	config.ReadTimeout = 3 * time.Second
+
+	config.Breaker = &pRedis.BreakerOptions{OpenTimeout: 5}
+	config.ClientCache = &pRedis.ClientCacheOptions{TTL: -time.Minute}
+	_, err := pRedis.NewPool(config)
+	if err == nil || !strings.Contains(err.Error(), "breaker.open-timeout 5ns") || !strings.Contains(err.Error(), "client-cache.ttl -1m0s is negative") {
+		t.Fatalf("NewPool with an open timeout of 5ns and a negative ttl = %v, want errors", err)
+	}
+	config.Breaker.OpenTimeout = 5 * time.Second
+	config.ClientCache.TTL = time.Minute
+	pool, err := pRedis.NewPool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pool.Close()
+}
+
+func TestPoolRefFollowsReconfigure(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	if _, err := first.InitPool("test-pool-ref"); err != nil {
+		t.Fatal(err)
+	}
+	ref := pRedis.PoolRef("test-pool-ref")
+	defer ref.Close()
+
+	set := func(value string) {
+		t.Helper()
+		conn := ref.Get()
+		defer conn.Close()
+		if _, err := conn.Do("SET", "k", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set("first")
+	if err := pRedis.ReconfigurePool("test-pool-ref", second.DialConfig()); err != nil {
+		t.Fatal(err)
+	}
+	set("second")
+
+	for srv, want := range map[*pRedisFake.Server]string{first: "first", second: "second"} {
+		pool := newTestPool(t, srv, nil)
+		conn := pool.Get()
+		got, err := redis.String(conn.Do("GET", "k"))
+		_ = conn.Close()
+		if err != nil || got != want {
+			t.Errorf("GET = %q, %v, want %q", got, err, want)
+		}
+	}
+}
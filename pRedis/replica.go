@@ -0,0 +1,236 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReplicaCooldown = 5 * time.Second
+
+// replicaGroups maps the pools created by NewReplicaPool to their group.
+var replicaGroups = sync.Map{}
+
+type replica struct {
+	pool      *redis.Pool
+	downUntil int64 // unix nano, 0 when healthy
+}
+
+func (r *replica) healthy(now time.Time) bool {
+	return atomic.LoadInt64(&r.downUntil) <= now.UnixNano()
+}
+
+// ReplicaGroup
+//
+// A primary and its replicas under one logical pool. Read-only commands
+// issued by Do (`GET`, `HGETALL`, `ZRANGE`...) are balanced over healthy
+// replicas, everything else goes to the primary: writes, scripts,
+// transactions, pub/sub and pipelines (Send, Flush and Receive).
+//
+// Reads following a write on the same connection go to the primary too, so a
+// connection always reads its own writes. A replica failing with a network
+// error is skipped for Cooldown, and the read is retried on the primary.
+type ReplicaGroup struct {
+	Primary  *redis.Pool
+	Replicas []*redis.Pool
+	// Cooldown is how long a failed replica is skipped, 5 seconds by default.
+	Cooldown time.Duration
+
+	replicas []*replica
+	next     uint32
+}
+
+// NewReplicaPool
+//
+// Create a pool routing commands to primary and replicas, see ReplicaGroup.
+// It can be registered with RegisterPool like any pool, closing it closes
+// primary and replicas too.
+func NewReplicaPool(primary *redis.Pool, replicas ...*redis.Pool) *redis.Pool {
+	g := &ReplicaGroup{Primary: primary, Replicas: replicas, Cooldown: defaultReplicaCooldown}
+	for _, p := range replicas {
+		g.replicas = append(g.replicas, &replica{pool: p})
+	}
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return &routedConn{group: g}, nil
+		},
+		MaxIdle:   primary.MaxIdle,
+		MaxActive: primary.MaxActive,
+		Wait:      primary.Wait,
+	}
+	replicaGroups.Store(pool, g)
+	return pool
+}
+
+// Replicas
+//
+// Fetch the replica group of the pool with given name, see Pool. An error is
+// returned if the pool has no replicas.
+func Replicas(name ...string) (*ReplicaGroup, error) {
+	pool, err := Pool(name...)
+	if err != nil {
+		return nil, err
+	}
+	g, ok := replicaGroups.Load(pool)
+	if !ok {
+		return nil, errors.Errorf("no replicas for pool %v", name)
+	}
+	return g.(*ReplicaGroup), nil
+}
+
+// PrimaryPool
+//
+// Fetch the pool with given name like Pool, but the primary if it has
+// replicas. Use it for long-lived connections, e.g. subscriptions.
+func PrimaryPool(name ...string) (*redis.Pool, error) {
+	pool, err := Pool(name...)
+	if err != nil {
+		return nil, err
+	}
+	if g, ok := replicaGroups.Load(pool); ok {
+		return g.(*ReplicaGroup).Primary, nil
+	}
+	return pool, nil
+}
+
+// pick returns a healthy replica, round robin, nil if none.
+func (g *ReplicaGroup) pick() *replica {
+	n := len(g.replicas)
+	if n == 0 {
+		return nil
+	}
+	now := time.Now()
+	start := atomic.AddUint32(&g.next, 1)
+	for i := 0; i < n; i++ {
+		r := g.replicas[(int(start)+i)%n]
+		if r.healthy(now) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (g *ReplicaGroup) markDown(r *replica, err error) {
+	cooldown := g.Cooldown
+	if cooldown <= 0 {
+		cooldown = defaultReplicaCooldown
+	}
+	atomic.StoreInt64(&r.downUntil, time.Now().Add(cooldown).UnixNano())
+	log.WithError(err).Warn("redis replica skipped")
+}
+
+// close closes primary and replicas, and forgets their breakers.
+func (g *ReplicaGroup) close() {
+	for _, p := range append([]*redis.Pool{g.Primary}, g.Replicas...) {
+		poolBreakers.Delete(p)
+		_ = p.Close()
+	}
+}
+
+// routedConn is the connection of a replica pool. It borrows a connection of
+// the primary at the first command that must run on it, and keeps it until
+// the routed connection is returned to the pool.
+type routedConn struct {
+	group   *ReplicaGroup
+	primary redis.Conn
+}
+
+func (c *routedConn) pinned() redis.Conn {
+	if c.primary == nil {
+		c.primary = c.group.Primary.Get()
+	}
+	return c.primary
+}
+
+// release gives the primary connection back, its transaction and
+// subscription states are reset by the pool before.
+func (c *routedConn) release() error {
+	if c.primary == nil {
+		return nil
+	}
+	err := c.primary.Close()
+	c.primary = nil
+	return err
+}
+
+func (c *routedConn) Close() error {
+	return c.release()
+}
+
+func (c *routedConn) Err() error {
+	if c.primary != nil {
+		return c.primary.Err()
+	}
+	return nil
+}
+
+func (c *routedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.do(func(conn redis.Conn) (interface{}, error) {
+		return conn.Do(cmd, args...)
+	}, cmd)
+}
+
+func (c *routedConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return c.do(func(conn redis.Conn) (interface{}, error) {
+		return redis.DoWithTimeout(conn, timeout, cmd, args...)
+	}, cmd)
+}
+
+func (c *routedConn) do(do func(conn redis.Conn) (interface{}, error), cmd string) (interface{}, error) {
+	if cmd == "" {
+		// the pool flushes a connection given back with Do(""), the
+		// primary pool does the same when the connection is released
+		return nil, c.release()
+	}
+	if c.primary != nil || !isReadOnly(cmd) {
+		return do(c.pinned())
+	}
+	r := c.group.pick()
+	if r == nil {
+		return do(c.pinned())
+	}
+	conn := r.pool.Get()
+	reply, err := do(conn)
+	_ = conn.Close()
+	if err == nil || !isReplicaFailure(err) {
+		return reply, err
+	}
+	c.group.markDown(r, err)
+	return do(c.pinned())
+}
+
+// doOnPrimary runs cmd on conn like Do, but pipelined, so that a connection
+// of a ReplicaGroup sends it to the primary even if it is read-only. Use it
+// for reads that must see the writes of other connections.
+func doOnPrimary(conn redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+	if err := conn.Send(cmd, args...); err != nil {
+		return nil, err
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	return conn.Receive()
+}
+
+func isReplicaFailure(err error) bool {
+	return errors.Cause(err) == ErrCircuitOpen || isRedisFailure(err)
+}
+
+func (c *routedConn) Send(cmd string, args ...interface{}) error {
+	return c.pinned().Send(cmd, args...)
+}
+
+func (c *routedConn) Flush() error {
+	return c.pinned().Flush()
+}
+
+func (c *routedConn) Receive() (interface{}, error) {
+	return c.pinned().Receive()
+}
+
+func (c *routedConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.pinned(), timeout)
+}
@@ -0,0 +1,129 @@
+package pRedis_test
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"github.com/zzj-custom/pkg/pRedis/pRedisFake"
+	"reflect"
+	"testing"
+)
+
+func TestReplicaRouting(t *testing.T) {
+	primary, replica := newTestServer(t), newTestServer(t)
+	// the fake does not replicate, so every server holds its own value of k
+	for srv, value := range map[*pRedisFake.Server]string{primary: "primary", replica: "replica"} {
+		conn := newTestPool(t, srv, nil).Get()
+		_, err := conn.Do("SET", "app:k", value)
+		_ = conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := pRedis.InitMultiPools([]*pRedis.MultiDialConfig{{
+		Name:      "test-replica",
+		Config:    primary.DialConfig(),
+		KeyPrefix: "app:",
+		Replicas:  []*pRedis.DialConfig{replica.DialConfig()},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(stage string) {
+		t.Helper()
+		pool, err := pRedis.Pool("test-replica")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := pool.Get()
+		defer conn.Close()
+		if v, err := redis.String(conn.Do("GET", "k")); err != nil || v != "replica" {
+			t.Fatalf("%s: GET = %q, %v, want the value of the replica", stage, v, err)
+		}
+		if _, err = conn.Do("SET", "w", "1"); err != nil {
+			t.Fatal(err)
+		}
+		// a connection reads its own writes from the primary
+		if v, err := redis.String(conn.Do("GET", "k")); err != nil || v != "primary" {
+			t.Fatalf("%s: GET after SET = %q, %v, want the value of the primary", stage, v, err)
+		}
+	}
+	check("initial")
+
+	config, err := pRedis.PoolConfig("test-replica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconfigured := *config
+	reconfigured.MaxIdle = 2
+	if err = pRedis.ReconfigurePool("test-replica", &reconfigured); err != nil {
+		t.Fatal(err)
+	}
+	if group, err := pRedis.Replicas("test-replica"); err != nil || len(group.Replicas) != 1 {
+		t.Fatalf("Replicas after ReconfigurePool = %v, %v, want the replica kept", group, err)
+	}
+	check("reconfigured")
+}
+
+func TestReplicaScan(t *testing.T) {
+	primary, replica := newTestServer(t), newTestServer(t)
+	for srv, key := range map[*pRedisFake.Server]string{primary: "app:primary", replica: "app:replica"} {
+		conn := newTestPool(t, srv, nil).Get()
+		_, err := conn.Do("HSET", key, "f", "v")
+		_ = conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := pRedis.InitMultiPools([]*pRedis.MultiDialConfig{{
+		Name:      "test-replica-scan",
+		Config:    primary.DialConfig(),
+		KeyPrefix: "app:",
+		Replicas:  []*pRedis.DialConfig{replica.DialConfig()},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := pRedis.PoolRef("test-replica-scan")
+
+	// cursors of a replica would be resumed on another server, scans go to
+	// the primary
+	var keys []string
+	it := pRedis.Scan(pool, pRedis.ScanOptions{})
+	for it.Next(context.Background()) {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil || !reflect.DeepEqual(keys, []string{"primary"}) {
+		t.Fatalf("Scan = %v, %v, want the keys of the primary", keys, it.Err())
+	}
+	it = pRedis.HScan(pool, "primary", pRedis.ScanOptions{})
+	if !it.Next(context.Background()) || it.Key() != "f" {
+		t.Fatalf("HScan = %v, want the fields of the primary", it.Err())
+	}
+}
+
+func TestReplicaPoolReconfigureForgetsBreakers(t *testing.T) {
+	primary, replica := newTestServer(t), newTestServer(t)
+	config, replicaConfig := primary.DialConfig(), replica.DialConfig()
+	config.Breaker = &pRedis.BreakerOptions{}
+	replicaConfig.Breaker = &pRedis.BreakerOptions{}
+	err := pRedis.InitMultiPools([]*pRedis.MultiDialConfig{{
+		Name:     "test-replica-breakers",
+		Config:   config,
+		Replicas: []*pRedis.DialConfig{replicaConfig},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := pRedis.BreakerCount()
+	for i := 0; i < 3; i++ {
+		if err = pRedis.ReconfigurePool("test-replica-breakers", config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the old pools are drained in background
+	waitFor(t, "the breakers of the old pools to be forgotten", func() bool {
+		return pRedis.BreakerCount() == count
+	})
+}
@@ -0,0 +1,195 @@
+package pRedis
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+type ScanOptions struct {
+	// Match filters elements by a glob-style pattern.
+	Match string
+	// Count hints redis how many elements to walk per round trip.
+	Count int
+	// Type filters keys by type, only for SCAN (redis >= 6.0).
+	Type string
+	// Interval is the minimum delay between two round trips, so that long
+	// maintenance jobs do not hurt the latency of other clients.
+	Interval time.Duration
+	// Cursor to start from, e.g. one saved by a previous iterator.
+	Cursor uint64
+}
+
+// Usage:
+// Create an iterator by Scan, HScan, SScan or ZScan, then call Next until it
+// returns false and check Err.
+//
+// Example:
+// it := pRedis.Scan(pool, pRedis.ScanOptions{Match: "session:*", Count: 500})
+// for it.Next(ctx) {
+//     log.Info(it.Key())
+// }
+// if err := it.Err(); err != nil {
+//     return err
+// }
+
+type ScanIterator struct {
+	pool *redis.Pool
+	cmd  string
+	key  string
+	opts ScanOptions
+	pair bool
+
+	cursor   uint64
+	started  bool
+	items    []string
+	pos      int
+	err      error
+	lastScan time.Time
+}
+
+// Scan iterates over keys of the database with `SCAN`.
+func Scan(pool *redis.Pool, opts ScanOptions) *ScanIterator {
+	return newScanIterator(pool, "SCAN", "", opts, false)
+}
+
+// HScan iterates over fields and values of hash key with `HSCAN`.
+func HScan(pool *redis.Pool, key string, opts ScanOptions) *ScanIterator {
+	return newScanIterator(pool, "HSCAN", key, opts, true)
+}
+
+// SScan iterates over members of set key with `SSCAN`.
+func SScan(pool *redis.Pool, key string, opts ScanOptions) *ScanIterator {
+	return newScanIterator(pool, "SSCAN", key, opts, false)
+}
+
+// ZScan iterates over members and scores of sorted set key with `ZSCAN`.
+func ZScan(pool *redis.Pool, key string, opts ScanOptions) *ScanIterator {
+	return newScanIterator(pool, "ZSCAN", key, opts, true)
+}
+
+func newScanIterator(pool *redis.Pool, cmd, key string, opts ScanOptions, pair bool) *ScanIterator {
+	return &ScanIterator{
+		pool:   pool,
+		cmd:    cmd,
+		key:    key,
+		opts:   opts,
+		pair:   pair,
+		cursor: opts.Cursor,
+	}
+}
+
+// Next advances the iterator, it returns false when the iteration is over,
+// ctx is done or an error occurs.
+func (it *ScanIterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	step := 1
+	if it.pair {
+		step = 2
+	}
+	if it.started {
+		it.pos += step
+	}
+	for it.pos+step > len(it.items) {
+		if it.started && it.cursor == 0 {
+			return false
+		}
+		if err := it.fetch(ctx); err != nil {
+			it.err = err
+			return false
+		}
+	}
+	return true
+}
+
+func (it *ScanIterator) fetch(ctx context.Context) error {
+	if it.opts.Interval > 0 && !it.lastScan.IsZero() {
+		if wait := it.opts.Interval - time.Since(it.lastScan); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var args []interface{}
+	if it.key != "" {
+		args = append(args, it.key)
+	}
+	args = append(args, it.cursor)
+	if it.opts.Match != "" {
+		args = append(args, "MATCH", it.opts.Match)
+	}
+	if it.opts.Count > 0 {
+		args = append(args, "COUNT", it.opts.Count)
+	}
+	if it.opts.Type != "" && it.cmd == "SCAN" {
+		args = append(args, "TYPE", it.opts.Type)
+	}
+
+	conn, err := it.pool.GetContext(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "%s failed", it.cmd)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	values, err := redis.Values(conn.Do(it.cmd, args...))
+	it.lastScan = time.Now()
+	if err != nil {
+		return errors.Wrapf(err, "%s failed", it.cmd)
+	}
+	if len(values) != 2 {
+		return errors.Errorf("%s failed, unexpected reply length %d", it.cmd, len(values))
+	}
+	cursor, err := redis.String(values[0], nil)
+	if err != nil {
+		return errors.Wrapf(err, "%s failed", it.cmd)
+	}
+	if it.cursor, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+		return errors.Wrapf(err, "%s failed", it.cmd)
+	}
+	if it.items, err = redis.Strings(values[1], nil); err != nil {
+		return errors.Wrapf(err, "%s failed", it.cmd)
+	}
+	it.pos = 0
+	it.started = true
+	return nil
+}
+
+// Key returns the current key of SCAN, field of HSCAN or member of SSCAN and ZSCAN.
+func (it *ScanIterator) Key() string {
+	if it.pos < len(it.items) {
+		return it.items[it.pos]
+	}
+	return ""
+}
+
+// Value returns the current value of HSCAN or score of ZSCAN, it is always
+// empty for SCAN and SSCAN.
+func (it *ScanIterator) Value() string {
+	if it.pair && it.pos+1 < len(it.items) {
+		return it.items[it.pos+1]
+	}
+	return ""
+}
+
+// Cursor returns the cursor of the next round trip. Iterating again from it
+// with `ScanOptions.Cursor` resumes after the elements already fetched.
+func (it *ScanIterator) Cursor() uint64 {
+	return it.cursor
+}
+
+func (it *ScanIterator) Err() error {
+	return it.err
+}
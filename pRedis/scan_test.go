@@ -0,0 +1,107 @@
+package pRedis_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/zzj-custom/pkg/pRedis"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+	conn := pool.Get()
+	defer conn.Close()
+	var want []string
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		want = append(want, key)
+		if _, err := conn.Do("SET", key, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.Do("HSET", "user:hash", "f", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	it := pRedis.Scan(pool, pRedis.ScanOptions{Match: "user:*", Count: 3, Type: "string"})
+	var keys []string
+	for it.Next(ctx) {
+		keys = append(keys, it.Key())
+		if it.Value() != "" {
+			t.Fatalf("Value of SCAN = %q, want nothing", it.Value())
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Scan = %v, want %v", keys, want)
+	}
+
+	// resume from the cursor of a stopped iteration
+	it = pRedis.Scan(pool, pRedis.ScanOptions{Count: 4})
+	seen := map[string]bool{}
+	for i := 0; i < 4 && it.Next(ctx); i++ {
+		seen[it.Key()] = true
+	}
+	it = pRedis.Scan(pool, pRedis.ScanOptions{Count: 4, Cursor: it.Cursor()})
+	for it.Next(ctx) {
+		if seen[it.Key()] {
+			t.Fatalf("%s scanned again after resuming", it.Key())
+		}
+		seen[it.Key()] = true
+	}
+	if it.Err() != nil || len(seen) != 11 {
+		t.Fatalf("resumed scan saw %d keys, %v, want 11", len(seen), it.Err())
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	it = pRedis.Scan(pool, pRedis.ScanOptions{})
+	if it.Next(cancelled) || it.Err() != context.Canceled {
+		t.Fatalf("Scan with a cancelled context = %v, want it stopped", it.Err())
+	}
+}
+
+func TestScanCollections(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	conn := pool.Get()
+	defer conn.Close()
+	for _, cmd := range [][]interface{}{
+		{"HSET", "h", "a", "1", "b", "2"},
+		{"SADD", "s", "x", "y"},
+		{"ZADD", "z", 1, "m", 2.5, "n"},
+	} {
+		if _, err := conn.Do(cmd[0].(string), cmd[1:]...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(it *pRedis.ScanIterator) map[string]string {
+		t.Helper()
+		got := map[string]string{}
+		for it.Next(context.Background()) {
+			got[it.Key()] = it.Value()
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got := collect(pRedis.HScan(pool, "h", pRedis.ScanOptions{})); !reflect.DeepEqual(got, map[string]string{"a": "1", "b": "2"}) {
+		t.Fatalf("HScan = %v", got)
+	}
+	if got := collect(pRedis.SScan(pool, "s", pRedis.ScanOptions{})); !reflect.DeepEqual(got, map[string]string{"x": "", "y": ""}) {
+		t.Fatalf("SScan = %v", got)
+	}
+	if got := collect(pRedis.ZScan(pool, "z", pRedis.ScanOptions{Match: "n"})); !reflect.DeepEqual(got, map[string]string{"n": "2.5"}) {
+		t.Fatalf("ZScan = %v", got)
+	}
+	if got := collect(pRedis.HScan(pool, "missing", pRedis.ScanOptions{})); len(got) != 0 {
+		t.Fatalf("HScan of a missing key = %v, want nothing", got)
+	}
+}
@@ -0,0 +1,495 @@
+package pRedis
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSchedulerKeyPrefix = "cron:"
+	defaultJobLockSeconds     = 60
+	defaultJobMaxCatchUp      = 10
+
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+var (
+	// jobStartScript records the start of a run, unless a run with a newer
+	// fencing token, or of the same or a later activation, was recorded
+	// already.
+	jobStartScript = RegisterScript("pRedis:scheduler:start", 1, `
+local state = redis.call('HMGET', KEYS[1], 'token', 'last_scheduled')
+if tonumber(ARGV[1]) <= tonumber(state[1] or '0') or tonumber(ARGV[2]) <= tonumber(state[2] or '0') then
+	return 0
+end
+redis.call('HMSET', KEYS[1], 'token', ARGV[1], 'last_scheduled', ARGV[2], 'last_started', ARGV[3],
+	'runner', ARGV[4], 'status', 'running', 'missed', ARGV[5], 'next_run', ARGV[6], 'error', '')
+return 1
+`)
+	// jobFinishScript records the outcome of a run, if it is still the
+	// latest one.
+	jobFinishScript = RegisterScript("pRedis:scheduler:finish", 1, `
+if redis.call('HGET', KEYS[1], 'token') ~= ARGV[1] then
+	return 0
+end
+redis.call('HMSET', KEYS[1], 'status', ARGV[2], 'error', ARGV[3], 'last_finished', ARGV[4], 'last_duration', ARGV[5])
+return 1
+`)
+)
+
+// MissedRunPolicy tells what to do with the activations missed while no
+// scheduler was running, or while the previous run was still in progress.
+type MissedRunPolicy int
+
+const (
+	// MissedRunSkip ignores missed activations.
+	MissedRunSkip MissedRunPolicy = iota
+	// MissedRunOnce runs once for all missed activations.
+	MissedRunOnce
+	// MissedRunAll runs once per missed activation, up to `JobOptions.MaxCatchUp`.
+	MissedRunAll
+)
+
+type JobOptions struct {
+	MissedRuns MissedRunPolicy
+	// MaxCatchUp bounds the runs of MissedRunAll, the latest activations are
+	// kept. 10 by default.
+	MaxCatchUp int
+	// LockSeconds is the ttl of the lock held while running, which is renewed
+	// every third of it. 60 by default.
+	LockSeconds int
+}
+
+// JobRun describes a run given to a Job.
+type JobRun struct {
+	Name string
+	// ScheduledAt is the activation time the run stands for.
+	ScheduledAt time.Time
+	// Missed is the number of activations skipped before this one.
+	Missed int
+	// Token is a fencing token increasing with every run of the job, pass it
+	// to downstream writes so that they can reject a runner that lost its lock,
+	// see CheckFencingToken. The run is cancelled if the lock is lost.
+	Token int64
+}
+
+type Job func(ctx context.Context, run JobRun) error
+
+// JobState is the record of the last run of a job kept in redis.
+type JobState struct {
+	Status        string
+	Error         string
+	Runner        string
+	Token         int64
+	Missed        int
+	LastScheduled time.Time
+	LastStarted   time.Time
+	LastFinished  time.Time
+	LastDuration  time.Duration
+	NextRun       time.Time
+}
+
+type SchedulerOptions struct {
+	Ctx  context.Context
+	Pool *redis.Pool
+	// KeyPrefix of the keys of the scheduler, "cron:" by default.
+	KeyPrefix string
+	// Location of cron expressions, time.Local by default.
+	Location *time.Location
+	// Identity of this runner, recorded in job states. hostname:pid by default.
+	Identity string
+}
+
+type scheduledJob struct {
+	name     string
+	schedule Schedule
+	job      Job
+	opts     JobOptions
+
+	mu      sync.Mutex
+	running bool
+}
+
+// Usage:
+// Create a scheduler on every replica, register the same jobs, then call
+// Start. Every activation runs on a single replica, elected by a redis lock.
+//
+// Example:
+// s, _ := pRedis.NewScheduler(pRedis.SchedulerOptions{Ctx: ctx, Pool: pool})
+// _ = s.Register("report", "0 3 * * *", func(ctx context.Context, run pRedis.JobRun) error {
+//     return buildReport(ctx, run.ScheduledAt, run.Token)
+// }, pRedis.JobOptions{MissedRuns: pRedis.MissedRunOnce})
+// go s.Start()
+
+type Scheduler struct {
+	opts SchedulerOptions
+	lock *Lock
+
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob
+	wg   sync.WaitGroup
+}
+
+func NewScheduler(opts SchedulerOptions) (*Scheduler, error) {
+	if opts.Ctx == nil {
+		return nil, errors.Errorf("context must be specified")
+	}
+	if opts.Pool == nil {
+		return nil, errors.Errorf("redis pool must be specified")
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = defaultSchedulerKeyPrefix
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if opts.Identity == "" {
+		host, _ := os.Hostname()
+		opts.Identity = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	return &Scheduler{
+		opts: opts,
+		lock: NewLock(opts.Pool),
+		jobs: map[string]*scheduledJob{},
+	}, nil
+}
+
+// Register adds a job activated by the cron expression spec, see ParseCron.
+// If name exists, the job will be replaced.
+func (s *Scheduler) Register(name, spec string, job Job, opts ...JobOptions) error {
+	schedule, err := ParseCron(spec, s.opts.Location)
+	if err != nil {
+		return err
+	}
+	var o JobOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.MaxCatchUp <= 0 {
+		o.MaxCatchUp = defaultJobMaxCatchUp
+	}
+	if o.LockSeconds <= 0 {
+		o.LockSeconds = defaultJobLockSeconds
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[name] = &scheduledJob{name: name, schedule: schedule, job: job, opts: o}
+	return nil
+}
+
+// Unregister removes the job with name, a run in progress is not stopped.
+func (s *Scheduler) Unregister(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, name)
+}
+
+// Start runs jobs until the context is done, then waits for running jobs.
+// Activations missed while no scheduler was running are handled at once
+// according to the policy of each job.
+func (s *Scheduler) Start() {
+	ctx := s.opts.Ctx
+	now := time.Now()
+	for _, j := range s.snapshot() {
+		if j.opts.MissedRuns != MissedRunSkip {
+			s.fire(ctx, j, now)
+		}
+	}
+	next := map[*scheduledJob]time.Time{}
+	for {
+		jobs := s.snapshot()
+		var wake time.Time
+		now = time.Now()
+		// forget the activations of jobs unregistered or replaced meanwhile
+		registered := make(map[*scheduledJob]bool, len(jobs))
+		for _, j := range jobs {
+			registered[j] = true
+		}
+		for j := range next {
+			if !registered[j] {
+				delete(next, j)
+			}
+		}
+		for _, j := range jobs {
+			at, ok := next[j]
+			if !ok {
+				at = j.schedule.Next(now)
+				next[j] = at
+			}
+			if !at.IsZero() && (wake.IsZero() || at.Before(wake)) {
+				wake = at
+			}
+		}
+		// without jobs, look again later for registered ones
+		wait := time.Second
+		if !wake.IsZero() {
+			wait = time.Until(wake)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.wg.Wait()
+			return
+		case <-timer.C:
+		}
+		now = time.Now()
+		for _, j := range jobs {
+			if at := next[j]; !at.IsZero() && !at.After(now) {
+				s.fire(ctx, j, at)
+				delete(next, j)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) snapshot() []*scheduledJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*scheduledJob, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+// fire runs j in background, unless it is running in this process already.
+func (s *Scheduler) fire(ctx context.Context, j *scheduledJob, at time.Time) {
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		return
+	}
+	j.running = true
+	j.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			j.mu.Lock()
+			j.running = false
+			j.mu.Unlock()
+			s.wg.Done()
+		}()
+		if err := s.run(ctx, j, at); err != nil {
+			log.WithError(err).WithField("job", j.name).Error("failed to run scheduled job")
+		}
+	}()
+}
+
+func (s *Scheduler) stateKey(name string) string {
+	return s.opts.KeyPrefix + name
+}
+
+// run elects this process to run the activations of j due at now, and runs
+// them while holding the lock of the job.
+func (s *Scheduler) run(ctx context.Context, j *scheduledJob, now time.Time) error {
+	lockKey := s.opts.KeyPrefix + j.name + ":lock"
+	token, err := s.lock.AcquireWithFence(lockKey, j.opts.LockSeconds)
+	if err != nil {
+		if errors.Cause(err) == redis.ErrNil {
+			// another runner has it
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		_ = s.lock.ReleaseWithFence(lockKey, token)
+	}()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := s.keepLock(lockKey, token, j.opts.LockSeconds, cancel)
+	defer stop()
+
+	state, err := s.State(j.name)
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	for i, run := range s.due(j, state, now) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		// the token of the lock is used by the first run, later ones need
+		// newer tokens
+		run.Token = token
+		if i > 0 {
+			if run.Token, err = s.nextToken(lockKey); err != nil {
+				return err
+			}
+		}
+		if err = s.runOnce(ctx, j, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// due returns the runs of activations after the last one recorded, up to now.
+func (s *Scheduler) due(j *scheduledJob, state JobState, now time.Time) []JobRun {
+	latest := now
+	if at := j.schedule.Next(now.Add(-time.Second)); at.IsZero() || at.After(now) {
+		// now is not an activation, e.g. at startup
+		latest = time.Time{}
+	}
+	if state.LastScheduled.IsZero() {
+		if latest.IsZero() {
+			return nil
+		}
+		return []JobRun{{Name: j.name, ScheduledAt: latest}}
+	}
+
+	var missed []time.Time
+	for t := j.schedule.Next(state.LastScheduled); !t.IsZero() && !t.After(now); t = j.schedule.Next(t) {
+		missed = append(missed, t)
+		if len(missed) > j.opts.MaxCatchUp {
+			missed = missed[1:]
+		}
+	}
+	if len(missed) == 0 {
+		return nil
+	}
+	last := missed[len(missed)-1]
+	switch j.opts.MissedRuns {
+	case MissedRunSkip:
+		if !last.Equal(latest) {
+			return nil
+		}
+		return []JobRun{{Name: j.name, ScheduledAt: last, Missed: len(missed) - 1}}
+	case MissedRunAll:
+		runs := make([]JobRun, len(missed))
+		for i, t := range missed {
+			runs[i] = JobRun{Name: j.name, ScheduledAt: t}
+		}
+		return runs
+	}
+	return []JobRun{{Name: j.name, ScheduledAt: last, Missed: len(missed) - 1}}
+}
+
+func (s *Scheduler) nextToken(lockKey string) (int64, error) {
+	conn := s.opts.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	token, err := redis.Int64(conn.Do("INCR", FenceKey(lockKey)))
+	if err != nil {
+		return 0, errors.Wrap(err, "issue fencing token failed")
+	}
+	return token, nil
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, j *scheduledJob, run JobRun) error {
+	token := run.Token
+	start := time.Now()
+	ok, err := redis.Bool(jobStartScript.Exec(s.opts.Pool, s.stateKey(j.name),
+		token, run.ScheduledAt.UnixMilli(), start.UnixMilli(), s.opts.Identity, run.Missed,
+		j.schedule.Next(run.ScheduledAt).UnixMilli(),
+	))
+	if err != nil {
+		return errors.Wrap(err, "record job start failed")
+	}
+	if !ok {
+		return nil
+	}
+
+	jobErr := s.safeRun(ctx, j, run)
+	status, message := JobSucceeded, ""
+	if jobErr != nil {
+		status, message = JobFailed, jobErr.Error()
+		log.WithError(jobErr).WithField("job", j.name).Error("scheduled job failed")
+	}
+	_, err = jobFinishScript.Exec(s.opts.Pool, s.stateKey(j.name),
+		token, status, message, time.Now().UnixMilli(), time.Since(start).Milliseconds(),
+	)
+	if err != nil {
+		return errors.Wrap(err, "record job outcome failed")
+	}
+	return nil
+}
+
+func (s *Scheduler) safeRun(ctx context.Context, j *scheduledJob, run JobRun) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+	return j.job(ctx, run)
+}
+
+// keepLock renews the lock until the returned function is called, lost is
+// called if the lock is held by another runner meanwhile.
+func (s *Scheduler) keepLock(key string, token int64, seconds int, lost func()) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Duration(seconds) * time.Second / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := s.lock.RefreshWithFence(key, token, seconds)
+				if errors.Cause(err) == ErrStaleFencingToken {
+					log.WithError(err).WithField("lock", key).Error("job lock lost, cancelling the run")
+					lost()
+					return
+				}
+				if err != nil {
+					log.WithError(err).WithField("lock", key).Error("failed to renew job lock")
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// State fetches the record of the last run of the job with name,
+// redis.ErrNil is returned if it never ran. It is read from the primary of
+// pools with replicas.
+func (s *Scheduler) State(name string) (JobState, error) {
+	conn := s.opts.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	// a replica may not have the state of the last run yet, which would run
+	// it again
+	values, err := redis.StringMap(doOnPrimary(conn, "HGETALL", s.stateKey(name)))
+	if err != nil {
+		return JobState{}, err
+	}
+	if len(values) == 0 {
+		return JobState{}, redis.ErrNil
+	}
+	ms := func(field string) time.Time {
+		v, err := strconv.ParseInt(values[field], 10, 64)
+		if err != nil || v == 0 {
+			return time.Time{}
+		}
+		return time.UnixMilli(v)
+	}
+	state := JobState{
+		Status:        values["status"],
+		Error:         values["error"],
+		Runner:        values["runner"],
+		LastScheduled: ms("last_scheduled"),
+		LastStarted:   ms("last_started"),
+		LastFinished:  ms("last_finished"),
+		NextRun:       ms("next_run"),
+	}
+	state.Token, _ = strconv.ParseInt(values["token"], 10, 64)
+	state.Missed, _ = strconv.Atoi(values["missed"])
+	if d, err := strconv.ParseInt(values["last_duration"], 10, 64); err == nil {
+		state.LastDuration = time.Duration(d) * time.Millisecond
+	}
+	return state, nil
+}
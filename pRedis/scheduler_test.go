@@ -0,0 +1,135 @@
+package pRedis_test
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+
+	// two runners share the job, every activation must run once
+	var mu sync.Mutex
+	runs := map[time.Time]int{}
+	var tokens []int64
+	job := func(ctx context.Context, run pRedis.JobRun) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs[run.ScheduledAt]++
+		tokens = append(tokens, run.Token)
+		return nil
+	}
+	var wg sync.WaitGroup
+	for _, identity := range []string{"a", "b"} {
+		s, err := pRedis.NewScheduler(pRedis.SchedulerOptions{Ctx: ctx, Pool: pool, Identity: identity})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Register("tick", "@every 1s", job); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Register("removed", "@every 1s", job); err != nil {
+			t.Fatal(err)
+		}
+		s.Unregister("removed")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Start()
+		}()
+	}
+	wg.Wait()
+
+	if len(runs) == 0 {
+		t.Fatal("the job never ran")
+	}
+	for at, n := range runs {
+		if n != 1 {
+			t.Errorf("activation %s ran %d times", at, n)
+		}
+	}
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i] <= tokens[i-1] {
+			t.Errorf("token %d after %d", tokens[i], tokens[i-1])
+		}
+	}
+
+	s, err := pRedis.NewScheduler(pRedis.SchedulerOptions{Ctx: context.Background(), Pool: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := s.State("tick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != pRedis.JobSucceeded || state.Token != tokens[len(tokens)-1] {
+		t.Fatalf("state = %+v, want the last run succeeded", state)
+	}
+	if state, err = s.State("removed"); err != redis.ErrNil {
+		t.Fatalf("State of the unregistered job = %+v, %v, want ErrNil", state, err)
+	}
+}
+
+func TestSchedulerStartScript(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	script, ok := pRedis.LookupScript("pRedis:scheduler:start")
+	if !ok {
+		t.Fatal("no start script")
+	}
+	// token, scheduled at, started at, runner, missed, next run
+	start := func(token, scheduledAt int64) bool {
+		t.Helper()
+		ok, err := redis.Bool(script.Exec(pool, "cron:job", token, scheduledAt, 0, "a", 0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	if !start(1, 1000) {
+		t.Fatal("the first run was not recorded")
+	}
+	if start(1, 2000) {
+		t.Fatal("a run with the same token was recorded")
+	}
+	// a runner which read a stale state computes an activation again
+	if start(2, 1000) {
+		t.Fatal("an activation which already ran was recorded again")
+	}
+	if !start(3, 2000) {
+		t.Fatal("the next activation was not recorded")
+	}
+}
+
+func TestSchedulerStateReadsPrimary(t *testing.T) {
+	primary, replica := newTestServer(t), newTestServer(t)
+	conn := newTestPool(t, primary, nil).Get()
+	_, err := conn.Do("HSET", "cron:job", "token", 7, "status", pRedis.JobSucceeded)
+	_ = conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pRedis.InitMultiPools([]*pRedis.MultiDialConfig{{
+		Name:     "test-scheduler-replica",
+		Config:   primary.DialConfig(),
+		Replicas: []*pRedis.DialConfig{replica.DialConfig()},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fake does not replicate, the state is on the primary only
+	s, err := pRedis.NewScheduler(pRedis.SchedulerOptions{Ctx: context.Background(), Pool: pRedis.PoolRef("test-scheduler-replica")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := s.State("job")
+	if err != nil || state.Token != 7 {
+		t.Fatalf("State = %+v, %v, want the state of the primary", state, err)
+	}
+}
@@ -0,0 +1,175 @@
+package pRedis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+var scripts = sync.Map{}
+
+// Script
+//
+// A Lua script declared once by RegisterScript. Pools created by NewPool check
+// with `SCRIPT EXISTS` on every new connection that registered scripts are on
+// the server, and load the missing ones by `SCRIPT LOAD`. Scripts are
+// evaluated by `EVALSHA`, falling back to `EVAL` if redis answers NOSCRIPT
+// (e.g. after a `SCRIPT FLUSH` or a failover, or when redis refused to load
+// them).
+type Script struct {
+	name   string
+	src    string
+	script *redis.Script
+}
+
+// RegisterScript
+//
+// Declare a Lua script with given name. keyCount has the same meaning as in
+// redis.NewScript. If this name exists, the script will be replaced.
+func RegisterScript(name string, keyCount int, src string) *Script {
+	s := &Script{
+		name:   name,
+		src:    src,
+		script: redis.NewScript(keyCount, src),
+	}
+	scripts.Store(name, s)
+	return s
+}
+
+// LookupScript
+//
+// Fetch a script registered by RegisterScript.
+func LookupScript(name string) (*Script, bool) {
+	s, ok := scripts.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return s.(*Script), true
+}
+
+// LoadScripts
+//
+// Load every registered script into the server of conn with `SCRIPT LOAD`.
+// Commands are pipelined, so it costs a single round trip.
+func LoadScripts(conn redis.Conn) error {
+	n := 0
+	var err error
+	scripts.Range(func(_, v interface{}) bool {
+		if err = conn.Send("SCRIPT", "LOAD", v.(*Script).src); err != nil {
+			return false
+		}
+		n++
+		return true
+	})
+	if err != nil {
+		return errors.Wrap(err, "load scripts failed")
+	}
+	if n == 0 {
+		return nil
+	}
+	if err = conn.Flush(); err != nil {
+		return errors.Wrap(err, "load scripts failed")
+	}
+	for i := 0; i < n; i++ {
+		if _, e := conn.Receive(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "load scripts failed")
+	}
+	return nil
+}
+
+// loadScripts loads the registered scripts missing on the server of conn. A
+// script redis refuses to load, e.g. because of an ACL or a proxy, is logged
+// and left to the `EVAL` fallback of Do, only connection failures are returned.
+func loadScripts(conn redis.Conn) error {
+	var all []*Script
+	scripts.Range(func(_, v interface{}) bool {
+		all = append(all, v.(*Script))
+		return true
+	})
+	if len(all) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(all)+1)
+	args = append(args, "EXISTS")
+	for _, s := range all {
+		args = append(args, s.Hash())
+	}
+	exists, err := redis.Ints(conn.Do("SCRIPT", args...))
+	if err != nil {
+		if _, ok := err.(redis.Error); ok {
+			log.WithError(err).Warn("redis scripts not loaded, they are evaluated by EVAL")
+			return nil
+		}
+		return errors.Wrap(err, "load scripts failed")
+	}
+	var missing []*Script
+	for i, s := range all {
+		if i < len(exists) && exists[i] == 0 {
+			missing = append(missing, s)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	for _, s := range missing {
+		if err = conn.Send("SCRIPT", "LOAD", s.src); err != nil {
+			return errors.Wrap(err, "load scripts failed")
+		}
+	}
+	if err = conn.Flush(); err != nil {
+		return errors.Wrap(err, "load scripts failed")
+	}
+	for _, s := range missing {
+		if _, err = conn.Receive(); err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				return errors.Wrap(err, "load scripts failed")
+			}
+			log.WithError(err).WithField("script", s.name).Warn("redis script not loaded, it is evaluated by EVAL")
+		}
+	}
+	return nil
+}
+
+func (s *Script) Name() string {
+	return s.name
+}
+
+func (s *Script) Src() string {
+	return s.src
+}
+
+func (s *Script) Hash() string {
+	return s.script.Hash()
+}
+
+// Load loads the script with `SCRIPT LOAD` without evaluating it.
+func (s *Script) Load(conn redis.Conn) error {
+	return s.script.Load(conn)
+}
+
+// Do evaluates the script with `EVALSHA`, and with `EVAL` if the script is
+// not loaded yet.
+func (s *Script) Do(conn redis.Conn, keysAndArgs ...interface{}) (interface{}, error) {
+	return s.script.Do(conn, keysAndArgs...)
+}
+
+// Send evaluates the script with `EVALSHA` without waiting for the reply, the
+// script should have been loaded before, which pools created by NewPool do
+// unless redis refused it.
+func (s *Script) Send(conn redis.Conn, keysAndArgs ...interface{}) error {
+	return s.script.SendHash(conn, keysAndArgs...)
+}
+
+// Exec gets a connection from pool and evaluates the script on it.
+func (s *Script) Exec(pool *redis.Pool, keysAndArgs ...interface{}) (interface{}, error) {
+	conn := pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	return s.Do(conn, keysAndArgs...)
+}
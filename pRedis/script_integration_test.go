@@ -0,0 +1,47 @@
+//go:build integration
+
+package pRedis_test
+
+import (
+	"fmt"
+	"github.com/zzj-custom/pkg/pRedis"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBuiltinScriptsOnRedis runs the Lua of the scripts of pRedis on the redis
+// at REDIS_ADDR, e.g.
+//
+//	REDIS_ADDR=localhost:6379 go test -tags integration -run OnRedis ./pRedis
+//
+// The fake only runs their emulations, see pRedisFake.Server.
+func TestBuiltinScriptsOnRedis(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR is not set")
+	}
+	host, port, _ := strings.Cut(addr, ":")
+	config := &pRedis.DialConfig{Host: host, Port: 6379, ConnectTimeout: time.Second, ReadTimeout: time.Second}
+	if port != "" {
+		var err error
+		if config.Port, err = strconv.Atoi(port); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// keys of their own, so that the steps start from scratch
+	config.KeyPrefix = fmt.Sprintf("pRedis-test:%d:", time.Now().UnixNano())
+	pool, err := pRedis.NewPool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	defer func() {
+		conn := pool.Get()
+		defer conn.Close()
+		_, _ = conn.Do("DEL", "lock", "lock:fence", "req", "board", "job")
+	}()
+	runBuiltinScriptSteps(t, pool)
+}
@@ -0,0 +1,204 @@
+package pRedis_test
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"sync/atomic"
+	"testing"
+)
+
+var testIncrScript = pRedis.RegisterScript("pRedis_test:incr", 1, `
+return redis.call('INCRBY', KEYS[1], ARGV[1])
+`)
+
+func emulateIncr(call func(cmd string, args ...string) (interface{}, error), keys, args []string) (interface{}, error) {
+	return call("INCRBY", keys[0], args[0])
+}
+
+func TestScript(t *testing.T) {
+	srv := newTestServer(t)
+	srv.RegisterScript(testIncrScript.Src(), emulateIncr)
+	pool := newTestPool(t, srv, func(config *pRedis.DialConfig) { config.KeyPrefix = "app:" })
+
+	if script, ok := pRedis.LookupScript("pRedis_test:incr"); !ok || script != testIncrScript {
+		t.Fatal("LookupScript did not find the registered script")
+	}
+	for _, want := range []int{2, 4} {
+		n, err := redis.Int(testIncrScript.Exec(pool, "n", 2))
+		if err != nil || n != want {
+			t.Fatalf("Exec = %d, %v, want %d", n, err, want)
+		}
+	}
+
+	raw := newTestPool(t, srv, nil).Get()
+	defer raw.Close()
+	if n, _ := redis.Int(raw.Do("GET", "app:n")); n != 4 {
+		t.Fatalf("GET app:n = %d, want the key of the script prefixed", n)
+	}
+}
+
+func TestScriptPipeline(t *testing.T) {
+	srv := newTestServer(t)
+	srv.RegisterScript(testIncrScript.Src(), emulateIncr)
+	conn := newTestPool(t, srv, nil).Get()
+	defer conn.Close()
+
+	if err := pRedis.LoadScripts(conn); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := testIncrScript.Send(conn, "n", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for want := 1; want <= 3; want++ {
+		if n, err := redis.Int(conn.Receive()); err != nil || n != want {
+			t.Fatalf("Receive = %d, %v, want %d", n, err, want)
+		}
+	}
+}
+
+func TestScriptLoadedOnce(t *testing.T) {
+	srv := newTestServer(t)
+	srv.RegisterScript(testIncrScript.Src(), emulateIncr)
+	var loads int32
+	count := pRedis.Hook{
+		Send: func(next pRedis.SendFunc) pRedis.SendFunc {
+			return func(cmd string, args ...interface{}) error {
+				if cmd == "SCRIPT" && args[0] == "LOAD" {
+					atomic.AddInt32(&loads, 1)
+				}
+				return next(cmd, args...)
+			}
+		},
+	}
+	pool := newTestPool(t, srv, func(config *pRedis.DialConfig) { config.Hooks = []pRedis.Hook{count} })
+
+	first, second := pool.Get(), pool.Get()
+	defer first.Close()
+	defer second.Close()
+	if err := first.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Err(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := atomic.LoadInt32(&loads)
+	if loaded == 0 {
+		t.Fatal("the first connection loaded no script")
+	}
+	third := pool.Get()
+	defer third.Close()
+	if err := third.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&loads); n != loaded {
+		t.Fatalf("%d scripts loaded again by a new connection, want none", n-loaded)
+	}
+}
+
+func TestScriptDenied(t *testing.T) {
+	srv := newTestServer(t)
+	srv.RegisterScript(testIncrScript.Src(), emulateIncr)
+	// like an ACL with -@scripting, which still allows EVAL of the fallback
+	deny := pRedis.FaultHook(func(cmd string, args []interface{}) error {
+		if cmd == "SCRIPT" {
+			return redis.Error("NOPERM this user has no permissions to run the 'script' command")
+		}
+		return nil
+	})
+	pool := newTestPool(t, srv, func(config *pRedis.DialConfig) { config.Hooks = []pRedis.Hook{deny} })
+
+	for _, want := range []int{1, 2} {
+		n, err := redis.Int(testIncrScript.Exec(pool, "n", 1))
+		if err != nil || n != want {
+			t.Fatalf("Exec = %d, %v, want %d", n, err, want)
+		}
+	}
+}
+
+// builtinScriptSteps run the scripts of pRedis, or plain commands to check
+// their effects, with the replies they must give in turn. The fake runs the
+// emulations of the scripts, TestBuiltinScriptsOnRedis runs the same steps
+// against the Lua.
+var builtinScriptSteps = []struct {
+	name string
+	args []interface{}
+	want string
+}{
+	{"pRedis:lock:acquire", []interface{}{"lock", "lock:fence", 10}, "1"},
+	{"pRedis:lock:acquire", []interface{}{"lock", "lock:fence", 10}, "<nil>"},
+	{"pRedis:lock:refresh", []interface{}{"lock", 2, 10}, "0"},
+	{"pRedis:lock:refresh", []interface{}{"lock", 1, 20}, "1"},
+	{"TTL", []interface{}{"lock"}, "20"},
+	{"pRedis:lock:release", []interface{}{"lock", 2}, "0"},
+	{"pRedis:lock:release", []interface{}{"lock", 1}, "1"},
+	{"pRedis:lock:acquire", []interface{}{"lock", "lock:fence", 10}, "2"},
+
+	{"pRedis:idempotency:begin", []interface{}{"req", "processing", 60000, "t1"}, "[]"},
+	{"pRedis:idempotency:begin", []interface{}{"req", "processing", 60000, "t2"}, "[processing <nil>]"},
+	{"pRedis:idempotency:complete", []interface{}{"req", "t2", "completed", "done", 60000}, "0"},
+	{"pRedis:idempotency:abort", []interface{}{"req", "t1", "completed"}, "0"},
+	{"pRedis:idempotency:complete", []interface{}{"req", "t1", "completed", "done", 120000}, "1"},
+	{"pRedis:idempotency:begin", []interface{}{"req", "processing", 60000, "t3"}, "[completed done]"},
+	{"TTL", []interface{}{"req"}, "120"},
+	{"pRedis:idempotency:abort", []interface{}{"req", "t1", "completed"}, "1"},
+	{"EXISTS", []interface{}{"req"}, "0"},
+
+	{"pRedis:leaderboard:incr", []interface{}{"board", "m", 5, 0.25}, "5"},
+	{"pRedis:leaderboard:incr", []interface{}{"board", "m", 2, 0.5}, "7"},
+	{"ZSCORE", []interface{}{"board", "m"}, "7.5"},
+
+	{"pRedis:scheduler:start", []interface{}{"job", 1, 1000, 1, "a", 0, 2000}, "1"},
+	{"pRedis:scheduler:start", []interface{}{"job", 2, 1000, 1, "b", 0, 2000}, "0"},
+	{"pRedis:scheduler:start", []interface{}{"job", 1, 2000, 1, "b", 0, 3000}, "0"},
+	{"pRedis:scheduler:finish", []interface{}{"job", 2, "succeeded", "", 3, 4}, "0"},
+	{"pRedis:scheduler:finish", []interface{}{"job", 1, "succeeded", "", 3, 4}, "1"},
+	{"HMGET", []interface{}{"job", "token", "last_scheduled", "runner", "status", "next_run"}, "[1 1000 a succeeded 2000]"},
+}
+
+// runBuiltinScriptSteps runs builtinScriptSteps on pool, which must hold none
+// of their keys.
+func runBuiltinScriptSteps(t *testing.T, pool *redis.Pool) {
+	t.Helper()
+	conn := pool.Get()
+	defer conn.Close()
+	for i, step := range builtinScriptSteps {
+		var reply interface{}
+		var err error
+		if script, ok := pRedis.LookupScript(step.name); ok {
+			reply, err = script.Do(conn, step.args...)
+		} else {
+			reply, err = conn.Do(step.name, step.args...)
+		}
+		if err != nil {
+			t.Fatalf("#%d %s: %v", i, step.name, err)
+		}
+		if got := fmt.Sprint(replyStrings(reply)); got != step.want {
+			t.Fatalf("#%d %s = %s, want %s", i, step.name, got, step.want)
+		}
+	}
+}
+
+// replyStrings converts the bulk strings of reply to strings.
+func replyStrings(reply interface{}) interface{} {
+	switch reply := reply.(type) {
+	case []byte:
+		return string(reply)
+	case []interface{}:
+		values := make([]interface{}, len(reply))
+		for i, v := range reply {
+			values[i] = replyStrings(v)
+		}
+		return values
+	}
+	return reply
+}
+
+func TestBuiltinScriptsOnFake(t *testing.T) {
+	runBuiltinScriptSteps(t, newTestPool(t, newTestServer(t), nil))
+}
@@ -0,0 +1,431 @@
+package pRedis
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionKeyPrefix  = "session:"
+	defaultSessionTTL        = 30 * time.Minute
+	defaultSessionCookieName = "session_id"
+	sessionIDBytes           = 32
+)
+
+// ErrSessionNotFound is returned by Load when the session does not exist or
+// expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+type SessionOptions struct {
+	Pool *redis.Pool
+	// KeyPrefix of the sessions, "session:" by default.
+	KeyPrefix string
+	// TTL is the idle timeout of a session, it is renewed on every load.
+	// 30 minutes by default.
+	TTL time.Duration
+	// EncryptionKey enables AES-GCM encryption of the stored payload when set,
+	// it must be 16, 24 or 32 bytes long.
+	EncryptionKey []byte
+	// Codec serializes session values, DefaultCodec if nil. Beware that
+	// JSONCodec decodes numbers as float64, and GobCodec needs value types to
+	// be registered by gob.Register.
+	Codec Codec
+
+	// Cookie used by Middleware, named "session_id" by default.
+	CookieName     string
+	CookiePath     string
+	CookieDomain   string
+	CookieSecure   bool
+	CookieSameSite http.SameSite
+}
+
+type sessionData struct {
+	Values    map[string]interface{}
+	CreatedAt time.Time
+}
+
+// Session
+//
+// Values of a client kept in redis. Values are changed in memory, and stored
+// by `SessionStore.Save`, or when the response is written if the session was
+// given by Middleware.
+type Session struct {
+	ID        string
+	CreatedAt time.Time
+
+	mu        sync.Mutex
+	values    map[string]interface{}
+	isNew     bool
+	stored    bool
+	modified  bool
+	destroyed bool
+}
+
+// Get returns the value of key, nil if not set.
+func (s *Session) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Set sets the value of key.
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete removes key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.modified = true
+}
+
+// Values returns a copy of every value.
+func (s *Session) Values() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string]interface{}, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	return values
+}
+
+// IsNew tells whether the session was created by this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Destroy marks the session to be destroyed by Middleware.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// Usage:
+// Wrap handlers by Middleware, then fetch the session of the request by
+// SessionFromContext. Sessions are stored only once a value is set.
+//
+// Example:
+// store, _ := pRedis.NewSessionStore(pRedis.SessionOptions{Pool: pool, CookieSecure: true})
+// http.Handle("/", store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//     session := pRedis.SessionFromContext(r.Context())
+//     session.Set("user", 42)
+// })))
+
+type SessionStore struct {
+	opts SessionOptions
+	aead cipher.AEAD
+}
+
+func NewSessionStore(opts SessionOptions) (*SessionStore, error) {
+	if opts.Pool == nil {
+		return nil, errors.Errorf("redis pool must be specified")
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = defaultSessionKeyPrefix
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultSessionTTL
+	}
+	if opts.Codec == nil {
+		opts.Codec = DefaultCodec
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = defaultSessionCookieName
+	}
+	if opts.CookiePath == "" {
+		opts.CookiePath = "/"
+	}
+	store := &SessionStore{opts: opts}
+	if len(opts.EncryptionKey) > 0 {
+		block, err := aes.NewCipher(opts.EncryptionKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid encryption key")
+		}
+		if store.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, errors.Wrap(err, "invalid encryption key")
+		}
+	}
+	return store, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, sessionIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate session id failed")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (st *SessionStore) key(id string) string {
+	return st.opts.KeyPrefix + id
+}
+
+// New returns a new session with a random id, it is not stored until saved.
+func (st *SessionStore) New() (*Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:        id,
+		CreatedAt: time.Now(),
+		values:    map[string]interface{}{},
+		isNew:     true,
+	}, nil
+}
+
+// Create returns a new session and stores it at once.
+func (st *SessionStore) Create() (*Session, error) {
+	s, err := st.New()
+	if err != nil {
+		return nil, err
+	}
+	if err = st.Save(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load fetches the session with id and renews its ttl, ErrSessionNotFound is
+// returned if it does not exist.
+func (st *SessionStore) Load(id string) (*Session, error) {
+	if id == "" {
+		return nil, ErrSessionNotFound
+	}
+	b := NewBatch(st.opts.Pool)
+	payload := b.Queue("GET", st.key(id))
+	b.Queue("PEXPIRE", st.key(id), ttlMillis(st.opts.TTL))
+	if err := b.Exec(); err != nil {
+		return nil, errors.Wrap(err, "load session failed")
+	}
+	data, err := payload.Bytes()
+	if err == redis.ErrNil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "load session failed")
+	}
+	if data, err = st.decrypt(data); err != nil {
+		return nil, err
+	}
+	var sd sessionData
+	if err = st.opts.Codec.Unmarshal(data, &sd); err != nil {
+		return nil, errors.Wrap(err, "decode session failed")
+	}
+	if sd.Values == nil {
+		sd.Values = map[string]interface{}{}
+	}
+	return &Session{ID: id, CreatedAt: sd.CreatedAt, values: sd.Values}, nil
+}
+
+// Save stores the session and renews its ttl.
+func (st *SessionStore) Save(s *Session) error {
+	s.mu.Lock()
+	data, err := st.opts.Codec.Marshal(sessionData{Values: s.values, CreatedAt: s.CreatedAt})
+	s.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "encode session failed")
+	}
+	if data, err = st.encrypt(data); err != nil {
+		return err
+	}
+	conn := st.opts.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err = conn.Do("SET", st.key(s.ID), data, "PX", ttlMillis(st.opts.TTL)); err != nil {
+		return errors.Wrap(err, "save session failed")
+	}
+	s.mu.Lock()
+	s.modified = false
+	s.stored = true
+	s.mu.Unlock()
+	return nil
+}
+
+// Destroy removes the session with id.
+func (st *SessionStore) Destroy(id string) error {
+	conn := st.opts.Pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Do("DEL", st.key(id)); err != nil {
+		return errors.Wrap(err, "destroy session failed")
+	}
+	return nil
+}
+
+// Regenerate moves the session to a new id, call it when the privilege
+// level changes (e.g. after login) to prevent session fixation.
+func (st *SessionStore) Regenerate(s *Session) error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	old := s.ID
+	s.mu.Lock()
+	s.ID = id
+	s.isNew = true
+	s.mu.Unlock()
+	if err = st.Save(s); err != nil {
+		return err
+	}
+	if old != "" {
+		return st.Destroy(old)
+	}
+	return nil
+}
+
+func (st *SessionStore) encrypt(data []byte) ([]byte, error) {
+	if st.aead == nil {
+		return data, nil
+	}
+	nonce := make([]byte, st.aead.NonceSize(), st.aead.NonceSize()+len(data)+st.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "encrypt session failed")
+	}
+	return st.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (st *SessionStore) decrypt(data []byte) ([]byte, error) {
+	if st.aead == nil {
+		return data, nil
+	}
+	if len(data) < st.aead.NonceSize() {
+		return nil, errors.Errorf("decrypt session failed: payload too short")
+	}
+	nonce, sealed := data[:st.aead.NonceSize()], data[st.aead.NonceSize():]
+	plain, err := st.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt session failed")
+	}
+	return plain, nil
+}
+
+type sessionContextKey struct{}
+
+// SessionFromContext returns the session put in ctx by Middleware, nil if
+// there is none.
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return s
+}
+
+// Middleware loads the session of the request from its cookie, or starts a
+// new one, and makes it available by SessionFromContext. The session is saved
+// and the cookie set right before the response headers are written, if it was
+// modified or destroyed.
+func (st *SessionStore) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var s *Session
+		if cookie, err := r.Cookie(st.opts.CookieName); err == nil {
+			s, err = st.Load(cookie.Value)
+			if err != nil && err != ErrSessionNotFound {
+				log.WithError(err).Error("failed to load session")
+			}
+		}
+		if s == nil {
+			var err error
+			if s, err = st.New(); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		sw := &sessionResponseWriter{ResponseWriter: w, store: st, session: s}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, s)))
+		sw.commit()
+	})
+}
+
+func (st *SessionStore) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     st.opts.CookieName,
+		Value:    value,
+		Path:     st.opts.CookiePath,
+		Domain:   st.opts.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   st.opts.CookieSecure,
+		HttpOnly: true,
+		SameSite: st.opts.CookieSameSite,
+	}
+}
+
+// sessionResponseWriter saves the session before the headers are sent, since
+// the cookie cannot be set afterwards.
+type sessionResponseWriter struct {
+	http.ResponseWriter
+	store     *SessionStore
+	session   *Session
+	committed bool
+}
+
+func (w *sessionResponseWriter) commit() {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	s := w.session
+	s.mu.Lock()
+	destroyed, modified, isNew, stored := s.destroyed, s.modified, s.isNew, s.stored
+	s.mu.Unlock()
+	if destroyed {
+		if !isNew || stored {
+			if err := w.store.Destroy(s.ID); err != nil {
+				log.WithError(err).Error("failed to destroy session")
+			}
+		}
+		http.SetCookie(w.ResponseWriter, w.store.cookie("", -1))
+		return
+	}
+	if modified {
+		if err := w.store.Save(s); err != nil {
+			log.WithError(err).Error("failed to save session")
+			return
+		}
+		stored = true
+	}
+	// the client learns the id of a new session, or of a regenerated one
+	if isNew && stored {
+		http.SetCookie(w.ResponseWriter, w.store.cookie(s.ID, 0))
+	}
+}
+
+func (w *sessionResponseWriter) WriteHeader(code int) {
+	w.commit()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionResponseWriter) Write(b []byte) (int, error) {
+	w.commit()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionResponseWriter) Flush() {
+	w.commit()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *sessionResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
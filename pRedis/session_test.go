@@ -0,0 +1,145 @@
+package pRedis_test
+
+import (
+	"github.com/zzj-custom/pkg/pRedis"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	srv := newTestServer(t)
+	pool := newTestPool(t, srv, nil)
+	store, err := pRedis.NewSessionStore(pRedis.SessionOptions{
+		Pool:          pool,
+		TTL:           time.Minute,
+		EncryptionKey: []byte("0123456789abcdef"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("user", "alice")
+	if err = store.Save(s); err != nil {
+		t.Fatal(err)
+	}
+	conn := pool.Get()
+	stored, err := conn.Do("GET", "session:"+s.ID)
+	_ = conn.Close()
+	if err != nil || stored == nil {
+		t.Fatalf("GET session = %v, %v, want the stored session", stored, err)
+	}
+
+	loaded, err := store.Load(s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Get("user") != "alice" || loaded.IsNew() {
+		t.Fatalf("Load = %+v, want the saved values", loaded.Values())
+	}
+
+	// loading renews the ttl
+	srv.Advance(40 * time.Second)
+	if _, err = store.Load(s.ID); err != nil {
+		t.Fatal(err)
+	}
+	srv.Advance(40 * time.Second)
+	if _, err = store.Load(s.ID); err != nil {
+		t.Fatalf("Load after the renewed ttl = %v", err)
+	}
+	srv.Advance(2 * time.Minute)
+	if _, err = store.Load(s.ID); err != pRedis.ErrSessionNotFound {
+		t.Fatalf("Load of an expired session = %v, want ErrSessionNotFound", err)
+	}
+
+	s, err = store.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := s.ID
+	if err = store.Regenerate(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Load(old); err != pRedis.ErrSessionNotFound {
+		t.Fatalf("Load of the regenerated id = %v, want ErrSessionNotFound", err)
+	}
+	if _, err = store.Load(s.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	store, err := pRedis.NewSessionStore(pRedis.SessionOptions{Pool: newTestPool(t, newTestServer(t), nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := pRedis.SessionFromContext(r.Context())
+		switch r.URL.Path {
+		case "/login":
+			s.Set("user", "alice")
+		case "/logout":
+			s.Destroy()
+		}
+		user, _ := s.Get("user").(string)
+		_, _ = w.Write([]byte(user))
+	}))
+	serve := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("/"); len(w.Result().Cookies()) != 0 {
+		t.Fatalf("cookies = %v, want none for an unmodified session", w.Result().Cookies())
+	}
+	w := serve("/login")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session_id" || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %v, want the session cookie", cookies)
+	}
+	if w = serve("/", cookies[0]); w.Body.String() != "alice" {
+		t.Fatalf("body = %q, want the value of the session", w.Body.String())
+	}
+	w = serve("/logout", cookies[0])
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Fatalf("cookies = %v, want the cookie removed", c)
+	}
+	if w = serve("/", cookies[0]); w.Body.String() != "" {
+		t.Fatalf("body = %q, want the session destroyed", w.Body.String())
+	}
+}
+
+func TestSessionTTLBelowAMillisecond(t *testing.T) {
+	srv := newTestServer(t)
+	store, err := pRedis.NewSessionStore(pRedis.SessionOptions{Pool: newTestPool(t, srv, nil), TTL: 500 * time.Microsecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save(s); err != nil {
+		t.Fatalf("Save with a ttl below a millisecond = %v, want it rounded up", err)
+	}
+	// Load renews the ttl, it must not expire the session at once
+	for i := 0; i < 2; i++ {
+		if _, err = store.Load(s.ID); err != nil {
+			t.Fatalf("Load #%d = %v, want the saved session", i, err)
+		}
+	}
+	srv.Advance(time.Millisecond)
+	if _, err = store.Load(s.ID); err != pRedis.ErrSessionNotFound {
+		t.Fatalf("Load after the ttl = %v, want ErrSessionNotFound", err)
+	}
+}
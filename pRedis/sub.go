@@ -6,6 +6,7 @@ import (
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,26 +37,46 @@ type Subscription struct {
 	RestartDuration time.Duration
 	PingDuration    time.Duration
 
-	restartTicker *time.Ticker
-	pingTicker    *time.Ticker
-
 	channel   string
+	pattern   bool
 	processor SubscriptionProcessor
-	psc       redis.PubSubConn
+
+	// mu guards psc, the connection of the running receive loop, so that
+	// Start pings and unsubscribes on it while the loop may be closing it.
+	mu       sync.Mutex
+	psc      redis.PubSubConn
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
+// Stop makes Start unsubscribe, and return once the receive loop exited.
 func (r *Subscription) Stop() {
-	defer func() {
-		_ = r.psc.Close()
-	}()
-	r.pingTicker.Stop()
-	r.restartTicker.Stop()
-	r.pingTicker = nil
-	r.restartTicker = nil
+	r.stopOnce.Do(func() {
+		close(r.stopChan())
+	})
+}
+
+func (r *Subscription) stopChan() chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop == nil {
+		r.stop = make(chan struct{})
+	}
+	return r.stop
+}
+
+// PSubscribe is like Subscribe, but listens to every channel matching pattern.
+func (r *Subscription) PSubscribe(pattern string, processor SubscriptionProcessor) error {
+	if err := r.Subscribe(pattern, processor); err != nil {
+		return err
+	}
+	r.pattern = true
+	return nil
 }
 
 func (r *Subscription) Subscribe(channel string, processor SubscriptionProcessor) error {
 	r.channel = channel
+	r.pattern = false
 	r.processor = processor
 	if r.RestartDuration == 0 {
 		r.RestartDuration = defaultRestartDuration
@@ -66,24 +87,38 @@ func (r *Subscription) Subscribe(channel string, processor SubscriptionProcessor
 	if r.channel == "" || r.processor == nil {
 		return errors.Errorf("channel and processor must be not nil")
 	}
-	r.restartTicker = time.NewTicker(r.RestartDuration)
-	r.pingTicker = time.NewTicker(r.PingDuration)
 	return nil
 }
 
-func (r *Subscription) subscribe() error {
-	conn := r.RedisPool.Get()
-	r.psc = redis.PubSubConn{Conn: conn}
-	defer func() {
-		_ = r.psc.Close()
-	}()
-
-	if err := r.psc.Subscribe(r.channel); err != nil {
-		return errors.Wrap(err, "subscribe failed")
+// subscribe subscribes on a new connection, which becomes the one of the
+// receive loop.
+func (r *Subscription) subscribe() (redis.PubSubConn, error) {
+	psc := redis.PubSubConn{Conn: r.RedisPool.Get()}
+	subscribe := psc.Subscribe
+	if r.pattern {
+		subscribe = psc.PSubscribe
+	}
+	if err := subscribe(r.channel); err != nil {
+		_ = psc.Close()
+		return psc, errors.Wrap(err, "subscribe failed")
 	}
+	r.mu.Lock()
+	r.psc = psc
+	r.mu.Unlock()
+	return psc, nil
+}
 
+// receive processes the messages of psc until it is unsubscribed or broken,
+// then closes it.
+func (r *Subscription) receive(psc redis.PubSubConn) error {
+	defer func() {
+		r.mu.Lock()
+		r.psc = redis.PubSubConn{}
+		_ = psc.Close()
+		r.mu.Unlock()
+	}()
 	for {
-		switch n := r.psc.Receive().(type) {
+		switch n := psc.Receive().(type) {
 		case redis.Message:
 			r.processor(r.Context, n)
 		case error:
@@ -92,8 +127,9 @@ func (r *Subscription) subscribe() error {
 				log.WithError(n).Info("连接已断开，退出。")
 				return nil
 			}
+			return errors.Wrap(n, "receive failed")
 		case redis.Subscription:
-			if n.Kind == "unsubscribe" {
+			if n.Kind == "unsubscribe" || n.Kind == "punsubscribe" {
 				return nil
 			}
 		default:
@@ -102,28 +138,77 @@ func (r *Subscription) subscribe() error {
 	}
 }
 
+// unsubscribe makes the receive loop exit, if it runs.
+func (r *Subscription) unsubscribe() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.psc.Conn == nil {
+		return
+	}
+	if r.pattern {
+		_ = r.psc.PUnsubscribe()
+	} else {
+		_ = r.psc.Unsubscribe()
+	}
+}
+
+func (r *Subscription) ping() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.psc.Conn != nil {
+		_ = r.psc.Ping("PING")
+	}
+}
+
+// Start runs the receive loop until the context is done or Stop is called,
+// resubscribing on a new connection every RestartDuration, or a second after
+// the connection broke.
 func (r *Subscription) Start() {
-	go func() {
-		err := r.subscribe()
-		if err != nil {
-			log.Error("failed to subscribe: ", r.channel, ", error:", err)
-		}
-	}()
+	restartTicker := time.NewTicker(r.RestartDuration)
+	defer restartTicker.Stop()
+	pingTicker := time.NewTicker(r.PingDuration)
+	defer pingTicker.Stop()
+	stop := r.stopChan()
+
 	for {
-		select {
-		case <-r.Context.Done():
-			r.Stop()
-			return
-		case <-r.restartTicker.C:
+		done := make(chan error, 1)
+		if psc, err := r.subscribe(); err != nil {
+			done <- err
+		} else {
 			go func() {
-				r.pingTicker.Stop()
-				_ = r.psc.Unsubscribe()
-				time.Sleep(1 * time.Second)
-				r.pingTicker.Reset(r.PingDuration)
-				_ = r.subscribe()
+				done <- r.receive(psc)
 			}()
-		case <-r.pingTicker.C:
-			r.psc.Ping("PING")
+		}
+		restart := false
+		for !restart {
+			select {
+			case <-r.Context.Done():
+				r.unsubscribe()
+				<-done
+				return
+			case <-stop:
+				r.unsubscribe()
+				<-done
+				return
+			case <-restartTicker.C:
+				r.unsubscribe()
+				<-done
+				restart = true
+			case err := <-done:
+				if err != nil {
+					log.Error("failed to subscribe: ", r.channel, ", error:", err)
+				}
+				select {
+				case <-r.Context.Done():
+					return
+				case <-stop:
+					return
+				case <-time.After(1 * time.Second):
+				}
+				restart = true
+			case <-pingTicker.C:
+				r.ping()
+			}
 		}
 	}
 }
@@ -0,0 +1,67 @@
+package pRedis_test
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"github.com/zzj-custom/pkg/pRedis"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscription(t *testing.T) {
+	pool := newTestPool(t, newTestServer(t), nil)
+	sub, err := pRedis.NewSubscription(pRedis.Options{
+		Ctx:             context.Background(),
+		Pool:            pool,
+		RestartDuration: 30 * time.Millisecond,
+		PingDuration:    5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	received := map[string]bool{}
+	err = sub.Subscribe("news", func(ctx context.Context, msg redis.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received[string(msg.Data)] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sub.Start()
+	}()
+
+	// messages keep coming across restarts, pings are sent meanwhile
+	conn := pool.Get()
+	defer conn.Close()
+	publish := func(data string) func() bool {
+		return func() bool {
+			if _, err := conn.Do("PUBLISH", "news", data); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			return received[data]
+		}
+	}
+	waitFor(t, "the first message", publish("first"))
+	time.Sleep(100 * time.Millisecond)
+	waitFor(t, "a message after restarts", publish("restarted"))
+
+	sub.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+	if stats := pool.Stats(); stats.ActiveCount-stats.IdleCount != 1 {
+		t.Fatalf("%d connections borrowed after Stop, want the one of the test", stats.ActiveCount-stats.IdleCount)
+	}
+}
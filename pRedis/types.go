@@ -4,6 +4,12 @@ import (
 	"time"
 )
 
+// DialConfig
+//
+// ConnectTimeout, ReadTimeout, MaxConnLifetime and IdleTimeout are durations,
+// e.g. `3 * time.Second`. They used to be numbers of seconds, so NewPool
+// rejects values below a millisecond, which are most likely such numbers. See
+// LoadConfigs for their format in files.
 type DialConfig struct {
 	Host            string        `toml:"host" json:"host,omitempty" yaml:"host" mapstructure:"host"`
 	Port            int           `toml:"port" json:"port,omitempty" yaml:"port" mapstructure:"port"`
@@ -16,10 +22,27 @@ type DialConfig struct {
 	ReadTimeout     time.Duration `toml:"read-timeout" json:"read-timeout,omitempty" yaml:"read-timeout" mapstructure:"read-timeout"`
 	MaxConnLifetime time.Duration `toml:"max-conn-lifetime" json:"max-conn-lifetime,omitempty" yaml:"max-conn-lifetime" mapstructure:"max-conn-lifetime"`
 	IdleTimeout     time.Duration `toml:"idle-timeout" json:"idle-timeout,omitempty" yaml:"idle-timeout" mapstructure:"idle-timeout"`
+	KeyPrefix       string        `toml:"key-prefix" json:"key-prefix,omitempty" yaml:"key-prefix" mapstructure:"key-prefix"`
+
+	// Breaker enables a circuit breaker on the pool, see CircuitBreaker.
+	Breaker *BreakerOptions `toml:"breaker" json:"breaker,omitempty" yaml:"breaker" mapstructure:"breaker"`
+
+	// ClientCache enables a client side cache of the pools registered by
+	// InitPool, InitMultiPools and ReconfigurePool, see ClientCacheOf. NewPool
+	// ignores it.
+	ClientCache *ClientCacheOptions `toml:"client-cache" json:"client-cache,omitempty" yaml:"client-cache" mapstructure:"client-cache"`
+
+	// Hooks are applied to every connection of the pool, see Hook.
+	Hooks []Hook `toml:"-" json:"-" yaml:"-" mapstructure:"-"`
 }
 
 type MultiDialConfig struct {
-	Name    string      `toml:"name"`
-	Default bool        `toml:"default"`
-	Config  *DialConfig `toml:"config"`
+	Name    string      `toml:"name" json:"name,omitempty" yaml:"name" mapstructure:"name"`
+	Default bool        `toml:"default" json:"default,omitempty" yaml:"default" mapstructure:"default"`
+	Config  *DialConfig `toml:"config" json:"config,omitempty" yaml:"config" mapstructure:"config"`
+	// KeyPrefix overrides `Config.KeyPrefix` when not empty.
+	KeyPrefix string `toml:"key-prefix" json:"key-prefix,omitempty" yaml:"key-prefix" mapstructure:"key-prefix"`
+	// Replicas of the redis of Config, read-only commands are balanced over
+	// them, see ReplicaGroup.
+	Replicas []*DialConfig `toml:"replicas" json:"replicas,omitempty" yaml:"replicas" mapstructure:"replicas"`
 }